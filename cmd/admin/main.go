@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/database"
+	"github.com/amidvn/go-metrics/internal/filestoring"
+	"github.com/amidvn/go-metrics/internal/storage"
+)
+
+type Config struct {
+	FilePath    string
+	DatabaseDSN string
+	Epsilon     float64
+	Steps       int
+}
+
+const usage = `Usage: admin <command> [flags]
+
+Commands:
+  import   replace database metrics with a file snapshot
+  export   write the database contents into a file snapshot
+  verify   compare a file snapshot with the database contents
+  migrate  manage database schema migrations: status, up or down
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "import":
+		err = runImport(os.Args[2:])
+	case "export":
+		err = runExport(os.Args[2:])
+	case "verify":
+		err = runVerify(os.Args[2:])
+	case "migrate":
+		err = runMigrate(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func getParameters(name string, args []string) (Config, error) {
+	var cfg Config
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&cfg.FilePath, "f", "/tmp/metrics-db.json", "file storage path of the snapshot")
+	fs.StringVar(&cfg.DatabaseDSN, "d", os.Getenv("DATABASE_DSN"), "database Data Source Name")
+	switch name {
+	case "verify":
+		fs.Float64Var(&cfg.Epsilon, "e", 1e-6, "allowed difference between gauge values")
+	case "migrate down":
+		fs.IntVar(&cfg.Steps, "n", 1, "number of migrations to revert")
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if cfg.DatabaseDSN == "" {
+		return cfg, fmt.Errorf("%s: database DSN is not set", name)
+	}
+	return cfg, nil
+}
+
+func connect(dsn string) (*database.DBConnection, error) {
+	// перенос данных целиком может длиться дольше обычного запроса,
+	// поэтому ограничение времени выполнения отключено
+	opts := database.DefaultOptions
+	opts.StatementTimeout = 0
+
+	dbc := database.New(dsn, opts)
+	if err := database.CheckConnection(context.Background(), dbc); err != nil {
+		dbc.Close()
+		return nil, err
+	}
+	return dbc, nil
+}
+
+func runImport(args []string) error {
+	cfg, err := getParameters("import", args)
+	if err != nil {
+		return err
+	}
+
+	data, err := filestoring.Load(cfg.FilePath)
+	if err != nil {
+		return err
+	}
+	s := fromSnapshot(data, cfg.FilePath)
+
+	dbc, err := connect(cfg.DatabaseDSN)
+	if err != nil {
+		return err
+	}
+	defer dbc.Close()
+
+	if _, err := database.Migrate(context.Background(), dbc); err != nil {
+		return err
+	}
+	if err := database.Replace(context.Background(), s, dbc); err != nil {
+		return err
+	}
+
+	fmt.Printf("imported %d counters and %d gauges from %s\n",
+		len(data.Counter), len(data.Gauge), cfg.FilePath)
+	return nil
+}
+
+func runExport(args []string) error {
+	cfg, err := getParameters("export", args)
+	if err != nil {
+		return err
+	}
+
+	dbc, err := connect(cfg.DatabaseDSN)
+	if err != nil {
+		return err
+	}
+	defer dbc.Close()
+
+	s := storage.New(0, cfg.FilePath, false)
+	if _, err := database.Restore(context.Background(), s, dbc); err != nil {
+		return err
+	}
+
+	if err := filestoring.Save(s, cfg.FilePath); err != nil {
+		return err
+	}
+
+	fmt.Printf("exported %d counters and %d gauges to %s\n",
+		len(s.GetCounterData()), len(s.GetGaugeData()), cfg.FilePath)
+	return nil
+}
+
+func runVerify(args []string) error {
+	cfg, err := getParameters("verify", args)
+	if err != nil {
+		return err
+	}
+
+	data, err := filestoring.Load(cfg.FilePath)
+	if err != nil {
+		return err
+	}
+	fromFile := fromSnapshot(data, cfg.FilePath)
+
+	dbc, err := connect(cfg.DatabaseDSN)
+	if err != nil {
+		return err
+	}
+	defer dbc.Close()
+
+	fromDB := storage.New(0, cfg.FilePath, false)
+	if _, err := database.Restore(context.Background(), fromDB, dbc); err != nil {
+		return err
+	}
+
+	diffs := compare(fromFile, fromDB, cfg.Epsilon)
+	for _, d := range diffs {
+		fmt.Println(d)
+	}
+	if len(diffs) != 0 {
+		return fmt.Errorf("verify: %d differences between %s and the database", len(diffs), cfg.FilePath)
+	}
+
+	fmt.Println("file snapshot and database are in sync")
+	return nil
+}
+
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return errors.New("migrate: expected status, up or down")
+	}
+	action := args[0]
+	cfg, err := getParameters("migrate "+action, args[1:])
+	if err != nil {
+		return err
+	}
+
+	dbc, err := connect(cfg.DatabaseDSN)
+	if err != nil {
+		return err
+	}
+	defer dbc.Close()
+
+	ctx := context.Background()
+	switch action {
+	case "up":
+		n, err := database.Migrate(ctx, dbc)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %d migrations\n", n)
+	case "down":
+		n, err := database.MigrateDown(ctx, dbc, cfg.Steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("reverted %d migrations\n", n)
+	case "status":
+		states, err := database.MigrationStatus(ctx, dbc)
+		if err != nil {
+			return err
+		}
+		for _, st := range states {
+			status := "pending"
+			if st.Applied {
+				status = "applied " + st.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", st.Version, st.Name, status)
+		}
+	default:
+		return fmt.Errorf("migrate: unknown action %q", action)
+	}
+	return nil
+}
+
+func fromSnapshot(data storage.AllMetrics, filePath string) *storage.MemStorage {
+	s := storage.New(0, filePath, false)
+	if data.Counter != nil {
+		s.UpdateCounterData(data.Counter)
+	}
+	if data.Gauge != nil {
+		s.UpdateGaugeData(data.Gauge)
+	}
+	return s
+}
+
+// compare возвращает описание всех расхождений между двумя хранилищами.
+func compare(file, db *storage.MemStorage, epsilon float64) []string {
+	var diffs []string
+
+	fileCounters, dbCounters := file.GetCounterData(), db.GetCounterData()
+	for _, n := range unionKeys(keys(fileCounters), keys(dbCounters)) {
+		fv, inFile := fileCounters[n]
+		dv, inDB := dbCounters[n]
+		switch {
+		case !inDB:
+			diffs = append(diffs, fmt.Sprintf("counter %s: missing in database (file=%d)", n, fv))
+		case !inFile:
+			diffs = append(diffs, fmt.Sprintf("counter %s: missing in file (database=%d)", n, dv))
+		case fv != dv:
+			diffs = append(diffs, fmt.Sprintf("counter %s: file=%d database=%d", n, fv, dv))
+		}
+	}
+
+	fileGauges, dbGauges := file.GetGaugeData(), db.GetGaugeData()
+	for _, n := range unionKeys(keys(fileGauges), keys(dbGauges)) {
+		fv, inFile := fileGauges[n]
+		dv, inDB := dbGauges[n]
+		switch {
+		case !inDB:
+			diffs = append(diffs, fmt.Sprintf("gauge %s: missing in database (file=%v)", n, fv))
+		case !inFile:
+			diffs = append(diffs, fmt.Sprintf("gauge %s: missing in file (database=%v)", n, dv))
+		case gaugesDiffer(float64(fv), float64(dv), epsilon):
+			diffs = append(diffs, fmt.Sprintf("gauge %s: file=%v database=%v", n, fv, dv))
+		}
+	}
+
+	return diffs
+}
+
+// gaugesDiffer сообщает, расходятся ли значения gauge больше чем на
+// epsilon. NaN совпадает только с NaN.
+func gaugesDiffer(a, b, epsilon float64) bool {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return math.IsNaN(a) != math.IsNaN(b)
+	}
+	return math.Abs(a-b) > epsilon
+}
+
+func keys[V any](m map[string]V) []string {
+	result := make([]string, 0, len(m))
+	for k := range m {
+		result = append(result, k)
+	}
+	return result
+}
+
+func unionKeys(a, b []string) []string {
+	seen := make(map[string]struct{}, len(a)+len(b))
+	var result []string
+	for _, k := range append(a, b...) {
+		if _, ok := seen[k]; !ok {
+			seen[k] = struct{}{}
+			result = append(result, k)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
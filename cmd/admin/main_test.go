@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/amidvn/go-metrics/internal/filestoring"
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompare(t *testing.T) {
+	file := storage.New(0, "", false)
+	file.UpdateCounter(context.Background(), "PollCount", 5)
+	file.UpdateCounter(context.Background(), "OnlyInFile", 1)
+	file.UpdateGauge(context.Background(), "Alloc", 1.0000001)
+	file.UpdateGauge(context.Background(), "HeapAlloc", 2)
+	file.UpdateGauge(context.Background(), "Ratio", math.NaN())
+	file.UpdateGauge(context.Background(), "Missing", math.NaN())
+	file.UpdateGauge(context.Background(), "Peak", math.Inf(1))
+
+	db := storage.New(0, "", false)
+	db.UpdateCounter(context.Background(), "PollCount", 5)
+	db.UpdateGauge(context.Background(), "Alloc", 1)
+	db.UpdateGauge(context.Background(), "HeapAlloc", 3)
+	db.UpdateGauge(context.Background(), "OnlyInDB", 4)
+	db.UpdateGauge(context.Background(), "Ratio", 0.5)
+	db.UpdateGauge(context.Background(), "Missing", math.NaN())
+	db.UpdateGauge(context.Background(), "Peak", math.Inf(1))
+
+	diffs := compare(file, db, 1e-6)
+	assert.Equal(t, []string{
+		"counter OnlyInFile: missing in database (file=1)",
+		"gauge HeapAlloc: file=2 database=3",
+		"gauge OnlyInDB: missing in file (database=4)",
+		"gauge Ratio: file=NaN database=0.5",
+	}, diffs)
+
+	assert.Empty(t, compare(db, db, 0))
+}
+
+func testSnapshot(t *testing.T) (*storage.MemStorage, string) {
+	s := storage.New(0, "", false)
+	s.UpdateCounter(context.Background(), "PollCount", 5)
+	s.UpdateCounter(context.Background(), `requests{code="200"}`, 12)
+	s.UpdateGauge(context.Background(), "Alloc", 1.5)
+	s.UpdateGauge(context.Background(), "HeapAlloc", 2.25)
+
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	require.NoError(t, filestoring.Save(s, path))
+	return s, path
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	s, path := testSnapshot(t)
+
+	data, err := filestoring.Load(path)
+	require.NoError(t, err)
+	assert.Empty(t, compare(fromSnapshot(data, path), s, 0))
+
+	// пустой снапшот превращается в пустое хранилище
+	empty := filepath.Join(t.TempDir(), "empty.json")
+	require.NoError(t, os.WriteFile(empty, []byte(`{}`), 0666))
+	data, err = filestoring.Load(empty)
+	require.NoError(t, err)
+	restored := fromSnapshot(data, empty)
+	assert.Empty(t, restored.GetCounterData())
+	assert.Empty(t, restored.GetGaugeData())
+}
+
+// TestDatabaseRoundTrip пропускается, если не задана переменная
+// TEST_DATABASE_DSN. Тест очищает таблицы метрик в этой базе.
+func TestDatabaseRoundTrip(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	s, path := testSnapshot(t)
+	require.NoError(t, runImport([]string{"-f", path, "-d", dsn}))
+	require.NoError(t, runVerify([]string{"-f", path, "-d", dsn}))
+
+	exported := filepath.Join(t.TempDir(), "exported.json")
+	require.NoError(t, runExport([]string{"-f", exported, "-d", dsn}))
+	data, err := filestoring.Load(exported)
+	require.NoError(t, err)
+	assert.Empty(t, compare(fromSnapshot(data, exported), s, 0))
+
+	// расхождение с базой делает verify неуспешным
+	s.UpdateCounter(context.Background(), "PollCount", 1)
+	require.NoError(t, filestoring.Save(s, path))
+	assert.Error(t, runVerify([]string{"-f", path, "-d", dsn}))
+}
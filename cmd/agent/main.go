@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -12,21 +13,28 @@ import (
 	"runtime"
 	"time"
 
+	"github.com/amidvn/go-metrics/internal/grpcapi"
 	"github.com/amidvn/go-metrics/internal/models"
 	"github.com/caarlos0/env/v6"
 	"github.com/hashicorp/go-retryablehttp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type Config struct {
 	PollInterval   int    `env:"POLL_INTERVAL"`
 	ReportInterval int    `env:"REPORT_INTERVAL"`
 	AddressServer  string `env:"ADDRESS"`
+	// способ отправки метрик: http или grpc
+	Transport   string `env:"TRANSPORT"`
+	GRPCAddress string `env:"GRPC_ADDRESS"`
 }
 
 const (
 	retryMax     int           = 3
 	retryWaitMin time.Duration = time.Second * 1
 	retryWaitMax time.Duration = time.Second * 5
+	grpcTimeout  time.Duration = time.Second * 5
 )
 
 var cfg Config
@@ -34,12 +42,22 @@ var cfg Config
 var valuesGauge = map[string]float64{}
 var pollCount uint64
 
+var grpcClient *grpcapi.Client
+
 func main() {
 	err := getParameters()
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if cfg.Transport == "grpc" {
+		grpcClient, err = grpcapi.Dial(cfg.GRPCAddress)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer grpcClient.Close()
+	}
+
 	pollTicker := time.NewTicker(time.Duration(cfg.PollInterval) * time.Second)
 	defer pollTicker.Stop()
 	reportTicker := time.NewTicker(time.Duration(cfg.ReportInterval) * time.Second)
@@ -59,6 +77,8 @@ func getParameters() error {
 	flag.StringVar(&cfg.AddressServer, "a", "localhost:8080", "address and port to run server")
 	flag.IntVar(&cfg.ReportInterval, "r", 10, "report interval in seconds")
 	flag.IntVar(&cfg.PollInterval, "p", 2, "poll interval in seconds")
+	flag.StringVar(&cfg.Transport, "t", "http", "transport for sending metrics: http or grpc")
+	flag.StringVar(&cfg.GRPCAddress, "g", "localhost:3200", "address and port of the server gRPC API")
 	flag.Parse()
 
 	err := env.Parse(&cfg)
@@ -66,6 +86,9 @@ func getParameters() error {
 		fmt.Println(err)
 	}
 
+	if cfg.Transport != "http" && cfg.Transport != "grpc" {
+		return fmt.Errorf("unknown transport %q, can only be 'http' or 'grpc'", cfg.Transport)
+	}
 	return nil
 }
 
@@ -104,6 +127,22 @@ func getMetrics() {
 }
 
 func postQueries() {
+	metrics := make([]models.Metrics, 0, len(valuesGauge)+2)
+	for k, v := range valuesGauge {
+		v := v
+		metrics = append(metrics, models.Metrics{ID: k, MType: "gauge", Value: &v})
+	}
+	pc := int64(pollCount)
+	metrics = append(metrics, models.Metrics{ID: "PollCount", MType: "counter", Delta: &pc})
+	r := rand.Float64()
+	metrics = append(metrics, models.Metrics{ID: "RandomValue", MType: "gauge", Value: &r})
+	pollCount = 0
+
+	if cfg.Transport == "grpc" {
+		postGRPC(grpcClient, metrics)
+		return
+	}
+
 	url := fmt.Sprintf("http://%s/update/", cfg.AddressServer)
 
 	retryClient := retryablehttp.NewClient()
@@ -112,14 +151,28 @@ func postQueries() {
 	retryClient.RetryWaitMax = retryWaitMax
 	retryClient.Backoff = linearBackoff
 
-	for k, v := range valuesGauge {
-		postJSON(retryClient, url, models.Metrics{ID: k, MType: "gauge", Value: &v})
+	for _, m := range metrics {
+		postJSON(retryClient, url, m)
+	}
+}
+
+// postGRPC отправляет метрики одним пакетом. Если сервер недоступен,
+// попытка повторяется с теми же паузами, что и для HTTP. После истечения
+// времени ожидания пакет не повторяется: сервер мог уже его сохранить.
+func postGRPC(c *grpcapi.Client, metrics []models.Metrics) {
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), grpcTimeout)
+		_, err := c.Updates(ctx, metrics)
+		cancel()
+
+		if err == nil || attempt == retryMax || status.Code(err) != codes.Unavailable {
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+		time.Sleep(linearBackoff(retryWaitMin, retryWaitMax, attempt, nil))
 	}
-	pc := int64(pollCount)
-	postJSON(retryClient, url, models.Metrics{ID: "PollCount", MType: "counter", Delta: &pc})
-	r := rand.Float64()
-	postJSON(retryClient, url, models.Metrics{ID: "RandomValue", MType: "gauge", Value: &r})
-	pollCount = 0
 }
 
 func postJSON(r *retryablehttp.Client, url string, m models.Metrics) {
@@ -7,7 +7,10 @@ import (
 )
 
 func main() {
-	s := apiserver.New()
+	s, err := apiserver.New()
+	if err != nil {
+		log.Fatal(err)
+	}
 	if err := s.Start(); err != nil {
 		log.Fatal(err)
 	}
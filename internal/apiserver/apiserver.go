@@ -1,14 +1,27 @@
 package apiserver
 
 import (
+	"context"
+	"errors"
+	"expvar"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/amidvn/go-metrics/internal/database"
 	"github.com/amidvn/go-metrics/internal/filestoring"
+	"github.com/amidvn/go-metrics/internal/graphite"
+	"github.com/amidvn/go-metrics/internal/grpcapi"
 	"github.com/amidvn/go-metrics/internal/handlers"
+	"github.com/amidvn/go-metrics/internal/kvstorage"
 	"github.com/amidvn/go-metrics/internal/middlewares"
+	"github.com/amidvn/go-metrics/internal/statsd"
 	"github.com/amidvn/go-metrics/internal/storage"
 	"github.com/caarlos0/env/v6"
 	"github.com/labstack/echo/v4"
@@ -16,24 +29,70 @@ import (
 	"go.uber.org/zap"
 )
 
+// как часто сбрасывать историю на диск и обслуживать сегменты, в секундах
+const historyMaintainInterval = 10
+
+const shutdownTimeout = 5 * time.Second
+
 type Conf struct {
 	Address       string `env:"ADDRESS"`
 	StoreInterval int    `env:"STORE_INTERVAL"`
 	FilePath      string `env:"FILE_STORAGE_PATH"`
 	Restore       bool   `env:"RESTORE"`
+	RestoreStrict bool   `env:"RESTORE_STRICT"`
 	DatabaseDSN   string `env:"DATABASE_DSN"`
+	// файл встроенной базы ключ-значение, в которую сразу пишется каждое обновление
+	KVPath string `env:"KV_STORAGE_PATH"`
+	// работа с базой данных напрямую, без промежуточного хранения в памяти
+	DatabaseWriteThrough bool          `env:"DATABASE_WRITE_THROUGH"`
+	DatabaseCacheTTL     time.Duration `env:"DATABASE_CACHE_TTL"`
+	// обмен изменениями с другими серверами, работающими с той же базой
+	DatabasePropagate bool `env:"DATABASE_PROPAGATE"`
+	// пул соединений с базой данных
+	DatabaseMaxConns         int           `env:"DATABASE_MAX_CONNS"`
+	DatabaseMinConns         int           `env:"DATABASE_MIN_CONNS"`
+	DatabaseConnLifetime     time.Duration `env:"DATABASE_CONN_LIFETIME"`
+	DatabaseConnIdleTime     time.Duration `env:"DATABASE_CONN_IDLE_TIME"`
+	DatabaseStatementTimeout time.Duration `env:"DATABASE_STATEMENT_TIMEOUT"`
+	// история значений серий
+	HistoryPath      string        `env:"HISTORY_PATH"`
+	HistoryRetention time.Duration `env:"HISTORY_RETENTION"`
+	DatabaseHistory  bool          `env:"DATABASE_HISTORY"`
+	// приём метрик по протоколу Graphite plaintext
+	GraphiteAddress   string `env:"GRAPHITE_ADDRESS"`
+	GraphiteTemplates string `env:"GRAPHITE_TEMPLATES"`
+	// приём метрик по протоколу StatsD
+	StatsdAddress       string        `env:"STATSD_ADDRESS"`
+	StatsdFlushInterval time.Duration `env:"STATSD_FLUSH_INTERVAL"`
+	// адрес API по gRPC
+	GRPCAddress string `env:"GRPC_ADDRESS"`
+}
+
+// recorder — хранилище, умеющее записывать историю обновлений.
+type recorder interface {
+	SetHistory(h storage.History)
 }
 
 type APIServer struct {
-	storage *storage.MemStorage
+	storage storage.Storage
+	// mem — хранилище в памяти, которое периодически сохраняется в файл
+	// или базу данных; nil, если база данных используется напрямую
+	mem     *storage.MemStorage
 	echo    *echo.Echo
 	address string
 	logger  zap.SugaredLogger
 	config  *Conf
 	db      *database.DBConnection
+	history storage.History
+	// graphite — приём метрик по протоколу Graphite; nil, если выключен
+	graphite *graphite.Server
+	// statsd — приём метрик по протоколу StatsD; nil, если выключен
+	statsd *statsd.Server
+	// grpc — API по gRPC; nil, если выключено
+	grpc *grpcapi.Server
 }
 
-func New() *APIServer {
+func New() (*APIServer, error) {
 	a := &APIServer{}
 
 	var conf Conf
@@ -42,6 +101,24 @@ func New() *APIServer {
 	flag.StringVar(&conf.FilePath, "f", "/tmp/metrics-db.json", "file storage path for saving data")
 	flag.BoolVar(&conf.Restore, "r", true, "need to load data at startup")
 	flag.StringVar(&conf.DatabaseDSN, "d", "", "database Data Source Name")
+	flag.StringVar(&conf.KVPath, "k", "", "embedded key-value storage file, every update is persisted immediately")
+	flag.BoolVar(&conf.DatabaseWriteThrough, "db-write-through", false, "read and write metrics directly in the database")
+	flag.BoolVar(&conf.DatabasePropagate, "db-propagate", false, "exchange metric updates with other servers using the same database")
+	flag.DurationVar(&conf.DatabaseCacheTTL, "db-cache-ttl", 0, "how long to cache values read from the database, 0 to disable")
+	flag.IntVar(&conf.DatabaseMaxConns, "db-max-conns", int(database.DefaultOptions.MaxConns), "maximum number of database connections")
+	flag.IntVar(&conf.DatabaseMinConns, "db-min-conns", int(database.DefaultOptions.MinConns), "number of database connections kept open")
+	flag.DurationVar(&conf.DatabaseConnLifetime, "db-conn-lifetime", database.DefaultOptions.MaxConnLifetime, "how long a database connection may be reused")
+	flag.DurationVar(&conf.DatabaseConnIdleTime, "db-conn-idle-time", database.DefaultOptions.MaxConnIdleTime, "how long an idle database connection is kept")
+	flag.DurationVar(&conf.DatabaseStatementTimeout, "db-statement-timeout", database.DefaultOptions.StatementTimeout, "database query timeout, 0 to disable")
+	flag.BoolVar(&conf.RestoreStrict, "restore-strict", false, "abort startup if saved data cannot be loaded")
+	flag.StringVar(&conf.HistoryPath, "history-path", "", "directory for series history segments, empty to disable history")
+	flag.BoolVar(&conf.DatabaseHistory, "db-history", false, "keep series history in the database")
+	flag.DurationVar(&conf.HistoryRetention, "history-retention", filestoring.DefaultSegmentOptions.Retention, "how long to keep series history")
+	flag.StringVar(&conf.GraphiteAddress, "graphite-address", "", "address for the Graphite plaintext TCP listener, empty to disable")
+	flag.StringVar(&conf.GraphiteTemplates, "graphite-templates", "", "semicolon separated templates mapping Graphite paths to metric names and labels")
+	flag.StringVar(&conf.StatsdAddress, "statsd-address", "", "address for the StatsD UDP listener, empty to disable")
+	flag.DurationVar(&conf.StatsdFlushInterval, "statsd-flush-interval", 10*time.Second, "how often aggregated StatsD metrics are written to the storage")
+	flag.StringVar(&conf.GRPCAddress, "grpc-address", "", "address for the gRPC API, empty to disable")
 	flag.Parse()
 
 	err := env.Parse(&conf)
@@ -52,10 +129,29 @@ func New() *APIServer {
 	a.address = conf.Address
 	a.config = &conf
 
-	a.storage = storage.New(conf.StoreInterval, conf.FilePath, conf.Restore)
 	a.echo = echo.New()
 
-	a.db = database.New(conf.DatabaseDSN)
+	a.db = database.New(conf.DatabaseDSN, database.Options{
+		MaxConns:         int32(conf.DatabaseMaxConns),
+		MinConns:         int32(conf.DatabaseMinConns),
+		MaxConnLifetime:  conf.DatabaseConnLifetime,
+		MaxConnIdleTime:  conf.DatabaseConnIdleTime,
+		StatementTimeout: conf.DatabaseStatementTimeout,
+	})
+
+	switch {
+	case a.db.Pool != nil && conf.DatabaseWriteThrough:
+		a.storage = database.NewPGStorage(a.db, conf.DatabaseCacheTTL)
+	case conf.KVPath != "":
+		kv, err := kvstorage.Open(conf.KVPath)
+		if err != nil {
+			return nil, err
+		}
+		a.storage = kv
+	default:
+		a.mem = storage.New(conf.StoreInterval, conf.FilePath, conf.Restore)
+		a.storage = a.mem
+	}
 
 	logger, err := zap.NewDevelopment()
 	if err != nil {
@@ -64,40 +160,229 @@ func New() *APIServer {
 	defer logger.Sync()
 
 	a.logger = *logger.Sugar()
+	a.db.SetLogger(&a.logger)
 
-	if a.db.DB != nil {
-		database.Restore(a.storage, a.db)
-		if conf.StoreInterval != 0 {
-			go database.Dump(a.storage, a.db, conf.StoreInterval)
+	if a.db.Pool != nil {
+		n, err := database.Migrate(context.Background(), a.db)
+		if err != nil {
+			return nil, fmt.Errorf("database migration failed: %w", err)
 		}
-	} else if conf.FilePath != "" {
-		if conf.Restore {
-			filestoring.Restore(a.storage, conf.FilePath)
+		if n != 0 {
+			a.logger.Infow("database migrated", "applied", n)
 		}
+	}
+
+	var propagator *database.Propagator
+	if a.mem != nil && a.db.Pool != nil && conf.DatabasePropagate {
+		propagator = database.NewPropagator(a.db, a.mem)
+	}
+
+	if err := a.restore(propagator); err != nil {
+		if conf.RestoreStrict {
+			return nil, err
+		}
+		a.logger.Errorw("starting with empty storage", "error", err)
+		// без восстановленного снимка применяются только новые изменения
+		if propagator != nil {
+			if err := propagator.Start(context.Background()); err != nil {
+				a.logger.Errorw("change propagation is disabled", "error", err)
+				propagator = nil
+			}
+		}
+	}
+
+	if propagator != nil {
+		a.mem.SetNotifier(propagator)
+		go propagator.Run(context.Background())
+	}
+
+	health := handlers.HealthSources{
+		Storage:      a.storage,
+		DB:           a.db,
+		DumpInterval: time.Duration(conf.StoreInterval) * time.Second,
+		Started:      time.Now(),
+	}
+	switch {
+	case a.mem == nil:
+	case propagator != nil:
+		// таблицы метрик обновляются вместе с журналом изменений
+		health.Dumps, health.DumpTarget = a.mem, "database"
+	case a.db.Pool != nil:
+		if conf.StoreInterval != 0 {
+			go database.Dump(a.mem, a.db, conf.StoreInterval)
+			health.Dumps, health.DumpTarget = a.mem, "database"
+		}
+	case conf.FilePath != "":
 		if conf.StoreInterval != 0 {
-			go filestoring.Dump(a.storage, conf.FilePath, conf.StoreInterval)
+			go filestoring.Dump(a.mem, conf.FilePath, conf.StoreInterval)
+			health.Dumps, health.DumpTarget = a.mem, "file"
+		}
+	}
+
+	if err := a.openHistory(); err != nil {
+		a.logger.Errorw("history is disabled", "error", err)
+	}
+
+	if conf.GraphiteAddress != "" {
+		templates, err := graphite.ParseTemplates(conf.GraphiteTemplates)
+		if err != nil {
+			return nil, err
+		}
+		a.graphite = graphite.NewServer(a.storage, templates, &a.logger)
+		if err := a.graphite.Listen(conf.GraphiteAddress); err != nil {
+			return nil, err
+		}
+		a.logger.Infow("graphite listener started", "address", conf.GraphiteAddress, "templates", len(templates))
+	}
+
+	if conf.StatsdAddress != "" {
+		if conf.StatsdFlushInterval <= 0 {
+			return nil, fmt.Errorf("invalid statsd flush interval %s", conf.StatsdFlushInterval)
+		}
+		a.statsd = statsd.NewServer(a.storage, conf.StatsdFlushInterval, &a.logger)
+		if err := a.statsd.Listen(conf.StatsdAddress); err != nil {
+			return nil, err
 		}
+		a.logger.Infow("statsd listener started", "address", conf.StatsdAddress, "flush_interval", conf.StatsdFlushInterval)
+	}
+
+	if conf.GRPCAddress != "" {
+		a.grpc = grpcapi.NewServer(a.storage, &a.logger)
+		if err := a.grpc.Listen(conf.GRPCAddress); err != nil {
+			return nil, err
+		}
+		a.logger.Infow("grpc server started", "address", conf.GRPCAddress)
 	}
 
 	a.echo.Use(middlewares.WithLogging(a.logger))
 	a.echo.Use(middlewares.GzipUnpacking())
 
 	a.echo.GET("/", handlers.AllMetrics(a.storage))
+	a.echo.GET("/metrics", handlers.PrometheusMetrics(a.storage))
+	a.echo.GET("/api/v1/metrics", handlers.ListMetrics(a.storage))
+	a.echo.GET("/api/v1/query", handlers.Query(a.storage, a.history))
+	a.echo.POST("/api/v1/query", handlers.Query(a.storage, a.history))
 	a.echo.POST("/value/", handlers.GetValueJSON(a.storage))
 	a.echo.GET("/value/:typeM/:nameM", handlers.MetricsValue(a.storage))
 	a.echo.POST("/update/", handlers.UpdateJSON(a.storage))
 	a.echo.POST("/update/:typeM/:nameM/:valueM", handlers.PostWebhook(a.storage))
 	a.echo.GET("/ping", handlers.PingDB(a.db))
+	a.echo.GET("/health", handlers.Health(health))
+	a.echo.GET("/health/live", handlers.Live())
+	a.echo.GET("/health/ready", handlers.Ready(health))
 	a.echo.POST("/updates/", handlers.UpdatesJSON(a.storage))
+	a.echo.POST("/api/v1/write", handlers.RemoteWrite(a.storage))
+	a.echo.POST("/write", handlers.InfluxWrite(a.storage))
+	a.echo.POST("/api/v2/write", handlers.InfluxWrite(a.storage))
+	a.echo.POST("/v1/metrics", handlers.OTLPMetrics(a.storage))
+	a.echo.GET("/debug/vars", echo.WrapHandler(expvar.Handler()))
+	if a.db.Pool != nil {
+		expvar.Publish("db_pool", expvar.Func(func() any { return a.db.Stats() }))
+	}
+	if a.history != nil {
+		a.echo.GET("/history/:typeM/:nameM", handlers.History(a.history))
+		a.echo.GET("/api/v1/query_range", handlers.QueryRange(a.storage, a.history))
+		a.echo.POST("/api/v1/query_range", handlers.QueryRange(a.storage, a.history))
+	}
+
+	return a, nil
+}
+
+// openHistory включает запись истории в базу данных или в сегментные
+// файлы, если это задано в конфигурации.
+func (a *APIServer) openHistory() error {
+	conf := a.config
+	switch {
+	case conf.DatabaseHistory && a.db.Pool != nil:
+		samples := database.NewSampleStore(a.db, conf.HistoryRetention)
+		go samples.Maintain(historyMaintainInterval)
+		a.history = samples
+		a.logger.Infow("history enabled", "backend", "database", "retention", conf.HistoryRetention)
+	case conf.HistoryPath != "":
+		opts := filestoring.DefaultSegmentOptions
+		opts.Retention = conf.HistoryRetention
+		segments, err := filestoring.OpenSegments(conf.HistoryPath, opts)
+		if err != nil {
+			return err
+		}
+		go segments.Maintain(historyMaintainInterval)
+		a.history = segments
+		a.logger.Infow("history enabled", "backend", "file", "path", conf.HistoryPath, "retention", conf.HistoryRetention)
+	default:
+		return nil
+	}
 
-	return a
+	a.storage.(recorder).SetHistory(a.history)
+	return nil
+}
+
+// restore загружает сохранённое состояние из базы данных или файла.
+// При обмене изменениями позиция журнала propagator берётся из того же
+// снимка базы.
+func (a *APIServer) restore(propagator *database.Propagator) error {
+	conf := a.config
+	switch {
+	case a.mem == nil:
+	case a.db.Pool != nil:
+		n, pos, err := database.RestoreSnapshot(context.Background(), a.mem, a.db)
+		if err != nil {
+			return err
+		}
+		if propagator != nil {
+			propagator.StartAt(pos)
+		}
+		a.logger.Infow("metrics restored", "source", "database", "series", n)
+	case conf.FilePath != "" && conf.Restore:
+		n, err := filestoring.Restore(a.mem, conf.FilePath)
+		if err != nil {
+			return err
+		}
+		a.logger.Infow("metrics restored", "source", "file", "path", conf.FilePath, "series", n)
+	}
+	return nil
 }
 
 func (a *APIServer) Start() error {
-	err := a.echo.Start(a.address)
-	if err != nil {
-		log.Fatal(err)
+	go func() {
+		err := a.echo.Start(a.address)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := a.echo.Shutdown(ctx); err != nil {
+		return err
+	}
+	if a.grpc != nil {
+		if err := a.grpc.Shutdown(ctx); err != nil {
+			return err
+		}
 	}
+	if a.graphite != nil {
+		if err := a.graphite.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	if a.statsd != nil {
+		if err := a.statsd.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	defer a.db.Close()
 
+	if c, ok := a.history.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			return err
+		}
+	}
+	if c, ok := a.storage.(io.Closer); ok {
+		return c.Close()
+	}
 	return nil
 }
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"context"
+
+	"github.com/amidvn/go-metrics/internal/models"
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/jackc/pgx/v5"
+)
+
+// начиная с такого размера пакеты загружаются через COPY: на меньших
+// пакетах создание промежуточной таблицы обходится дороже самих запросов
+const copyThreshold = 64
+
+const (
+	// промежуточная таблица живёт до конца сеанса и очищается после
+	// каждой транзакции, поэтому создаётся один раз на соединение
+	createStagingQuery = `CREATE TEMP TABLE IF NOT EXISTS metrics_staging (
+		seq integer NOT NULL,
+		name text NOT NULL,
+		type text NOT NULL,
+		delta bigint,
+		value double precision
+	) ON COMMIT DELETE ROWS;`
+	// несколько приращений одного счётчика в пакете складываются
+	mergeCountersQuery = `INSERT INTO counter_metrics (name, value)
+		SELECT name, sum(delta)::bigint FROM metrics_staging WHERE type = 'counter' GROUP BY name
+		ON CONFLICT (name) DO UPDATE SET value = counter_metrics.value + EXCLUDED.value
+		RETURNING name, value;`
+	// из нескольких значений gauge в пакете остаётся последнее
+	mergeGaugesQuery = `INSERT INTO gauge_metrics (name, value)
+		SELECT DISTINCT ON (name) name, value FROM metrics_staging WHERE type = 'gauge' ORDER BY name, seq DESC
+		ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value
+		RETURNING name, value;`
+)
+
+var stagingColumns = []string{"seq", "name", "type", "delta", "value"}
+
+// storeBatchCopy загружает пакет в промежуточную таблицу через COPY
+// и переносит его в таблицы метрик двумя запросами. Возвращает итоговые
+// значения изменённых серий.
+func (s *PGStorage) storeBatchCopy(ctx context.Context, metrics []models.Metrics) ([]storage.Sample, error) {
+	tx, err := s.dbc.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(context.Background())
+
+	if _, err := tx.Exec(ctx, createStagingQuery); err != nil {
+		return nil, err
+	}
+
+	rows := make([][]any, 0, len(metrics))
+	for i, m := range metrics {
+		switch m.MType {
+		case "counter":
+			rows = append(rows, []any{i, m.ID, m.MType, *m.Delta, nil})
+		case "gauge":
+			rows = append(rows, []any{i, m.ID, m.MType, nil, *m.Value})
+		}
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"metrics_staging"}, stagingColumns, pgx.CopyFromRows(rows))
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []storage.Sample
+	counters, err := tx.Query(ctx, mergeCountersQuery)
+	if err != nil {
+		return nil, err
+	}
+	for counters.Next() {
+		smp := storage.Sample{MType: "counter"}
+		var total int64
+		if err := counters.Scan(&smp.Name, &total); err != nil {
+			counters.Close()
+			return nil, err
+		}
+		smp.Value = float64(total)
+		samples = append(samples, smp)
+	}
+	if err := counters.Err(); err != nil {
+		return nil, err
+	}
+
+	gauges, err := tx.Query(ctx, mergeGaugesQuery)
+	if err != nil {
+		return nil, err
+	}
+	for gauges.Next() {
+		smp := storage.Sample{MType: "gauge"}
+		if err := gauges.Scan(&smp.Name, &smp.Value); err != nil {
+			gauges.Close()
+			return nil, err
+		}
+		samples = append(samples, smp)
+	}
+	if err := gauges.Err(); err != nil {
+		return nil, err
+	}
+
+	return samples, tx.Commit(ctx)
+}
+
+// copySamples дописывает значения в историю одной командой COPY.
+// Партиционированная таблица сама раскладывает строки по партициям.
+func (s *SampleStore) copySamples(ctx context.Context, samples []storage.Sample) error {
+	_, err := s.dbc.Pool.CopyFrom(ctx, pgx.Identifier{samplesTable},
+		[]string{"series", "type", "ts", "value"},
+		pgx.CopyFromSlice(len(samples), func(i int) ([]any, error) {
+			smp := samples[i]
+			return []any{smp.Name, smp.MType, smp.Timestamp, smp.Value}, nil
+		}))
+	return err
+}
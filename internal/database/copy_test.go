@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/models"
+	"github.com/amidvn/go-metrics/internal/storage"
+)
+
+// Бенчмарки и тесты с настоящей базой данных пропускаются, если
+// не задана переменная TEST_DATABASE_DSN:
+//
+//	TEST_DATABASE_DSN=postgres://... go test -bench . ./internal/database
+func testDB(b testing.TB) *DBConnection {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		b.Skip("TEST_DATABASE_DSN is not set")
+	}
+	dbc := New(dsn, DefaultOptions)
+	b.Cleanup(dbc.Close)
+	if _, err := Migrate(context.Background(), dbc); err != nil {
+		b.Fatal(err)
+	}
+	return dbc
+}
+
+func benchBatch(size int) []models.Metrics {
+	metrics := make([]models.Metrics, 0, size)
+	for i := 0; i < size; i++ {
+		delta := int64(i)
+		value := float64(i) / 3
+		if i%2 == 0 {
+			metrics = append(metrics, models.Metrics{ID: fmt.Sprintf("bench_counter_%d", i), MType: "counter", Delta: &delta})
+		} else {
+			metrics = append(metrics, models.Metrics{ID: fmt.Sprintf("bench_gauge_%d", i), MType: "gauge", Value: &value})
+		}
+	}
+	return metrics
+}
+
+func BenchmarkStoreBatch(b *testing.B) {
+	s := NewPGStorage(testDB(b), 0)
+	ctx := context.Background()
+
+	methods := []struct {
+		name  string
+		store func(ctx context.Context, metrics []models.Metrics) ([]storage.Sample, error)
+	}{
+		{name: "rows", store: s.storeBatchRows},
+		{name: "copy", store: s.storeBatchCopy},
+	}
+	for _, size := range []int{10, 100, 1000, 10000} {
+		metrics := benchBatch(size)
+		for _, m := range methods {
+			b.Run(fmt.Sprintf("%s/%d", m.name, size), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := m.store(ctx, metrics); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(size*b.N)/b.Elapsed().Seconds(), "metrics/s")
+			})
+		}
+	}
+}
+
+func BenchmarkAppendSamples(b *testing.B) {
+	st := NewSampleStore(testDB(b), 0)
+	ctx := context.Background()
+	now := time.Now()
+	if err := st.ensurePartition(ctx, now); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, size := range []int{10, 100, 1000, 10000} {
+		samples := make([]storage.Sample, size)
+		for i := range samples {
+			samples[i] = storage.Sample{Name: fmt.Sprintf("bench_%d", i), MType: "gauge", Timestamp: now, Value: float64(i)}
+		}
+		b.Run(fmt.Sprintf("unnest/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := st.insertSamples(ctx, samples); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(size*b.N)/b.Elapsed().Seconds(), "samples/s")
+		})
+		b.Run(fmt.Sprintf("copy/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := st.copySamples(ctx, samples); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(size*b.N)/b.Elapsed().Seconds(), "samples/s")
+		})
+	}
+}
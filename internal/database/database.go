@@ -2,18 +2,52 @@ package database
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/amidvn/go-metrics/internal/storage"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/pkg/errors"
+	"go.uber.org/zap"
 )
 
 type DBConnection struct {
-	DB *sql.DB
+	Pool   *pgxpool.Pool
+	logger *zap.SugaredLogger
+
+	// ограничение времени одной попытки запроса на стороне клиента
+	statementTimeout time.Duration
+}
+
+// Options — настройки пула соединений.
+type Options struct {
+	MaxConns        int32
+	MinConns        int32
+	MaxConnLifetime time.Duration
+	MaxConnIdleTime time.Duration
+	// StatementTimeout ограничивает время выполнения запроса и на сервере
+	// (statement_timeout), и на клиенте; 0 — без ограничения.
+	StatementTimeout time.Duration
+}
+
+var DefaultOptions = Options{
+	MaxConns:         10,
+	MaxConnLifetime:  time.Hour,
+	MaxConnIdleTime:  30 * time.Minute,
+	StatementTimeout: 10 * time.Second,
+}
+
+// PoolStats — состояние пула соединений.
+type PoolStats struct {
+	AcquiredConns     int32         `json:"acquired_conns"`
+	IdleConns         int32         `json:"idle_conns"`
+	TotalConns        int32         `json:"total_conns"`
+	MaxConns          int32         `json:"max_conns"`
+	AcquireCount      int64         `json:"acquire_count"`
+	EmptyAcquireCount int64         `json:"empty_acquire_count"`
+	AcquireDuration   time.Duration `json:"acquire_duration_ns"`
 }
 
 type counterMetric struct {
@@ -26,34 +60,91 @@ type gaugeMetric struct {
 	value float64
 }
 
-func New(dsn string) *DBConnection {
-	dbc := &DBConnection{}
+func New(dsn string, opts Options) *DBConnection {
+	dbc := &DBConnection{logger: zap.NewNop().Sugar(), statementTimeout: opts.StatementTimeout}
 
 	if dsn == "" {
-		dbc.DB = nil
+		dbc.Pool = nil
 		return dbc
 	}
 
-	db, err := sql.Open("pgx", dsn)
+	cfg, err := poolConfig(dsn, opts)
 	if err != nil {
 		fmt.Println(err)
-		dbc.DB = nil
+		dbc.Pool = nil
 		return dbc
-	} else {
-		dbc.DB = db
 	}
 
-	// checkint if tables exist or not
-	if dbc.DB != nil {
-		dbc.DB.Exec("CREATE TABLE IF NOT EXISTS counter_metrics (name char(30) UNIQUE, value integer);")
-		dbc.DB.Exec("CREATE TABLE IF NOT EXISTS gauge_metrics (name char(30) UNIQUE, value double precision);")
+	// пул подключается лениво, поэтому недоступная база не мешает запуску
+	pool, err := pgxpool.NewWithConfig(context.Background(), cfg)
+	if err != nil {
+		fmt.Println(err)
+		dbc.Pool = nil
+		return dbc
+	} else {
+		dbc.Pool = pool
 	}
+
 	return dbc
 }
 
-func CheckConnection(dbc *DBConnection) error {
-	if dbc.DB != nil {
-		err := dbc.DB.Ping()
+func poolConfig(dsn string, opts Options) (*pgxpool.Config, error) {
+	cfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxConns > 0 {
+		cfg.MaxConns = opts.MaxConns
+	}
+	if opts.MinConns > 0 {
+		cfg.MinConns = opts.MinConns
+	}
+	if opts.MaxConnLifetime > 0 {
+		cfg.MaxConnLifetime = opts.MaxConnLifetime
+	}
+	if opts.MaxConnIdleTime > 0 {
+		cfg.MaxConnIdleTime = opts.MaxConnIdleTime
+	}
+	if opts.StatementTimeout > 0 {
+		cfg.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(opts.StatementTimeout.Milliseconds(), 10)
+	}
+	return cfg, nil
+}
+
+// Close закрывает все соединения пула.
+func (dbc *DBConnection) Close() {
+	if dbc.Pool != nil {
+		dbc.Pool.Close()
+	}
+}
+
+// Stats возвращает состояние пула соединений.
+func (dbc *DBConnection) Stats() PoolStats {
+	if dbc.Pool == nil {
+		return PoolStats{}
+	}
+	st := dbc.Pool.Stat()
+	return PoolStats{
+		AcquiredConns:     st.AcquiredConns(),
+		IdleConns:         st.IdleConns(),
+		TotalConns:        st.TotalConns(),
+		MaxConns:          st.MaxConns(),
+		AcquireCount:      st.AcquireCount(),
+		EmptyAcquireCount: st.EmptyAcquireCount(),
+		AcquireDuration:   st.AcquireDuration(),
+	}
+}
+
+// SetLogger задаёт логгер, в который пишутся повторы операций.
+func (dbc *DBConnection) SetLogger(logger *zap.SugaredLogger) {
+	dbc.logger = logger
+}
+
+func CheckConnection(ctx context.Context, dbc *DBConnection) error {
+	if dbc.Pool != nil {
+		err := dbc.withRetry(ctx, "ping", func(ctx context.Context) error {
+			return dbc.Pool.Ping(ctx)
+		})
 		if err != nil {
 			return err
 		}
@@ -62,76 +153,230 @@ func CheckConnection(dbc *DBConnection) error {
 	return errors.New("Empty connection string")
 }
 
-func Restore(s *storage.MemStorage, dbc *DBConnection) {
-	if dbc.DB == nil {
-		return
+// Ping проверяет соединение с базой данных один раз, без повторов,
+// и возвращает время ответа.
+func Ping(ctx context.Context, dbc *DBConnection) (time.Duration, error) {
+	if dbc.Pool == nil {
+		return 0, errors.New("Empty connection string")
+	}
+	start := time.Now()
+	err := dbc.Pool.Ping(ctx)
+	return time.Since(start), err
+}
+
+// Restore загружает метрики из базы данных в хранилище и возвращает
+// количество восстановленных серий. Хранилище изменяется, только если
+// обе таблицы прочитаны без ошибок.
+func Restore(ctx context.Context, s *storage.MemStorage, dbc *DBConnection) (int, error) {
+	n, _, err := restore(ctx, s, dbc, false)
+	return n, err
+}
+
+// RestoreSnapshot — то же, что Restore, но таблицы метрик и позиция
+// журнала изменений читаются из одного снимка базы. Propagator записывает
+// изменения в журнал и в таблицы одним запросом, поэтому записи, видимые
+// в снимке, уже учтены в таблицах, а с возвращённой позиции через StartAt
+// применяются только остальные.
+func RestoreSnapshot(ctx context.Context, s *storage.MemStorage, dbc *DBConnection) (int, ChangePosition, error) {
+	return restore(ctx, s, dbc, true)
+}
+
+func restore(ctx context.Context, s *storage.MemStorage, dbc *DBConnection, withChanges bool) (int, ChangePosition, error) {
+	var pos ChangePosition
+	if dbc.Pool == nil {
+		return 0, pos, nil
 	}
 
-	ctx := context.Background()
-	rowsCounter, err := dbc.DB.QueryContext(ctx, "SELECT name, value FROM counter_metrics;")
+	var (
+		counters []counterMetric
+		gauges   []gaugeMetric
+	)
+	err := dbc.withRetry(ctx, "restore metrics", func(ctx context.Context) error {
+		tx, err := dbc.Pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(context.Background())
+
+		if withChanges {
+			if pos, err = readPosition(ctx, tx); err != nil {
+				return fmt.Errorf("change position: %w", err)
+			}
+		}
+		if counters, err = loadCounters(ctx, tx); err != nil {
+			return fmt.Errorf("restore counters: %w", err)
+		}
+		if gauges, err = loadGauges(ctx, tx); err != nil {
+			return fmt.Errorf("restore gauges: %w", err)
+		}
+		return tx.Commit(ctx)
+	})
 	if err != nil {
-		fmt.Println(err)
+		return 0, pos, err
 	}
-	if err := rowsCounter.Err(); err != nil {
-		fmt.Println(err)
+
+	counterValues := make(map[string]int64, len(counters))
+	for _, cm := range counters {
+		counterValues[cm.name] = cm.value
+	}
+	gaugeValues := make(map[string]float64, len(gauges))
+	for _, gm := range gauges {
+		gaugeValues[gm.name] = gm.value
 	}
-	defer rowsCounter.Close()
+	s.Load(counterValues, gaugeValues)
+	return len(counters) + len(gauges), pos, nil
+}
+
+// querier — пул соединений или транзакция.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func loadCounters(ctx context.Context, q querier) ([]counterMetric, error) {
+	rows, err := q.Query(ctx, "SELECT name, value FROM counter_metrics;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	for rowsCounter.Next() {
+	var result []counterMetric
+	for rows.Next() {
 		var cm counterMetric
-		err = rowsCounter.Scan(&cm.name, &cm.value)
-		if err != nil {
-			fmt.Println(err)
+		if err := rows.Scan(&cm.name, &cm.value); err != nil {
+			return nil, err
 		}
-		s.UpdateCounter(strings.ReplaceAll(cm.name, " ", ""), cm.value)
+		result = append(result, cm)
 	}
+	return result, rows.Err()
+}
 
-	rowsGauge, err := dbc.DB.QueryContext(ctx, "SELECT name, value FROM gauge_metrics;")
+func loadGauges(ctx context.Context, q querier) ([]gaugeMetric, error) {
+	rows, err := q.Query(ctx, "SELECT name, value FROM gauge_metrics;")
 	if err != nil {
-		fmt.Println(err)
-	}
-	if err := rowsGauge.Err(); err != nil {
-		fmt.Println(err)
+		return nil, err
 	}
-	defer rowsGauge.Close()
+	defer rows.Close()
 
-	for rowsGauge.Next() {
+	var result []gaugeMetric
+	for rows.Next() {
 		var gm gaugeMetric
-		err = rowsGauge.Scan(&gm.name, &gm.value)
-		if err != nil {
-			fmt.Println(err)
+		if err := rows.Scan(&gm.name, &gm.value); err != nil {
+			return nil, err
 		}
-		s.UpdateGauge(strings.ReplaceAll(gm.name, " ", ""), gm.value)
+		result = append(result, gm)
 	}
+	return result, rows.Err()
 }
 
 func Dump(s *storage.MemStorage, dbc *DBConnection, storeInterval int) {
 	pollTicker := time.NewTicker(time.Duration(storeInterval) * time.Second)
 	defer pollTicker.Stop()
 	for range pollTicker.C {
-		saveMetrics(s, dbc)
+		counters, gauges := s.TakeChanges()
+		if len(counters) == 0 && len(gauges) == 0 {
+			// сохранять нечего — в базе уже актуальное состояние
+			s.SetDumpResult(nil)
+			continue
+		}
+		err := saveMetrics(context.Background(), dbc, counters, gauges)
+		if err != nil {
+			fmt.Println(err)
+			s.RequeueChanges(counters, gauges)
+		}
+		s.SetDumpResult(err)
 	}
 }
 
-func saveMetrics(s *storage.MemStorage, dbc *DBConnection) error {
-	tx, err := dbc.DB.Begin()
-	if err != nil {
-		return err
+// Save записывает все серии хранилища в базу данных, обновляя
+// уже сохранённые значения.
+func Save(ctx context.Context, s *storage.MemStorage, dbc *DBConnection) error {
+	if dbc.Pool == nil {
+		return errors.New("Empty connection string")
+	}
+
+	counters := make(map[string]int64)
+	for n, v := range s.GetCounterData() {
+		counters[n] = int64(v)
+	}
+	gauges := make(map[string]float64)
+	for n, v := range s.GetGaugeData() {
+		gauges[n] = float64(v)
 	}
-	var query string
-	query = "TRUNCATE counter_metrics, gauge_metrics; "
-	for k, v := range s.GetCounterData() {
-		query += fmt.Sprintf("INSERT INTO counter_metrics (name, value) VALUES ('%s', %d); ", k, v)
+	return saveMetrics(ctx, dbc, counters, gauges)
+}
+
+// Replace заменяет содержимое таблиц метрик сериями хранилища: таблицы
+// очищаются и заполняются через COPY в одной транзакции, поэтому серии,
+// которых нет в хранилище, удаляются.
+func Replace(ctx context.Context, s *storage.MemStorage, dbc *DBConnection) error {
+	if dbc.Pool == nil {
+		return errors.New("Empty connection string")
 	}
 
-	for k, v := range s.GetGaugeData() {
-		query += fmt.Sprintf("INSERT INTO gauge_metrics (name, value) VALUES ('%s', %f); ", k, v)
+	counters := make([][]any, 0)
+	for n, v := range s.GetCounterData() {
+		counters = append(counters, []any{n, int64(v)})
+	}
+	gauges := make([][]any, 0)
+	for n, v := range s.GetGaugeData() {
+		gauges = append(gauges, []any{n, float64(v)})
 	}
 
-	_, err = tx.Exec(query)
+	return dbc.withRetry(ctx, "replace metrics", func(ctx context.Context) error {
+		tx, err := dbc.Pool.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(context.Background())
+
+		if _, err := tx.Exec(ctx, "TRUNCATE counter_metrics, gauge_metrics;"); err != nil {
+			return err
+		}
+		columns := []string{"name", "value"}
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{"counter_metrics"}, columns, pgx.CopyFromRows(counters)); err != nil {
+			return err
+		}
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{"gauge_metrics"}, columns, pgx.CopyFromRows(gauges)); err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+}
+
+const (
+	upsertCounterQuery = `INSERT INTO counter_metrics (name, value) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value;`
+	upsertGaugeQuery = `INSERT INTO gauge_metrics (name, value) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value;`
+)
+
+// saveMetrics сохраняет переданные серии одной транзакцией.
+func saveMetrics(ctx context.Context, dbc *DBConnection, counters map[string]int64, gauges map[string]float64) error {
+	return dbc.withRetry(ctx, "save metrics", func(ctx context.Context) error {
+		return upsertMetrics(ctx, dbc, counters, gauges)
+	})
+}
+
+// upsertMetrics отправляет все обновления одним пакетом; pgx сам
+// подготавливает и кэширует запросы на соединении.
+func upsertMetrics(ctx context.Context, dbc *DBConnection, counters map[string]int64, gauges map[string]float64) error {
+	tx, err := dbc.Pool.Begin(ctx)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
-	return tx.Commit()
+	defer tx.Rollback(context.Background())
+
+	batch := &pgx.Batch{}
+	for n, v := range counters {
+		batch.Queue(upsertCounterQuery, n, v)
+	}
+	for n, v := range gauges {
+		batch.Queue(upsertGaugeQuery, n, v)
+	}
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
 }
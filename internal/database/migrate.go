@@ -0,0 +1,280 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// ключ advisory-блокировки, под которой выполняются миграции, чтобы
+// несколько серверов не мигрировали одну базу одновременно
+const migrationLockKey int64 = 0x6d6574726963
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState — состояние одной миграции в базе данных.
+type MigrationState struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// loadMigrations читает миграции из fsys и упорядочивает их по версии.
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, file := range files {
+		m := migrationName.FindStringSubmatch(path.Base(file))
+		if m == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", file)
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		body, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	result := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", mig.Version, mig.Name)
+		}
+		result = append(result, *mig)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
+
+// Migrate применяет все неприменённые миграции и возвращает их количество.
+func Migrate(ctx context.Context, dbc *DBConnection) (int, error) {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = withMigrationLock(ctx, dbc, func(conn *pgxpool.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			err := runMigration(ctx, conn, mig.Up,
+				"INSERT INTO schema_migrations (version, name) VALUES ($1, $2);", mig.Version, mig.Name)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// MigrateDown откатывает steps последних применённых миграций.
+func MigrateDown(ctx context.Context, dbc *DBConnection, steps int) (int, error) {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = withMigrationLock(ctx, dbc, func(conn *pgxpool.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+			mig := migrations[i]
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+			if mig.Down == "" {
+				return fmt.Errorf("migration %d_%s cannot be reverted", mig.Version, mig.Name)
+			}
+			err := runMigration(ctx, conn, mig.Down,
+				"DELETE FROM schema_migrations WHERE version = $1;", mig.Version)
+			if err != nil {
+				return fmt.Errorf("revert %d_%s: %w", mig.Version, mig.Name, err)
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// MigrationStatus возвращает все известные миграции с отметкой о применении.
+func MigrationStatus(ctx context.Context, dbc *DBConnection) ([]MigrationState, error) {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return nil, err
+	}
+	if dbc.Pool == nil {
+		return nil, errors.New("Empty connection string")
+	}
+
+	conn, err := dbc.Pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]MigrationState, 0, len(migrations))
+	for _, mig := range migrations {
+		at, ok := applied[mig.Version]
+		result = append(result, MigrationState{Migration: mig, Applied: ok, AppliedAt: at})
+	}
+	return result, nil
+}
+
+// SchemaVersion возвращает версию последней применённой миграции;
+// 0 — миграции ещё не применялись.
+func SchemaVersion(ctx context.Context, dbc *DBConnection) (int64, error) {
+	if dbc.Pool == nil {
+		return 0, errors.New("Empty connection string")
+	}
+
+	var version int64
+	err := dbc.withRetry(ctx, "schema version", func(ctx context.Context) error {
+		var exists bool
+		err := dbc.Pool.QueryRow(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL;").Scan(&exists)
+		if err != nil || !exists {
+			return err
+		}
+		return dbc.Pool.QueryRow(ctx, "SELECT coalesce(max(version), 0) FROM schema_migrations;").Scan(&version)
+	})
+	return version, err
+}
+
+// withMigrationLock выполняет fn на отдельном соединении, удерживая
+// advisory-блокировку миграций. Миграции могут идти дольше обычных
+// запросов, поэтому ограничение времени на них не действует.
+func withMigrationLock(ctx context.Context, dbc *DBConnection, fn func(conn *pgxpool.Conn) error) error {
+	if dbc.Pool == nil {
+		return errors.New("Empty connection string")
+	}
+
+	return dbc.retry(ctx, "migrate", 0, isRetriable, func(ctx context.Context) error {
+		return lockAndRun(ctx, dbc, fn)
+	})
+}
+
+func lockAndRun(ctx context.Context, dbc *DBConnection, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := dbc.Pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SET statement_timeout = 0;"); err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), "RESET statement_timeout;")
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1);", migrationLockKey); err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1);", migrationLockKey)
+
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint PRIMARY KEY,
+		name text NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now());`)
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func appliedMigrations(ctx context.Context, conn *pgxpool.Conn) (map[int64]time.Time, error) {
+	result := make(map[int64]time.Time)
+
+	var exists bool
+	err := conn.QueryRow(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL;").Scan(&exists)
+	if err != nil || !exists {
+		return result, err
+	}
+
+	rows, err := conn.Query(ctx, "SELECT version, applied_at FROM schema_migrations;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		result[version] = at
+	}
+	return result, rows.Err()
+}
+
+// runMigration выполняет скрипт миграции и обновление schema_migrations
+// в одной транзакции.
+func runMigration(ctx context.Context, conn *pgxpool.Conn, script string, bookkeeping string, args ...any) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	if _, err := tx.Exec(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, bookkeeping, args...); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles)
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for i, m := range migrations {
+		assert.Equal(t, int64(i+1), m.Version)
+		assert.NotEmpty(t, m.Up)
+		assert.NotEmpty(t, m.Down)
+	}
+
+	testCases := []struct {
+		name    string
+		files   fstest.MapFS
+		wantErr bool
+	}{
+		{name: "loadMigrations() Test 1", files: fstest.MapFS{
+			"migrations/0002_b.up.sql": {Data: []byte("SELECT 2;")},
+			"migrations/0001_a.up.sql": {Data: []byte("SELECT 1;")},
+		}},
+		{name: "loadMigrations() Test 2", files: fstest.MapFS{
+			"migrations/0001_a.down.sql": {Data: []byte("SELECT 1;")},
+		}, wantErr: true},
+		{name: "loadMigrations() Test 3", files: fstest.MapFS{
+			"migrations/first.up.sql": {Data: []byte("SELECT 1;")},
+		}, wantErr: true},
+		{name: "loadMigrations() Test 4", files: fstest.MapFS{
+			"migrations/0001_a.up.sql":   {Data: []byte("SELECT 1;")},
+			"migrations/0001_b.down.sql": {Data: []byte("SELECT 1;")},
+		}, wantErr: true},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			migrations, err := loadMigrations(test.files)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, []int64{1, 2}, []int64{migrations[0].Version, migrations[1].Version})
+		})
+	}
+}
@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS counter_metrics (name char(30) UNIQUE, value integer);
+CREATE TABLE IF NOT EXISTS gauge_metrics (name char(30) UNIQUE, value double precision);
@@ -0,0 +1,5 @@
+ALTER TABLE counter_metrics
+    ALTER COLUMN name TYPE char(30),
+    ALTER COLUMN value TYPE integer;
+ALTER TABLE gauge_metrics
+    ALTER COLUMN name TYPE char(30);
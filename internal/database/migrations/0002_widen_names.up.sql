@@ -0,0 +1,5 @@
+ALTER TABLE counter_metrics
+    ALTER COLUMN name TYPE text USING rtrim(name),
+    ALTER COLUMN value TYPE bigint;
+ALTER TABLE gauge_metrics
+    ALTER COLUMN name TYPE text USING rtrim(name);
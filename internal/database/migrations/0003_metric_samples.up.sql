@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS metric_samples (
+    series text NOT NULL,
+    type text NOT NULL,
+    ts timestamptz NOT NULL,
+    value double precision NOT NULL
+) PARTITION BY RANGE (ts);
+CREATE INDEX IF NOT EXISTS metric_samples_series_ts_idx ON metric_samples (series, ts);
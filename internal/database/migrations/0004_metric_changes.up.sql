@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS metric_changes (
+    id bigserial PRIMARY KEY,
+    instance text NOT NULL,
+    type text NOT NULL,
+    name text NOT NULL,
+    delta bigint,
+    value double precision,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    txid xid8 NOT NULL DEFAULT pg_current_xact_id(),
+    batch bigint
+);
+CREATE INDEX IF NOT EXISTS metric_changes_created_at_idx ON metric_changes (created_at);
+CREATE INDEX IF NOT EXISTS metric_changes_txid_idx ON metric_changes (txid);
+CREATE UNIQUE INDEX IF NOT EXISTS metric_changes_batch_idx ON metric_changes (instance, batch, type, name);
@@ -0,0 +1,456 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/models"
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	changesChannel = "metric_changes"
+	// сколько хранить журнал изменений: сервер, отключившийся дольше,
+	// получит только состояние, сохранённое в таблицах метрик
+	changesRetention = time.Hour
+	// изменения отправляются пачками не реже этого интервала
+	changesFlushInterval = 100 * time.Millisecond
+	changesBatchSize     = 1000
+)
+
+const (
+	// изменения пачки записываются в журнал и в таблицы метрик одним
+	// запросом, поэтому таблицы всегда соответствуют журналу. Номер пачки
+	// делает запись идемпотентной: повтор уже записанной пачки ничего
+	// не меняет. Подписчикам уходит одно уведомление с номером последней
+	// записи.
+	publishChangesQuery = `WITH ins AS (
+			INSERT INTO metric_changes (instance, batch, type, name, delta, value)
+			SELECT $1::text, $2::bigint, * FROM unnest($3::text[], $4::text[], $5::bigint[], $6::double precision[])
+			ON CONFLICT (instance, batch, type, name) DO NOTHING
+			RETURNING id, type, name, delta, value),
+		counters AS (
+			INSERT INTO counter_metrics (name, value)
+			SELECT name, delta FROM ins WHERE type = 'counter' AND delta IS NOT NULL
+			ON CONFLICT (name) DO UPDATE SET value = counter_metrics.value + EXCLUDED.value),
+		gauges AS (
+			INSERT INTO gauge_metrics (name, value)
+			SELECT name, value FROM ins WHERE type = 'gauge' AND value IS NOT NULL
+			ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value)
+		SELECT pg_notify('metric_changes', $1::text || ':' || max(id)) FROM ins HAVING count(*) > 0;`
+	// xmin снимка: все транзакции с меньшим номером уже завершены
+	snapshotXminQuery  = "SELECT pg_snapshot_xmin(pg_current_snapshot())::text::bigint;"
+	selectChangesQuery = `SELECT id, txid::text::bigint, instance, type, name, delta, value FROM metric_changes
+		WHERE txid >= $1::bigint::text::xid8 ORDER BY id;`
+	pruneChangesQuery = "DELETE FROM metric_changes WHERE created_at < $1;"
+)
+
+// ChangePosition — позиция в журнале изменений. Номера записей выдаются
+// до фиксации транзакций, поэтому запись с меньшим номером может стать
+// видна позже записи с большим, и одного номера последней записи
+// недостаточно. Позиция хранит xmin снимка, из которого журнал читался
+// в последний раз: транзакции с меньшими номерами к тому моменту
+// завершились, и их записи уже прочитаны. Прочитанные записи более
+// поздних транзакций запоминаются, чтобы не применить их повторно.
+type ChangePosition struct {
+	xmin int64
+	seen map[int64]int64 // номер записи -> номер транзакции
+}
+
+func (pos *ChangePosition) applied(id int64) bool {
+	_, ok := pos.seen[id]
+	return ok
+}
+
+func (pos *ChangePosition) add(id, txid int64) {
+	if pos.seen == nil {
+		pos.seen = make(map[int64]int64)
+	}
+	pos.seen[id] = txid
+}
+
+// advance сдвигает позицию к xmin нового снимка. Записи транзакций
+// с меньшими номерами больше не читаются, и помнить их не нужно.
+func (pos *ChangePosition) advance(xmin int64) {
+	pos.xmin = xmin
+	for id, txid := range pos.seen {
+		if txid < xmin {
+			delete(pos.seen, id)
+		}
+	}
+}
+
+// change — запись журнала изменений.
+type change struct {
+	id, txid int64
+	instance string
+	mtype    string
+	name     string
+	delta    *int64
+	value    *float64
+}
+
+// readChanges читает xmin снимка q и записи журнала начиная с транзакции
+// from. q должен быть транзакцией с уровнем изоляции не ниже
+// RepeatableRead, чтобы оба запроса видели один снимок.
+func readChanges(ctx context.Context, q querier, from int64) (int64, []change, error) {
+	var xmin int64
+	if err := q.QueryRow(ctx, snapshotXminQuery).Scan(&xmin); err != nil {
+		return 0, nil, err
+	}
+	changes, err := selectChanges(ctx, q, from)
+	return xmin, changes, err
+}
+
+func selectChanges(ctx context.Context, q querier, from int64) ([]change, error) {
+	rows, err := q.Query(ctx, selectChangesQuery, from)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []change
+	for rows.Next() {
+		var c change
+		if err := rows.Scan(&c.id, &c.txid, &c.instance, &c.mtype, &c.name, &c.delta, &c.value); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// readPosition возвращает позицию журнала, соответствующую снимку q:
+// все видимые в нём записи считаются применёнными.
+func readPosition(ctx context.Context, q querier) (ChangePosition, error) {
+	var pos ChangePosition
+	if err := q.QueryRow(ctx, snapshotXminQuery).Scan(&pos.xmin); err != nil {
+		return pos, err
+	}
+	changes, err := selectChanges(ctx, q, pos.xmin)
+	if err != nil {
+		return pos, err
+	}
+	for _, c := range changes {
+		pos.add(c.id, c.txid)
+	}
+	return pos, nil
+}
+
+// Propagator обменивается изменениями метрик с другими серверами,
+// работающими с той же базой данных. Свои изменения он записывает
+// в журнал metric_changes, одновременно прибавляя их к таблицам метрик,
+// и оповещает о них через NOTIFY, чужие — применяет к хранилищу в памяти.
+// После переподключения пропущенные изменения дочитываются из журнала.
+// Таблицы метрик при этом заполняет сам Propagator, и сохранять в них
+// хранилище через Dump не нужно: Dump записал бы поверх сумм других
+// серверов собственные значения счётчиков.
+type Propagator struct {
+	dbc      *DBConnection
+	s        *storage.MemStorage
+	instance string
+
+	// неотправленные изменения по сериям: приращения счётчика
+	// складываются, от gauge остаётся последнее значение
+	mu      sync.Mutex
+	pending map[string]models.Metrics
+	wake    chan struct{}
+
+	// номер последней отправленной пачки
+	batch int64
+
+	// позиция применённых изменений журнала
+	pos ChangePosition
+}
+
+func NewPropagator(dbc *DBConnection, s *storage.MemStorage) *Propagator {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &Propagator{
+		dbc:      dbc,
+		s:        s,
+		instance: hex.EncodeToString(id),
+		pending:  make(map[string]models.Metrics),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Start запоминает текущую позицию журнала. Используется, когда
+// хранилище не восстановлено из базы; иначе позиция берётся из того же
+// снимка, что и восстановленные значения, через StartAt.
+func (p *Propagator) Start(ctx context.Context) error {
+	return p.dbc.withRetry(ctx, "last change", func(ctx context.Context) error {
+		tx, err := p.dbc.Pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(context.Background())
+
+		if p.pos, err = readPosition(ctx, tx); err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+}
+
+// StartAt задаёт позицию журнала, с которой применяются чужие изменения.
+func (p *Propagator) StartAt(pos ChangePosition) {
+	p.pos = pos
+}
+
+// Notify добавляет изменение к неотправленным. Вызов не блокируется,
+// даже если база данных недоступна: изменения одной серии объединяются,
+// поэтому очередь не растёт больше числа серий.
+func (p *Propagator) Notify(ctx context.Context, m models.Metrics) {
+	p.mu.Lock()
+	p.merge(m, true)
+	full := len(p.pending) >= changesBatchSize
+	p.mu.Unlock()
+
+	if full {
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// merge объединяет изменение с неотправленным изменением той же серии.
+// С newer значение gauge заменяет прежнее, иначе прежнее сохраняется.
+func (p *Propagator) merge(m models.Metrics, newer bool) {
+	key := m.MType + ":" + m.ID
+	prev, ok := p.pending[key]
+	switch {
+	case m.MType == "counter" && m.Delta != nil:
+		delta := *m.Delta
+		if ok && prev.Delta != nil {
+			delta += *prev.Delta
+		}
+		m.Delta = &delta
+	case ok && !newer:
+		return
+	case m.Value != nil:
+		value := *m.Value
+		m.Value = &value
+	}
+	p.pending[key] = m
+}
+
+// takePending забирает все неотправленные изменения.
+func (p *Propagator) takePending() []models.Metrics {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	changes := make([]models.Metrics, 0, len(p.pending))
+	for key, m := range p.pending {
+		changes = append(changes, m)
+		delete(p.pending, key)
+	}
+	return changes
+}
+
+// requeue возвращает неотправленные изменения в очередь, не затирая
+// более новые значения gauge, пришедшие за это время.
+func (p *Propagator) requeue(changes []models.Metrics) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, m := range changes {
+		p.merge(m, false)
+	}
+}
+
+// changeBatch — пачка изменений и номер, под которым она записывается
+// в журнал.
+type changeBatch struct {
+	seq     int64
+	changes []models.Metrics
+}
+
+// nextBatches забирает неотправленные изменения и делит их на пачки.
+func (p *Propagator) nextBatches() []changeBatch {
+	changes := p.takePending()
+	var batches []changeBatch
+	for len(changes) > 0 {
+		n := len(changes)
+		if n > changesBatchSize {
+			n = changesBatchSize
+		}
+		p.batch++
+		batches = append(batches, changeBatch{seq: p.batch, changes: changes[:n]})
+		changes = changes[n:]
+	}
+	return batches
+}
+
+// Run отправляет свои изменения и применяет чужие, пока не отменён ctx.
+func (p *Propagator) Run(ctx context.Context) {
+	go p.publish(ctx)
+	p.listen(ctx)
+}
+
+func (p *Propagator) publish(ctx context.Context) {
+	ticker := time.NewTicker(changesFlushInterval)
+	defer ticker.Stop()
+
+	// пачка, запись которой не подтверждена
+	var held *changeBatch
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.wake:
+		}
+
+		batches := p.nextBatches()
+		if held != nil {
+			batches = append([]changeBatch{*held}, batches...)
+			held = nil
+		}
+		var err error
+		for i := range batches {
+			if err = p.publishBatch(ctx, batches[i]); err != nil {
+				// пачка могла быть записана, поэтому повторяется без изменений
+				// под тем же номером; неотправленные возвращаются в очередь
+				p.dbc.logger.Errorw("cannot publish metric changes", "changes", len(batches[i].changes), "error", err)
+				held = &batches[i]
+				for _, b := range batches[i+1:] {
+					p.requeue(b.changes)
+				}
+				break
+			}
+		}
+		// таблицы метрик обновляются вместе с журналом
+		p.s.SetDumpResult(err)
+	}
+}
+
+func (p *Propagator) publishBatch(ctx context.Context, b changeBatch) error {
+	types := make([]string, len(b.changes))
+	names := make([]string, len(b.changes))
+	deltas := make([]*int64, len(b.changes))
+	values := make([]*float64, len(b.changes))
+	for i, m := range b.changes {
+		types[i], names[i], deltas[i], values[i] = m.MType, m.ID, m.Delta, m.Value
+	}
+
+	return p.dbc.withRetry(ctx, "publish changes", func(ctx context.Context) error {
+		_, err := p.dbc.Pool.Exec(ctx, publishChangesQuery, p.instance, b.seq, types, names, deltas, values)
+		return err
+	})
+}
+
+// listen держит отдельное соединение с подпиской на уведомления
+// и переподключается при его потере.
+func (p *Propagator) listen(ctx context.Context) {
+	prune := time.NewTicker(changesRetention / 4)
+	defer prune.Stop()
+
+	for attempt := 0; ctx.Err() == nil; attempt++ {
+		err := p.listenOnce(ctx, prune.C)
+		if ctx.Err() != nil {
+			return
+		}
+
+		delay := retryDelays[len(retryDelays)-1]
+		if attempt < len(retryDelays) {
+			delay = retryDelays[attempt]
+		}
+		p.dbc.logger.Warnw("change notifications interrupted, reconnecting", "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (p *Propagator) listenOnce(ctx context.Context, prune <-chan time.Time) error {
+	// соединение с подпиской не возвращается в пул, поэтому открывается отдельно
+	conn, err := pgx.ConnectConfig(ctx, p.dbc.Pool.Config().ConnConfig)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+changesChannel+";"); err != nil {
+		return err
+	}
+	// всё, что произошло до подписки, дочитывается из журнала
+	if err := p.catchUp(ctx, conn); err != nil {
+		return err
+	}
+	p.dbc.logger.Infow("listening for metric changes", "instance", p.instance, "xmin", p.pos.xmin)
+
+	for {
+		waitCtx, cancel := context.WithTimeout(ctx, changesFlushInterval*10)
+		n, err := conn.WaitForNotification(waitCtx)
+		cancel()
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case err != nil && waitCtx.Err() == nil:
+			return err
+		case n != nil && strings.HasPrefix(n.Payload, p.instance+":"):
+			// собственные изменения уже применены
+		default:
+			// журнал дочитывается и по таймауту, на случай потерянных уведомлений
+			if err := p.catchUp(ctx, conn); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case now := <-prune:
+			if _, err := conn.Exec(ctx, pruneChangesQuery, now.Add(-changesRetention)); err != nil {
+				p.dbc.logger.Errorw("cannot prune metric changes", "error", err)
+			}
+		default:
+		}
+	}
+}
+
+// catchUp применяет чужие изменения журнала, ещё не применённые
+// с позиции p.pos.
+func (p *Propagator) catchUp(ctx context.Context, conn *pgx.Conn) error {
+	tx, err := conn.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	xmin, changes, err := readChanges(ctx, tx, p.pos.xmin)
+	if err != nil {
+		return err
+	}
+	p.applyChanges(ctx, xmin, changes)
+	return tx.Commit(ctx)
+}
+
+// applyChanges применяет прочитанные из снимка с xmin записи журнала,
+// пропуская свои и уже применённые, и сдвигает позицию.
+func (p *Propagator) applyChanges(ctx context.Context, xmin int64, changes []change) {
+	for _, c := range changes {
+		if c.instance == p.instance || p.pos.applied(c.id) {
+			continue
+		}
+		p.apply(ctx, c.mtype, c.name, c.delta, c.value)
+		p.pos.add(c.id, c.txid)
+	}
+	p.pos.advance(xmin)
+}
+
+func (p *Propagator) apply(ctx context.Context, t, n string, delta *int64, value *float64) {
+	switch {
+	case t == "counter" && delta != nil:
+		p.s.ApplyCounter(ctx, n, *delta)
+	case t == "gauge" && value != nil:
+		p.s.ApplyGauge(ctx, n, *value)
+	}
+}
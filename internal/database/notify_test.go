@@ -0,0 +1,276 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/models"
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingNotifier []models.Metrics
+
+func (n *recordingNotifier) Notify(ctx context.Context, m models.Metrics) {
+	*n = append(*n, m)
+}
+
+func TestPropagatorApply(t *testing.T) {
+	ctx := context.Background()
+	s := storage.New(0, "", false)
+	var sent recordingNotifier
+	s.SetNotifier(&sent)
+
+	s.UpdateCounter(ctx, "PollCount", 2)
+	s.TakeChanges()
+
+	p := &Propagator{s: s}
+	delta, value := int64(3), 1.5
+	p.apply(ctx, "counter", "PollCount", &delta, nil)
+	p.apply(ctx, "gauge", "Alloc", nil, &value)
+	p.apply(ctx, "gauge", "Broken", nil, nil)
+
+	c, err := s.GetCounterValue(ctx, "PollCount")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), c)
+	g, err := s.GetGaugeValue(ctx, "Alloc")
+	assert.NoError(t, err)
+	assert.Equal(t, 1.5, g)
+	_, err = s.GetGaugeValue(ctx, "Broken")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	// чужие изменения не отправляются обратно и не сохраняются повторно
+	assert.Len(t, sent, 1)
+	counters, gauges := s.TakeChanges()
+	assert.Empty(t, counters)
+	assert.Empty(t, gauges)
+	p.apply(ctx, "counter", "Requests", &delta, nil)
+	assert.NotContains(t, s.CountersCreated(ctx), "Requests")
+}
+
+func TestPropagatorNotify(t *testing.T) {
+	ctx := context.Background()
+	p := NewPropagator(nil, nil)
+
+	delta := func(v int64) *int64 { return &v }
+	value := func(v float64) *float64 { return &v }
+
+	// очередь не вычитывается, но Notify не блокируется
+	for i := 0; i < 2*changesBatchSize; i++ {
+		p.Notify(ctx, models.Metrics{ID: "PollCount", MType: "counter", Delta: delta(1)})
+	}
+	p.Notify(ctx, models.Metrics{ID: "Alloc", MType: "gauge", Value: value(1)})
+	p.Notify(ctx, models.Metrics{ID: "Alloc", MType: "gauge", Value: value(2)})
+
+	changes := p.takePending()
+	assert.Len(t, changes, 2)
+	byID := make(map[string]models.Metrics)
+	for _, m := range changes {
+		byID[m.ID] = m
+	}
+	assert.Equal(t, int64(2*changesBatchSize), *byID["PollCount"].Delta)
+	assert.Equal(t, 2.0, *byID["Alloc"].Value)
+
+	// неотправленные изменения возвращаются, не затирая более новые
+	p.Notify(ctx, models.Metrics{ID: "PollCount", MType: "counter", Delta: delta(5)})
+	p.Notify(ctx, models.Metrics{ID: "Alloc", MType: "gauge", Value: value(3)})
+	p.requeue(changes)
+
+	byID = make(map[string]models.Metrics)
+	for _, m := range p.takePending() {
+		byID[m.ID] = m
+	}
+	assert.Equal(t, int64(2*changesBatchSize+5), *byID["PollCount"].Delta)
+	assert.Equal(t, 3.0, *byID["Alloc"].Value)
+	assert.Empty(t, p.takePending())
+}
+
+// changeLog имитирует журнал изменений с транзакциями, которые
+// фиксируются не в порядке номеров записей.
+type changeLog struct {
+	rows      []change
+	open      map[int64]bool // незафиксированные транзакции
+	counters  map[string]int64
+	nextID    int64
+	nextTxid  int64
+	committed map[int64]bool
+}
+
+func newChangeLog() *changeLog {
+	return &changeLog{
+		open:      make(map[int64]bool),
+		committed: make(map[int64]bool),
+		counters:  make(map[string]int64),
+		nextID:    1,
+		nextTxid:  100,
+	}
+}
+
+// begin записывает приращение счётчика в незафиксированной транзакции
+// и возвращает её номер.
+func (l *changeLog) begin(instance, name string, delta int64) int64 {
+	txid := l.nextTxid
+	l.nextTxid++
+	l.open[txid] = true
+	l.rows = append(l.rows, change{id: l.nextID, txid: txid, instance: instance, mtype: "counter", name: name, delta: &delta})
+	l.nextID++
+	return txid
+}
+
+// commit фиксирует транзакцию: её записи становятся видны, а приращения
+// попадают в таблицу счётчиков.
+func (l *changeLog) commit(txid int64) {
+	delete(l.open, txid)
+	l.committed[txid] = true
+	for _, c := range l.rows {
+		if c.txid == txid {
+			l.counters[c.name] += *c.delta
+		}
+	}
+}
+
+// snapshot возвращает xmin текущего снимка и видимые в нём записи
+// транзакций начиная с from.
+func (l *changeLog) snapshot(from int64) (int64, []change) {
+	xmin := l.nextTxid
+	for txid := range l.open {
+		if txid < xmin {
+			xmin = txid
+		}
+	}
+	var changes []change
+	for _, c := range l.rows {
+		if c.txid >= from && l.committed[c.txid] {
+			changes = append(changes, c)
+		}
+	}
+	return xmin, changes
+}
+
+// restore восстанавливает хранилище нового сервера из таблиц и позицию
+// журнала из того же снимка.
+func (l *changeLog) restore() *Propagator {
+	s := storage.New(0, "", false)
+	s.Load(l.counters, nil)
+	p := NewPropagator(nil, s)
+	xmin, changes := l.snapshot(0)
+	for _, c := range changes {
+		if c.txid >= xmin {
+			p.pos.add(c.id, c.txid)
+		}
+	}
+	p.pos.advance(xmin)
+	return p
+}
+
+func TestPropagatorCatchUp(t *testing.T) {
+	ctx := context.Background()
+	log := newChangeLog()
+
+	// изменения сервера сначала применяются к его хранилищу, затем
+	// записываются в журнал
+	update := func(p *Propagator, delta int64) int64 {
+		p.s.ApplyCounter(ctx, "PollCount", delta)
+		return log.begin(p.instance, "PollCount", delta)
+	}
+	catchUp := func(p *Propagator) {
+		xmin, changes := log.snapshot(p.pos.xmin)
+		p.applyChanges(ctx, xmin, changes)
+	}
+	value := func(p *Propagator) int64 {
+		v, err := p.s.GetCounterValue(ctx, "PollCount")
+		assert.NoError(t, err)
+		return v
+	}
+
+	a := NewPropagator(nil, storage.New(0, "", false))
+	b := NewPropagator(nil, storage.New(0, "", false))
+
+	// запись a получает меньший номер, но фиксируется после записи b
+	txA := update(a, 1)
+	log.commit(update(b, 2))
+	catchUp(a)
+	catchUp(b)
+
+	// третий сервер восстанавливается, пока транзакция a не зафиксирована
+	c := log.restore()
+	assert.Equal(t, int64(2), value(c))
+
+	log.commit(txA)
+	log.commit(update(b, 4))
+	catchUp(a)
+	catchUp(b)
+	catchUp(c)
+	// повторное чтение того же снимка ничего не меняет
+	catchUp(c)
+
+	// ни одно изменение не потеряно и не применено дважды
+	for _, p := range []*Propagator{a, b, c} {
+		assert.Equal(t, int64(7), value(p))
+	}
+	assert.Equal(t, int64(7), log.counters["PollCount"])
+	// позиция не хранит записи завершённых транзакций
+	for _, p := range []*Propagator{a, b, c} {
+		assert.Empty(t, p.pos.seen)
+	}
+}
+
+// TestPropagatorInstances обменивается изменениями двух серверов через
+// настоящую базу данных.
+func TestPropagatorInstances(t *testing.T) {
+	dbc := testDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	name := fmt.Sprintf("propagate_%d", time.Now().UnixNano())
+	start := func(restore bool) *storage.MemStorage {
+		s := storage.New(0, "", false)
+		p := NewPropagator(dbc, s)
+		if restore {
+			_, pos, err := RestoreSnapshot(ctx, s, dbc)
+			require.NoError(t, err)
+			p.StartAt(pos)
+		} else {
+			require.NoError(t, p.Start(ctx))
+		}
+		s.SetNotifier(p)
+		go p.Run(ctx)
+		return s
+	}
+
+	const updates = 200
+	a, b := start(false), start(false)
+	var wg sync.WaitGroup
+	for _, s := range []*storage.MemStorage{a, b} {
+		wg.Add(1)
+		go func(s *storage.MemStorage) {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				s.UpdateCounter(ctx, name, 1)
+				time.Sleep(time.Millisecond)
+			}
+		}(s)
+	}
+	// третий сервер запускается, пока первые два обновляют счётчик
+	time.Sleep(100 * time.Millisecond)
+	c := start(true)
+	wg.Wait()
+
+	for _, s := range []*storage.MemStorage{a, b, c} {
+		assert.Eventually(t, func() bool {
+			v, err := s.GetCounterValue(ctx, name)
+			return err == nil && v == 2*updates
+		}, 5*time.Second, 50*time.Millisecond)
+	}
+
+	restored := storage.New(0, "", false)
+	_, err := Restore(ctx, restored, dbc)
+	require.NoError(t, err)
+	v, err := restored.GetCounterValue(ctx, name)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2*updates), v)
+}
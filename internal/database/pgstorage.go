@@ -0,0 +1,296 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/models"
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	incrementCounterQuery = `INSERT INTO counter_metrics (name, value) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET value = counter_metrics.value + EXCLUDED.value
+		RETURNING value;`
+	raiseCounterQuery = `INSERT INTO counter_metrics (name, value) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET value = GREATEST(counter_metrics.value, EXCLUDED.value)
+		RETURNING value;`
+	addGaugeQuery = `INSERT INTO gauge_metrics (name, value) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET value = gauge_metrics.value + EXCLUDED.value
+		RETURNING value;`
+	selectCounterQuery = "SELECT value FROM counter_metrics WHERE name = $1;"
+	selectGaugeQuery   = "SELECT value FROM gauge_metrics WHERE name = $1;"
+)
+
+// PGStorage — хранилище, которое читает и пишет метрики напрямую в базу
+// данных, поэтому несколько серверов могут работать с одной базой.
+type PGStorage struct {
+	storage.Recorder
+
+	dbc   *DBConnection
+	cache *readCache
+}
+
+// NewPGStorage создаёт хранилище поверх dbc. При cacheTTL > 0 прочитанные
+// значения кэшируются в памяти на это время.
+func NewPGStorage(dbc *DBConnection, cacheTTL time.Duration) *PGStorage {
+	s := &PGStorage{dbc: dbc}
+	if cacheTTL > 0 {
+		s.cache = newReadCache(cacheTTL)
+	}
+	return s
+}
+
+// Ping проверяет соединение с базой данных.
+func (s *PGStorage) Ping(ctx context.Context) error {
+	return s.dbc.Pool.Ping(ctx)
+}
+
+func (s *PGStorage) UpdateCounter(ctx context.Context, n string, v int64) error {
+	var total int64
+	err := s.dbc.withSafeRetry(ctx, "update counter", func(ctx context.Context) error {
+		return s.dbc.Pool.QueryRow(ctx, incrementCounterQuery, n, v).Scan(&total)
+	})
+	if err != nil {
+		return err
+	}
+	s.cache.set("counter", n, float64(total))
+	s.Record(ctx, "counter", n, float64(total))
+	return nil
+}
+
+// RaiseCounter не меняет уже поднятый счётчик, поэтому запрос можно
+// повторять при любой временной ошибке.
+func (s *PGStorage) RaiseCounter(ctx context.Context, n string, v int64) error {
+	if v < 0 {
+		return fmt.Errorf("%s: %w", n, storage.ErrNegativeCounter)
+	}
+	var total int64
+	err := s.dbc.withRetry(ctx, "raise counter", func(ctx context.Context) error {
+		return s.dbc.Pool.QueryRow(ctx, raiseCounterQuery, n, v).Scan(&total)
+	})
+	if err != nil {
+		return err
+	}
+	s.cache.set("counter", n, float64(total))
+	s.Record(ctx, "counter", n, float64(total))
+	return nil
+}
+
+func (s *PGStorage) UpdateGauge(ctx context.Context, n string, v float64) error {
+	err := s.dbc.withRetry(ctx, "update gauge", func(ctx context.Context) error {
+		_, err := s.dbc.Pool.Exec(ctx, upsertGaugeQuery, n, v)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.cache.set("gauge", n, v)
+	s.Record(ctx, "gauge", n, v)
+	return nil
+}
+
+func (s *PGStorage) AddGauge(ctx context.Context, n string, v float64) error {
+	var value float64
+	err := s.dbc.withSafeRetry(ctx, "add gauge", func(ctx context.Context) error {
+		return s.dbc.Pool.QueryRow(ctx, addGaugeQuery, n, v).Scan(&value)
+	})
+	if err != nil {
+		return err
+	}
+	s.cache.set("gauge", n, value)
+	s.Record(ctx, "gauge", n, value)
+	return nil
+}
+
+func (s *PGStorage) GetCounterValue(ctx context.Context, id string) (int64, error) {
+	if v, ok := s.cache.get("counter", id); ok {
+		return int64(v), nil
+	}
+
+	var v int64
+	err := s.dbc.withRetry(ctx, "get counter", func(ctx context.Context) error {
+		return s.dbc.Pool.QueryRow(ctx, selectCounterQuery, id).Scan(&v)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, storage.ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	s.cache.set("counter", id, float64(v))
+	return v, nil
+}
+
+func (s *PGStorage) GetGaugeValue(ctx context.Context, id string) (float64, error) {
+	if v, ok := s.cache.get("gauge", id); ok {
+		return v, nil
+	}
+
+	var v float64
+	err := s.dbc.withRetry(ctx, "get gauge", func(ctx context.Context) error {
+		return s.dbc.Pool.QueryRow(ctx, selectGaugeQuery, id).Scan(&v)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, storage.ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	s.cache.set("gauge", id, v)
+	return v, nil
+}
+
+func (s *PGStorage) Counters(ctx context.Context) (map[string]int64, error) {
+	var counters []counterMetric
+	err := s.dbc.withRetry(ctx, "list counters", func(ctx context.Context) (err error) {
+		counters, err = loadCounters(ctx, s.dbc.Pool)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]int64, len(counters))
+	for _, cm := range counters {
+		result[cm.name] = cm.value
+	}
+	return result, nil
+}
+
+func (s *PGStorage) Gauges(ctx context.Context) (map[string]float64, error) {
+	var gauges []gaugeMetric
+	err := s.dbc.withRetry(ctx, "list gauges", func(ctx context.Context) (err error) {
+		gauges, err = loadGauges(ctx, s.dbc.Pool)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]float64, len(gauges))
+	for _, gm := range gauges {
+		result[gm.name] = gm.value
+	}
+	return result, nil
+}
+
+// StoreBatch применяет пакет одной транзакцией: либо сохраняются все
+// метрики пакета, либо ни одной.
+func (s *PGStorage) StoreBatch(ctx context.Context, metrics []models.Metrics) error {
+	if err := storage.ValidateBatch(metrics); err != nil {
+		return err
+	}
+
+	store := s.storeBatchRows
+	if len(metrics) >= copyThreshold {
+		store = s.storeBatchCopy
+	}
+
+	var samples []storage.Sample
+	err := s.dbc.withSafeRetry(ctx, "store batch", func(ctx context.Context) (err error) {
+		samples, err = store(ctx, metrics)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, smp := range samples {
+		s.cache.set(smp.MType, smp.Name, smp.Value)
+	}
+	s.RecordSamples(ctx, samples)
+	return nil
+}
+
+// storeBatchRows выполняет транзакцию пакета по запросу на метрику
+// и возвращает новые значения серий. Все запросы пакета отправляются
+// на сервер за один обмен.
+func (s *PGStorage) storeBatchRows(ctx context.Context, metrics []models.Metrics) ([]storage.Sample, error) {
+	tx, err := s.dbc.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(context.Background())
+
+	batch := &pgx.Batch{}
+	for _, m := range metrics {
+		switch m.MType {
+		case "counter":
+			batch.Queue(incrementCounterQuery, m.ID, *m.Delta)
+		case "gauge":
+			batch.Queue(upsertGaugeQuery, m.ID, *m.Value)
+		}
+	}
+	results := tx.SendBatch(ctx, batch)
+
+	var samples []storage.Sample
+	for _, m := range metrics {
+		switch m.MType {
+		case "counter":
+			var total int64
+			if err := results.QueryRow().Scan(&total); err != nil {
+				results.Close()
+				return nil, err
+			}
+			samples = append(samples, storage.Sample{Name: m.ID, MType: m.MType, Value: float64(total)})
+		case "gauge":
+			if _, err := results.Exec(); err != nil {
+				results.Close()
+				return nil, err
+			}
+			samples = append(samples, storage.Sample{Name: m.ID, MType: m.MType, Value: *m.Value})
+		}
+	}
+	if err := results.Close(); err != nil {
+		return nil, err
+	}
+
+	return samples, tx.Commit(ctx)
+}
+
+// readCache — кэш прочитанных значений с ограниченным временем жизни.
+// Нулевой указатель — выключенный кэш.
+type readCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	value   float64
+	expires time.Time
+}
+
+func newReadCache(ttl time.Duration) *readCache {
+	return &readCache{ttl: ttl, entries: make(map[string]cacheEntry)}
+}
+
+func (c *readCache) get(t, n string) (float64, bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[t+":"+n]
+	if !ok {
+		return 0, false
+	}
+	if time.Now().After(e.expires) {
+		delete(c.entries, t+":"+n)
+		return 0, false
+	}
+	return e.value, true
+}
+
+func (c *readCache) set(t, n string, v float64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[t+":"+n] = cacheEntry{value: v, expires: time.Now().Add(c.ttl)}
+}
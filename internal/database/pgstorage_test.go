@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/models"
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadCache(t *testing.T) {
+	var disabled *readCache
+	disabled.set("gauge", "Alloc", 1)
+	_, ok := disabled.get("gauge", "Alloc")
+	assert.False(t, ok)
+
+	c := newReadCache(50 * time.Millisecond)
+	c.set("gauge", "Alloc", 1.5)
+	c.set("counter", "Alloc", 3)
+
+	v, ok := c.get("gauge", "Alloc")
+	assert.True(t, ok)
+	assert.Equal(t, 1.5, v)
+	v, ok = c.get("counter", "Alloc")
+	assert.True(t, ok)
+	assert.Equal(t, 3.0, v)
+
+	time.Sleep(60 * time.Millisecond)
+	_, ok = c.get("gauge", "Alloc")
+	assert.False(t, ok)
+}
+
+func TestPGStorage(t *testing.T) {
+	ctx := context.Background()
+	// два сервера с отдельными пулами соединений и одной базой
+	first := NewPGStorage(testDB(t), time.Minute)
+	second := NewPGStorage(testDB(t), time.Minute)
+
+	// имена уникальны для запуска: база может остаться от прошлых тестов
+	prefix := fmt.Sprintf("pgstorage_test_%d_", time.Now().UnixNano())
+	counter, gauge := prefix+"PollCount", prefix+"Alloc"
+
+	_, err := second.GetCounterValue(ctx, counter)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = second.GetGaugeValue(ctx, gauge)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	require.NoError(t, first.UpdateCounter(ctx, counter, 2))
+	require.NoError(t, first.UpdateCounter(ctx, counter, 3))
+	require.NoError(t, first.UpdateGauge(ctx, gauge, 1.5))
+	require.NoError(t, first.AddGauge(ctx, gauge, 0.25))
+
+	// второй сервер ещё не читал эти серии и видит записи первого
+	c, err := second.GetCounterValue(ctx, counter)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), c)
+	g, err := second.GetGaugeValue(ctx, gauge)
+	require.NoError(t, err)
+	assert.Equal(t, 1.75, g)
+
+	// приращения обоих серверов складываются в базе, а не в кэше
+	require.NoError(t, second.UpdateCounter(ctx, counter, 10))
+	require.NoError(t, first.UpdateCounter(ctx, counter, 1))
+	c, err = first.GetCounterValue(ctx, counter)
+	require.NoError(t, err)
+	assert.Equal(t, int64(16), c)
+
+	delta, value := int64(4), -1.0
+	batchCounter, batchGauge := prefix+"Requests", prefix+"Inflight"
+	require.NoError(t, first.StoreBatch(ctx, []models.Metrics{
+		{ID: counter, MType: "counter", Delta: &delta},
+		{ID: batchCounter, MType: "counter", Delta: &delta},
+		{ID: batchCounter, MType: "counter", Delta: &delta},
+		{ID: batchGauge, MType: "gauge", Value: &value},
+	}))
+	fresh := NewPGStorage(second.dbc, 0)
+	c, err = fresh.GetCounterValue(ctx, counter)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20), c)
+	c, err = fresh.GetCounterValue(ctx, batchCounter)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), c)
+	g, err = fresh.GetGaugeValue(ctx, batchGauge)
+	require.NoError(t, err)
+	assert.Equal(t, -1.0, g)
+
+	counters, err := fresh.Counters(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20), counters[counter])
+	gauges, err := fresh.Gauges(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1.75, gauges[gauge])
+
+	// неверный пакет не применяется целиком
+	err = first.StoreBatch(ctx, []models.Metrics{
+		{ID: batchCounter, MType: "counter", Delta: &delta},
+		{ID: batchGauge, MType: "gauge"},
+	})
+	assert.Error(t, err)
+	c, err = fresh.GetCounterValue(ctx, batchCounter)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), c)
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"expvar"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// паузы между повторными попытками операции с базой данных
+var retryDelays = []time.Duration{time.Second, 3 * time.Second, 5 * time.Second}
+
+// счётчики повторов публикуются в /debug/vars вместе с остальными
+// метриками самого сервера
+var (
+	retriesTotal  = expvar.NewInt("db_retries_total")
+	retriesFailed = expvar.NewInt("db_retries_failed_total")
+)
+
+// isRetriable сообщает, имеет ли смысл повторить операцию, завершившуюся
+// ошибкой err: соединение с базой потеряно или транзакция отменена
+// из-за конфликта с параллельной транзакцией.
+func isRetriable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case strings.HasPrefix(pgErr.Code, "08"): // connection_exception
+			return true
+		case pgErr.Code == "40001", pgErr.Code == "40P01": // serialization_failure, deadlock_detected
+			return true
+		case pgErr.Code == "57P01", pgErr.Code == "57P02", pgErr.Code == "57P03": // перезапуск сервера
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return pgconn.SafeToRetry(err) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &netErr)
+}
+
+// isSafeToRepeat сообщает, можно ли повторить неидемпотентную запись,
+// например приращение счётчика: запрос не дошёл до сервера или сервер
+// откатил транзакцию сам. После обрыва соединения на середине обмена
+// транзакция могла быть зафиксирована, и повтор применил бы её дважды.
+func isSafeToRepeat(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "40001" || pgErr.Code == "40P01"
+	}
+	// pgconn.SafeToRetry не разворачивает обёрнутые ошибки
+	var safe interface{ SafeToRetry() bool }
+	return errors.As(err, &safe) && safe.SafeToRetry()
+}
+
+// withRetry выполняет fn, повторяя её после временных ошибок с паузами
+// из retryDelays. Каждая попытка ограничена statementTimeout соединения.
+// Подходит только для идемпотентных операций.
+func (dbc *DBConnection) withRetry(ctx context.Context, op string, fn func(ctx context.Context) error) error {
+	return dbc.retry(ctx, op, dbc.statementTimeout, isRetriable, fn)
+}
+
+// withSafeRetry — то же, что withRetry, для неидемпотентных операций:
+// повтор выполняется, только если isSafeToRepeat.
+func (dbc *DBConnection) withSafeRetry(ctx context.Context, op string, fn func(ctx context.Context) error) error {
+	return dbc.retry(ctx, op, dbc.statementTimeout, isSafeToRepeat, fn)
+}
+
+// retry выполняет fn с явным ограничением времени одной попытки
+// (0 — без ограничения), повторяя её, пока retriable возвращает true.
+func (dbc *DBConnection) retry(ctx context.Context, op string, timeout time.Duration, retriable func(error) bool, fn func(ctx context.Context) error) error {
+	attemptFn := func() error {
+		if timeout <= 0 {
+			return fn(ctx)
+		}
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return fn(attemptCtx)
+	}
+
+	err := attemptFn()
+	for attempt := 0; attempt < len(retryDelays) && retriable(err); attempt++ {
+		retriesTotal.Add(1)
+		dbc.logger.Warnw("retrying database operation",
+			"operation", op,
+			"attempt", attempt+1,
+			"delay", retryDelays[attempt],
+			"error", err,
+		)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryDelays[attempt]):
+		}
+		err = attemptFn()
+	}
+
+	if err != nil && retriable(err) {
+		retriesFailed.Add(1)
+		dbc.logger.Errorw("database operation failed after retries", "operation", op, "error", err)
+	}
+	return err
+}
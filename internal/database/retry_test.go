@@ -0,0 +1,137 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetriable(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "isRetriable() Test 1", err: nil, want: false},
+		{name: "isRetriable() Test 2", err: &pgconn.PgError{Code: "08006"}, want: true},
+		{name: "isRetriable() Test 3", err: fmt.Errorf("save: %w", &pgconn.PgError{Code: "40001"}), want: true},
+		{name: "isRetriable() Test 4", err: &pgconn.PgError{Code: "23505"}, want: false},
+		{name: "isRetriable() Test 5", err: driver.ErrBadConn, want: true},
+		{name: "isRetriable() Test 6", err: context.Canceled, want: false},
+		{name: "isRetriable() Test 7", err: errors.New("syntax error"), want: false},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, isRetriable(test.err))
+		})
+	}
+}
+
+// notSentError — ошибка, после которой pgx гарантирует, что запрос
+// не был отправлен на сервер.
+type notSentError struct{}
+
+func (notSentError) Error() string     { return "connection refused" }
+func (notSentError) SafeToRetry() bool { return true }
+
+func TestIsSafeToRepeat(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "isSafeToRepeat() Test 1", err: nil, want: false},
+		{name: "isSafeToRepeat() Test 2", err: fmt.Errorf("store: %w", notSentError{}), want: true},
+		{name: "isSafeToRepeat() Test 3", err: &pgconn.PgError{Code: "40001"}, want: true},
+		{name: "isSafeToRepeat() Test 4", err: &pgconn.PgError{Code: "40P01"}, want: true},
+		{name: "isSafeToRepeat() Test 5", err: &pgconn.PgError{Code: "08006"}, want: false},
+		{name: "isSafeToRepeat() Test 6", err: driver.ErrBadConn, want: false},
+		{name: "isSafeToRepeat() Test 7", err: io.ErrUnexpectedEOF, want: false},
+		{name: "isSafeToRepeat() Test 8", err: context.DeadlineExceeded, want: false},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, isSafeToRepeat(test.err))
+		})
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	delays := retryDelays
+	retryDelays = []time.Duration{0, 0, 0}
+	defer func() { retryDelays = delays }()
+
+	dbc := New("", DefaultOptions)
+	transient := &pgconn.PgError{Code: "08006"}
+
+	calls := 0
+	err := dbc.withRetry(context.Background(), "test", func(context.Context) error {
+		calls++
+		if calls < 3 {
+			return transient
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	err = dbc.withRetry(context.Background(), "test", func(context.Context) error {
+		calls++
+		return transient
+	})
+	assert.ErrorIs(t, err, transient)
+	assert.Equal(t, 4, calls)
+
+	calls = 0
+	err = dbc.withRetry(context.Background(), "test", func(context.Context) error {
+		calls++
+		return errors.New("syntax error")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+
+	// ответ на приращение потерян: повтор мог бы применить его дважды
+	calls = 0
+	err = dbc.withSafeRetry(context.Background(), "test", func(context.Context) error {
+		calls++
+		return io.ErrUnexpectedEOF
+	})
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, 1, calls)
+
+	calls = 0
+	err = dbc.withSafeRetry(context.Background(), "test", func(context.Context) error {
+		calls++
+		if calls < 2 {
+			return notSentError{}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestWithRetryTimeout(t *testing.T) {
+	dbc := New("", Options{StatementTimeout: time.Millisecond})
+
+	err := dbc.withRetry(context.Background(), "test", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	err = dbc.retry(context.Background(), "test", 0, isRetriable, func(ctx context.Context) error {
+		_, ok := ctx.Deadline()
+		assert.False(t, ok)
+		return nil
+	})
+	assert.NoError(t, err)
+}
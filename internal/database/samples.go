@@ -0,0 +1,247 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	samplesTable = "metric_samples"
+	// ширина одной партиции таблицы истории
+	samplesPartition = 24 * time.Hour
+
+	insertSamplesQuery = `INSERT INTO metric_samples (series, type, ts, value)
+		SELECT * FROM unnest($1::text[], $2::text[], $3::timestamptz[], $4::double precision[]);`
+	selectSamplesQuery = `SELECT type, ts, value FROM metric_samples
+		WHERE series = $1 AND ts BETWEEN $2 AND $3 ORDER BY ts;`
+	listPartitionsQuery = `SELECT c.relname FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		WHERE p.relname = 'metric_samples';`
+)
+
+// SampleStore хранит историю серий в таблице metric_samples, разбитой
+// на дневные партиции. Партиции создаются по мере необходимости
+// и удаляются целиком по истечении срока хранения.
+type SampleStore struct {
+	dbc       *DBConnection
+	retention time.Duration
+
+	mu         sync.Mutex
+	partitions map[string]struct{}
+}
+
+func NewSampleStore(dbc *DBConnection, retention time.Duration) *SampleStore {
+	return &SampleStore{
+		dbc:        dbc,
+		retention:  retention,
+		partitions: make(map[string]struct{}),
+	}
+}
+
+func (s *SampleStore) Append(ctx context.Context, samples ...storage.Sample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+
+	err := s.append(ctx, samples)
+	if isNoPartition(err) {
+		// партицию мог удалить DropExpired другого сервера: запрос
+		// не записал ничего, поэтому партиции создаются заново
+		s.forgetPartitions(samples)
+		err = s.append(ctx, samples)
+	}
+	return err
+}
+
+// append создаёт недостающие партиции и дописывает значения. В таблице
+// истории нет уникального ключа, поэтому запись повторяется, только
+// если сервер её точно не применил.
+func (s *SampleStore) append(ctx context.Context, samples []storage.Sample) error {
+	for _, smp := range samples {
+		if err := s.ensurePartition(ctx, smp.Timestamp); err != nil {
+			return err
+		}
+	}
+	if len(samples) >= copyThreshold {
+		return s.dbc.withSafeRetry(ctx, "copy samples", func(ctx context.Context) error {
+			return s.copySamples(ctx, samples)
+		})
+	}
+	return s.dbc.withSafeRetry(ctx, "append samples", func(ctx context.Context) error {
+		return s.insertSamples(ctx, samples)
+	})
+}
+
+// forgetPartitions убирает из кэша партиции, в которые попадают samples.
+func (s *SampleStore) forgetPartitions(samples []storage.Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, smp := range samples {
+		delete(s.partitions, partitionName(smp.Timestamp.UTC().Truncate(samplesPartition)))
+	}
+}
+
+// isNoPartition сообщает, что для строки не нашлось партиции.
+func isNoPartition(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23514" // check_violation
+}
+
+// insertSamples дописывает значения в историю одним запросом INSERT.
+func (s *SampleStore) insertSamples(ctx context.Context, samples []storage.Sample) error {
+	series := make([]string, len(samples))
+	types := make([]string, len(samples))
+	timestamps := make([]time.Time, len(samples))
+	values := make([]float64, len(samples))
+	for i, smp := range samples {
+		series[i], types[i], timestamps[i], values[i] = smp.Name, smp.MType, smp.Timestamp, smp.Value
+	}
+
+	_, err := s.dbc.Pool.Exec(ctx, insertSamplesQuery, series, types, timestamps, values)
+	return err
+}
+
+func (s *SampleStore) Range(ctx context.Context, name string, from, to time.Time) ([]storage.Sample, error) {
+	var result []storage.Sample
+	err := s.dbc.withRetry(ctx, "select samples", func(ctx context.Context) error {
+		rows, err := s.dbc.Pool.Query(ctx, selectSamplesQuery, name, from, to)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		result = result[:0]
+		for rows.Next() {
+			smp := storage.Sample{Name: name}
+			if err := rows.Scan(&smp.MType, &smp.Timestamp, &smp.Value); err != nil {
+				return err
+			}
+			result = append(result, smp)
+		}
+		return rows.Err()
+	})
+	return result, err
+}
+
+// Maintain периодически заранее создаёт партицию на следующие сутки
+// и удаляет партиции старше срока хранения.
+func (s *SampleStore) Maintain(interval int) {
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		ctx := context.Background()
+		if err := s.ensurePartition(ctx, now.Add(samplesPartition)); err != nil {
+			s.dbc.logger.Errorw("cannot create samples partition", "error", err)
+		}
+		n, err := s.DropExpired(ctx, now)
+		if err != nil {
+			s.dbc.logger.Errorw("cannot drop expired samples partitions", "error", err)
+		} else if n != 0 {
+			s.dbc.logger.Infow("expired samples partitions dropped", "partitions", n)
+		}
+	}
+}
+
+// DropExpired удаляет партиции, все значения которых старше срока
+// хранения, и возвращает их количество.
+func (s *SampleStore) DropExpired(ctx context.Context, now time.Time) (int, error) {
+	if s.retention == 0 {
+		return 0, nil
+	}
+	cutoff := now.Add(-s.retention)
+
+	var names []string
+	err := s.dbc.withRetry(ctx, "list samples partitions", func(ctx context.Context) error {
+		rows, err := s.dbc.Pool.Query(ctx, listPartitionsQuery)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		names = names[:0]
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				return err
+			}
+			names = append(names, name)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var dropped int
+	for _, name := range names {
+		start, ok := parsePartitionName(name)
+		if !ok || start.Add(samplesPartition).After(cutoff) {
+			continue
+		}
+		err := s.dbc.withRetry(ctx, "drop samples partition", func(ctx context.Context) error {
+			_, err := s.dbc.Pool.Exec(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s;", name))
+			return err
+		})
+		if err != nil {
+			return dropped, err
+		}
+
+		s.mu.Lock()
+		delete(s.partitions, name)
+		s.mu.Unlock()
+		dropped++
+	}
+	return dropped, nil
+}
+
+// ensurePartition создаёт партицию, в которую попадает момент ts,
+// если она ещё не создана.
+func (s *SampleStore) ensurePartition(ctx context.Context, ts time.Time) error {
+	start := ts.UTC().Truncate(samplesPartition)
+	name := partitionName(start)
+
+	s.mu.Lock()
+	_, ok := s.partitions[name]
+	s.mu.Unlock()
+	if ok {
+		return nil
+	}
+
+	// имя партиции и границы формируются из даты, а не из пользовательских данных
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s');`,
+		name, samplesTable, start.Format(time.RFC3339), start.Add(samplesPartition).Format(time.RFC3339))
+	err := s.dbc.withRetry(ctx, "create samples partition", func(ctx context.Context) error {
+		_, err := s.dbc.Pool.Exec(ctx, query)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.partitions[name] = struct{}{}
+	s.mu.Unlock()
+	return nil
+}
+
+func partitionName(start time.Time) string {
+	return samplesTable + "_" + start.Format("20060102")
+}
+
+func parsePartitionName(name string) (time.Time, bool) {
+	suffix, ok := strings.CutPrefix(name, samplesTable+"_")
+	if !ok {
+		return time.Time{}, false
+	}
+	start, err := time.Parse("20060102", suffix)
+	return start, err == nil
+}
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitionName(t *testing.T) {
+	start := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	name := partitionName(start)
+	assert.Equal(t, "metric_samples_20230501", name)
+
+	parsed, ok := parsePartitionName(name)
+	assert.True(t, ok)
+	assert.Equal(t, start, parsed)
+
+	_, ok = parsePartitionName("metric_samples_default")
+	assert.False(t, ok)
+	_, ok = parsePartitionName("counter_metrics")
+	assert.False(t, ok)
+}
+
+func TestIsNoPartition(t *testing.T) {
+	assert.True(t, isNoPartition(fmt.Errorf("append: %w", &pgconn.PgError{Code: "23514"})))
+	assert.False(t, isNoPartition(&pgconn.PgError{Code: "08006"}))
+	assert.False(t, isNoPartition(errors.New("no partition")))
+	assert.False(t, isNoPartition(nil))
+}
+
+func TestSampleStoreDroppedPartition(t *testing.T) {
+	ctx := context.Background()
+	first := NewSampleStore(testDB(t), 24*time.Hour)
+	second := NewSampleStore(testDB(t), 24*time.Hour)
+
+	now := time.Now()
+	name := fmt.Sprintf("samples_test_%d", now.UnixNano())
+	old := storage.Sample{Name: name, MType: "gauge", Timestamp: now.Add(-10 * samplesPartition), Value: 1}
+	require.NoError(t, second.Append(ctx, old))
+
+	// партицию удаляет другой сервер, кэш второго об этом не знает
+	_, err := first.DropExpired(ctx, now)
+	require.NoError(t, err)
+	require.NoError(t, second.Append(ctx, old))
+
+	samples, err := second.Range(ctx, name, old.Timestamp.Add(-time.Minute), old.Timestamp.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Len(t, samples, 1)
+}
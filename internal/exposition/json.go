@@ -0,0 +1,54 @@
+package exposition
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+const JSONContentType = "application/json; charset=utf-8"
+
+// JSONFloat кодируется в JSON как число, а NaN и бесконечности, для
+// которых в JSON нет чисел, — строками "NaN", "+Inf" и "-Inf", как
+// в ответах Prometheus.
+type JSONFloat float64
+
+func (f JSONFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return json.Marshal(strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	return json.Marshal(v)
+}
+
+// jsonMetric повторяет models.Metrics, но значение gauge кодируется
+// как JSONFloat.
+type jsonMetric struct {
+	ID    string     `json:"id"`
+	MType string     `json:"type"`
+	Delta *int64     `json:"delta,omitempty"`
+	Value *JSONFloat `json:"value,omitempty"`
+}
+
+// WriteJSON выводит метрики списком в том же виде, в каком их
+// принимает и отдаёт JSON API сервера.
+func WriteJSON(w io.Writer, counters map[string]int64, gauges map[string]float64) error {
+	metrics := make([]jsonMetric, 0, len(counters)+len(gauges))
+	for n, v := range counters {
+		v := v
+		metrics = append(metrics, jsonMetric{ID: n, MType: "counter", Delta: &v})
+	}
+	for n, v := range gauges {
+		v := JSONFloat(v)
+		metrics = append(metrics, jsonMetric{ID: n, MType: "gauge", Value: &v})
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].MType != metrics[j].MType {
+			return metrics[i].MType < metrics[j].MType
+		}
+		return metrics[i].ID < metrics[j].ID
+	})
+	return json.NewEncoder(w).Encode(metrics)
+}
@@ -0,0 +1,61 @@
+package exposition
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// базовые единицы OpenMetrics, которые распознаются по суффиксу имени
+var units = []string{"seconds", "bytes", "ratio", "celsius", "meters", "volts", "amperes", "joules", "grams"}
+
+func unitOf(name string) string {
+	for _, u := range units {
+		if strings.HasSuffix(name, "_"+u) {
+			return u
+		}
+	}
+	return ""
+}
+
+// WriteOpenMetrics выводит метрики в формате OpenMetrics 1.0: счётчики
+// с суффиксом _total и временем появления _created, единицы измерения
+// и завершающий маркер # EOF.
+func WriteOpenMetrics(w io.Writer, families []Family) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		name := f.Name
+		if f.Type == "counter" {
+			name = strings.TrimSuffix(name, "_total")
+		}
+
+		bw.WriteString("# TYPE " + name + " " + f.Type + "\n")
+		if f.Unit != "" {
+			bw.WriteString("# UNIT " + name + " " + f.Unit + "\n")
+		}
+		bw.WriteString("# HELP " + name + " " + escapeOpenMetricsHelp(f.Help) + "\n")
+		for _, smp := range f.Samples {
+			labels := labelsString(smp.Labels)
+			if f.Type != "counter" {
+				bw.WriteString(name + labels + " " + FormatValue(smp.Value) + "\n")
+				continue
+			}
+			bw.WriteString(name + "_total" + labels + " " + FormatValue(smp.Value) + "\n")
+			if !smp.Created.IsZero() {
+				created := float64(smp.Created.UnixMilli()) / 1000
+				bw.WriteString(name + "_created" + labels + " " + strconv.FormatFloat(created, 'f', -1, 64) + "\n")
+			}
+		}
+	}
+	bw.WriteString("# EOF\n")
+	return bw.Flush()
+}
+
+var openMetricsHelpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeOpenMetricsHelp(s string) string {
+	return openMetricsHelpEscaper.Replace(s)
+}
@@ -0,0 +1,91 @@
+package exposition
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteOpenMetrics(t *testing.T) {
+	counters := map[string]int64{
+		`requests_total{code="200"}`: 7,
+		"gc_pause_seconds":           3,
+	}
+	gauges := map[string]float64{
+		"heap_bytes": 1024,
+		"Alloc":      1.5,
+	}
+	created := map[string]time.Time{
+		`requests_total{code="200"}`: time.UnixMilli(1682935200500),
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteOpenMetrics(&buf, Families(counters, gauges, created)))
+	assert.Equal(t, `# TYPE Alloc gauge
+# HELP Alloc gauge Alloc
+Alloc 1.5
+# TYPE gc_pause_seconds counter
+# UNIT gc_pause_seconds seconds
+# HELP gc_pause_seconds counter gc_pause_seconds
+gc_pause_seconds_total 3
+# TYPE heap_bytes gauge
+# UNIT heap_bytes bytes
+# HELP heap_bytes gauge heap_bytes
+heap_bytes 1024
+# TYPE requests counter
+# HELP requests counter requests_total
+requests_total{code="200"} 7
+requests_created{code="200"} 1682935200.5
+# EOF
+`, buf.String())
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{"text/html", "text/plain", "application/openmetrics-text", "application/json"}
+	testCases := []struct {
+		name   string
+		accept string
+		want   string
+	}{
+		{name: "Negotiate() Test 1", accept: "", want: "text/html"},
+		{name: "Negotiate() Test 2", accept: "*/*", want: "text/html"},
+		{name: "Negotiate() Test 3", accept: "application/json", want: "application/json"},
+		{name: "Negotiate() Test 4", accept: "application/openmetrics-text;version=1.0.0,application/openmetrics-text;version=0.0.1;q=0.75,text/plain;version=0.0.4;q=0.5,*/*;q=0.1",
+			want: "application/openmetrics-text"},
+		{name: "Negotiate() Test 5", accept: "text/*;q=0.5, application/json;q=0.9", want: "application/json"},
+		{name: "Negotiate() Test 6", accept: "text/html;q=0, text/*", want: "text/plain"},
+		{name: "Negotiate() Test 7", accept: "image/png", want: "text/html"},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, Negotiate(test.accept, offers...))
+		})
+	}
+}
+
+func TestWriteOpenMetricsCollisions(t *testing.T) {
+	counters := map[string]int64{
+		"foo":       1,
+		"foo_total": 2,
+	}
+	gauges := map[string]float64{
+		"foo_created": 3,
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteOpenMetrics(&buf, Families(counters, gauges, nil)))
+	assert.Equal(t, `# TYPE foo counter
+# HELP foo counter foo
+foo_total 1
+# TYPE foo_created_gauge gauge
+# HELP foo_created_gauge gauge foo_created_gauge
+foo_created_gauge 3
+# TYPE foo_total_counter counter
+# HELP foo_total_counter counter foo_total_counter
+foo_total_counter_total 2
+# EOF
+`, buf.String())
+}
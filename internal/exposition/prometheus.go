@@ -0,0 +1,242 @@
+// Package exposition выводит хранимые метрики в форматах, которые
+// понимают внешние системы мониторинга.
+package exposition
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/series"
+)
+
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Family — метрики с одним именем и типом.
+type Family struct {
+	Name string
+	Type string
+	Help string
+	// Unit — единица измерения, если она следует из суффикса имени
+	Unit    string
+	Samples []Sample
+}
+
+type Sample struct {
+	Labels []series.Label
+	Value  float64
+	// Created — время появления счётчика; нулевое, если неизвестно
+	Created time.Time
+}
+
+// Families группирует серии хранилища по именам метрик. Имена и метки
+// приводятся к виду, допустимому в Prometheus. Разные исходные имена,
+// которые после этого совпадают между собой или с именами _total и
+// _created счётчика, получают суффикс с типом метрики (и номером, если
+// этого мало); имя без изменений остаётся за метрикой, чьё исходное имя
+// уже было допустимым. Серии, совпавшие после приведения меток,
+// различаются меткой id с исходным ключом. created — время появления
+// счётчиков по их ключам, может быть nil.
+func Families(counters map[string]int64, gauges map[string]float64, created map[string]time.Time) []Family {
+	type familyID struct{ mtype, name string }
+	type entry struct {
+		id      familyID
+		key     string
+		k       series.Key
+		value   float64
+		created time.Time
+	}
+
+	var entries []entry
+	collect := func(mtype, key string, v float64, createdAt time.Time) {
+		k, err := series.Parse(key)
+		if err != nil {
+			// ключ без корректных меток выводится как имя целиком
+			k = series.Key{Name: key}
+		}
+		entries = append(entries, entry{id: familyID{mtype, k.Name}, key: key, k: k, value: v, created: createdAt})
+	}
+	for key, v := range counters {
+		collect("counter", key, float64(v), created[key])
+	}
+	for key, v := range gauges {
+		collect("gauge", key, v, time.Time{})
+	}
+	// счётчики раньше gauge, допустимые имена раньше приведённых
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.id.mtype != b.id.mtype {
+			return a.id.mtype == "counter"
+		}
+		if ca, cb := MetricName(a.id.name) != a.id.name, MetricName(b.id.name) != b.id.name; ca != cb {
+			return cb
+		}
+		if a.id.name != b.id.name {
+			return a.id.name < b.id.name
+		}
+		return a.key < b.key
+	})
+
+	taken := make(map[string]bool)
+	byID := make(map[familyID]*Family)
+	seen := make(map[*Family]map[string]bool)
+	var result []*Family
+	for _, e := range entries {
+		f, ok := byID[e.id]
+		if !ok {
+			name := familyName(MetricName(e.id.name), e.id.mtype, taken)
+			f = &Family{Name: name, Type: e.id.mtype, Help: e.id.mtype + " " + name, Unit: unitOf(strings.TrimSuffix(name, "_total"))}
+			byID[e.id] = f
+			seen[f] = make(map[string]bool)
+			result = append(result, f)
+		}
+
+		labels := make([]series.Label, 0, len(e.k.Labels)+1)
+		used := make(map[string]bool, len(e.k.Labels)+1)
+		for _, l := range e.k.Labels {
+			labels = append(labels, series.Label{Name: uniqueName(LabelName(l.Name), used), Value: l.Value})
+		}
+		sortLabels(labels)
+		if seen[f][labelsString(labels)] {
+			labels = append(labels, series.Label{Name: uniqueName("id", used), Value: e.key})
+			sortLabels(labels)
+		}
+		seen[f][labelsString(labels)] = true
+		f.Samples = append(f.Samples, Sample{Labels: labels, Value: e.value, Created: e.created})
+	}
+
+	families := make([]Family, 0, len(result))
+	for _, f := range result {
+		sort.Slice(f.Samples, func(i, j int) bool {
+			return labelsString(f.Samples[i].Labels) < labelsString(f.Samples[j].Labels)
+		})
+		families = append(families, *f)
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].Name < families[j].Name })
+	return families
+}
+
+// familyName подбирает свободное имя семейства и занимает все имена,
+// под которыми оно выводится: для счётчика это имя без _total, с _total
+// и с _created.
+func familyName(name, mtype string, taken map[string]bool) string {
+	exposed := func(n string) []string {
+		if mtype != "counter" {
+			return []string{n}
+		}
+		base := strings.TrimSuffix(n, "_total")
+		return []string{base, base + "_total", base + "_created"}
+	}
+	free := func(n string) bool {
+		for _, e := range exposed(n) {
+			if taken[e] {
+				return false
+			}
+		}
+		return true
+	}
+
+	candidate := name
+	for i := 1; !free(candidate); i++ {
+		candidate = name + "_" + mtype
+		if i > 1 {
+			candidate += "_" + strconv.Itoa(i)
+		}
+	}
+	for _, e := range exposed(candidate) {
+		taken[e] = true
+	}
+	return candidate
+}
+
+// uniqueName добавляет к имени номер, если оно уже занято, и занимает его.
+func uniqueName(name string, used map[string]bool) string {
+	candidate := name
+	for i := 2; used[candidate]; i++ {
+		candidate = name + "_" + strconv.Itoa(i)
+	}
+	used[candidate] = true
+	return candidate
+}
+
+func sortLabels(labels []series.Label) {
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+}
+
+// WritePrometheus выводит метрики в текстовом формате Prometheus 0.0.4.
+func WritePrometheus(w io.Writer, families []Family) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		bw.WriteString("# HELP " + f.Name + " " + escapeHelp(f.Help) + "\n")
+		bw.WriteString("# TYPE " + f.Name + " " + f.Type + "\n")
+		for _, smp := range f.Samples {
+			bw.WriteString(f.Name + labelsString(smp.Labels) + " " + FormatValue(smp.Value) + "\n")
+		}
+	}
+	return bw.Flush()
+}
+
+// MetricName заменяет символы, недопустимые в имени метрики, на '_'.
+func MetricName(s string) string {
+	return sanitize(s, func(c byte, first bool) bool {
+		return c == '_' || c == ':' || isLetter(c) || (!first && isDigit(c))
+	})
+}
+
+// LabelName заменяет символы, недопустимые в имени метки, на '_'.
+func LabelName(s string) string {
+	return sanitize(s, func(c byte, first bool) bool {
+		return c == '_' || isLetter(c) || (!first && isDigit(c))
+	})
+}
+
+func sanitize(s string, valid func(c byte, first bool) bool) string {
+	if s == "" {
+		return "_"
+	}
+	b := []byte(s)
+	for i, c := range b {
+		if !valid(c, i == 0) {
+			b[i] = '_'
+		}
+	}
+	// имя, начинавшееся с цифры, сохраняет её после префикса
+	if isDigit(s[0]) {
+		return "_" + s[:1] + string(b[1:])
+	}
+	return string(b)
+}
+
+func isLetter(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+// FormatValue форматирует значение так, как его ожидает Prometheus.
+func FormatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case v == math.Trunc(v) && math.Abs(v) < 1e15:
+		// целые значения, в том числе счётчики, выводятся без экспоненты
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func labelsString(labels []series.Label) string {
+	return series.Key{Labels: labels}.String()
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
@@ -0,0 +1,97 @@
+package exposition
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	counters := map[string]int64{
+		"PollCount":                               5,
+		`http_requests{method="GET",code="200"}`:  12345678,
+		`http_requests{method="POST",code="500"}`: 1,
+		"same": 1,
+	}
+	gauges := map[string]float64{
+		"Alloc":             1.5,
+		"cpu.usage%":        0.25,
+		"9lives":            math.Inf(1),
+		"same":              2,
+		`temp{room-id="1"}`: -3,
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WritePrometheus(&buf, Families(counters, gauges, nil)))
+	assert.Equal(t, `# HELP Alloc gauge Alloc
+# TYPE Alloc gauge
+Alloc 1.5
+# HELP PollCount counter PollCount
+# TYPE PollCount counter
+PollCount 5
+# HELP _9lives gauge _9lives
+# TYPE _9lives gauge
+_9lives +Inf
+# HELP cpu_usage_ gauge cpu_usage_
+# TYPE cpu_usage_ gauge
+cpu_usage_ 0.25
+# HELP http_requests counter http_requests
+# TYPE http_requests counter
+http_requests{code="200",method="GET"} 12345678
+http_requests{code="500",method="POST"} 1
+# HELP same counter same
+# TYPE same counter
+same 1
+# HELP same_gauge gauge same_gauge
+# TYPE same_gauge gauge
+same_gauge 2
+# HELP temp gauge temp
+# TYPE temp gauge
+temp{room_id="1"} -3
+`, buf.String())
+}
+
+func TestNames(t *testing.T) {
+	assert.Equal(t, "node:cpu_total", MetricName("node:cpu-total"))
+	assert.Equal(t, "_1m", MetricName("1m"))
+	assert.Equal(t, "_", MetricName(""))
+	assert.Equal(t, "a_b", LabelName("a:b"))
+	assert.Equal(t, "1e-05", FormatValue(0.00001))
+	assert.Equal(t, "NaN", FormatValue(math.NaN()))
+}
+
+func TestFamiliesCollisions(t *testing.T) {
+	counters := map[string]int64{
+		"a.b": 1,
+		"a_b": 2,
+	}
+	gauges := map[string]float64{
+		"a-b":                3,
+		`m{a.b="1"}`:         4,
+		`m{a_b="1"}`:         5,
+		`l{a.b="1",a_b="2"}`: 6,
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WritePrometheus(&buf, Families(counters, gauges, nil)))
+	assert.Equal(t, `# HELP a_b counter a_b
+# TYPE a_b counter
+a_b 2
+# HELP a_b_counter counter a_b_counter
+# TYPE a_b_counter counter
+a_b_counter 1
+# HELP a_b_gauge gauge a_b_gauge
+# TYPE a_b_gauge gauge
+a_b_gauge 3
+# HELP l gauge l
+# TYPE l gauge
+l{a_b="1",a_b_2="2"} 6
+# HELP m gauge m
+# TYPE m gauge
+m{a_b="1",id="m{a_b=\"1\"}"} 5
+m{a_b="1"} 4
+`, buf.String())
+}
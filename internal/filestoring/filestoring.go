@@ -2,7 +2,9 @@ package filestoring
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path"
 	"time"
@@ -10,15 +12,16 @@ import (
 	"github.com/amidvn/go-metrics/internal/storage"
 )
 
-func Restore(s *storage.MemStorage, filePath string) {
-	file, err := os.ReadFile(filePath)
-	if err != nil {
-		fmt.Println(err)
+// Restore загружает снапшот из файла в хранилище и возвращает количество
+// восстановленных серий. Отсутствие файла ошибкой не считается: это
+// первый запуск сервера.
+func Restore(s *storage.MemStorage, filePath string) (int, error) {
+	data, err := Load(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
 	}
-
-	var data storage.AllMetrics
-	if err := json.Unmarshal(file, &data); err != nil {
-		fmt.Println(err)
+	if err != nil {
+		return 0, fmt.Errorf("restore from %s: %w", filePath, err)
 	}
 
 	if len(data.Counter) != 0 {
@@ -27,21 +30,58 @@ func Restore(s *storage.MemStorage, filePath string) {
 	if len(data.Gauge) != 0 {
 		s.UpdateGaugeData(data.Gauge)
 	}
+	return len(data.Counter) + len(data.Gauge), nil
+}
+
+// Load читает снапшот метрик из файла, не изменяя хранилище.
+func Load(filePath string) (storage.AllMetrics, error) {
+	var data storage.AllMetrics
+
+	file, err := os.ReadFile(filePath)
+	if err != nil {
+		return data, err
+	}
+
+	if err := json.Unmarshal(file, &data); err != nil {
+		return data, err
+	}
+
+	return data, nil
 }
 
 func Dump(s *storage.MemStorage, filePath string, storeInterval int) {
-	dir, _ := path.Split(filePath)
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		err := os.MkdirAll(dir, 0666)
-		if err != nil {
-			fmt.Println(err)
-		}
+	if err := ensureDir(filePath); err != nil {
+		fmt.Println(err)
 	}
 	pollTicker := time.NewTicker(time.Duration(storeInterval) * time.Second)
 	defer pollTicker.Stop()
 	for range pollTicker.C {
-		saveJSON(s, filePath)
+		err := saveJSON(s, filePath)
+		if err != nil {
+			fmt.Println(err)
+		}
+		s.SetDumpResult(err)
+	}
+}
+
+// Save записывает текущее состояние хранилища в файл снапшота,
+// при необходимости создавая каталог.
+func Save(s *storage.MemStorage, filePath string) error {
+	if err := ensureDir(filePath); err != nil {
+		return err
+	}
+	return saveJSON(s, filePath)
+}
+
+func ensureDir(filePath string) error {
+	dir, _ := path.Split(filePath)
+	if dir == "" {
+		return nil
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return os.MkdirAll(dir, 0755)
 	}
+	return nil
 }
 
 func saveJSON(s *storage.MemStorage, filePath string) error {
@@ -56,5 +96,11 @@ func saveJSON(s *storage.MemStorage, filePath string) error {
 		return err
 	}
 
-	return os.WriteFile(filePath, data, 0666)
+	// пишем во временный файл и переименовываем, чтобы сбой во время записи
+	// не оставил на диске обрезанный снапшот
+	tmp := filePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filePath)
 }
@@ -0,0 +1,45 @@
+package filestoring
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestore(t *testing.T) {
+	dir := t.TempDir()
+
+	saved := storage.New(0, "", false)
+	saved.UpdateCounter(context.Background(), "PollCount", 3)
+	saved.UpdateGauge(context.Background(), "Alloc", 1.5)
+	require.NoError(t, Save(saved, filepath.Join(dir, "metrics.json")))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "corrupt.json"), []byte(`{"gauge": {`), 0666))
+
+	testCases := []struct {
+		name    string
+		file    string
+		series  int
+		wantErr bool
+	}{
+		{name: "Restore() Test 1", file: "metrics.json", series: 2},
+		{name: "Restore() Test 2", file: "missing.json", series: 0},
+		{name: "Restore() Test 3", file: "corrupt.json", series: 0, wantErr: true},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			s := storage.New(0, "", false)
+			n, err := Restore(s, filepath.Join(dir, test.file))
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, test.series, n)
+		})
+	}
+}
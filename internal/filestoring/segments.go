@@ -0,0 +1,805 @@
+package filestoring
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/storage"
+)
+
+const (
+	segmentExt = ".seg"
+	indexExt   = ".idx"
+
+	// заголовок записи: длина полезной нагрузки и её контрольная сумма
+	recordHeaderSize = 8
+
+	// maxRecordName — наибольшая длина имени серии в записи сегмента
+	maxRecordName = 64 << 10
+	// maxRecordSize — наибольший размер полезной нагрузки: имя с его
+	// длиной, тип, время и значение
+	maxRecordSize = binary.MaxVarintLen32 + maxRecordName + 1 + binary.MaxVarintLen64 + 8
+)
+
+var (
+	errCorruptRecord = errors.New("corrupt segment record")
+	errNameTooLong   = errors.New("series name is too long")
+)
+
+// SegmentOptions задаёт параметры хранения истории.
+type SegmentOptions struct {
+	// Partition — длительность окна, которое покрывает один сегмент.
+	Partition time.Duration
+	// CompactAfter — возраст, после которого закрытые сегменты сливаются
+	// в сегменты размером CompactPartition.
+	CompactAfter     time.Duration
+	CompactPartition time.Duration
+	// Retention — сколько хранить историю; 0 — хранить всегда.
+	Retention time.Duration
+}
+
+var DefaultSegmentOptions = SegmentOptions{
+	Partition:        time.Hour,
+	CompactAfter:     24 * time.Hour,
+	CompactPartition: 24 * time.Hour,
+	Retention:        7 * 24 * time.Hour,
+}
+
+// segmentIndex — индекс сегмента: смещения записей каждой серии
+// и временные границы содержимого.
+type segmentIndex struct {
+	MinTime int64              `json:"min_time"`
+	MaxTime int64              `json:"max_time"`
+	Series  map[string][]int64 `json:"series"`
+}
+
+type segment struct {
+	start, end time.Time
+	path       string
+	index      *segmentIndex
+}
+
+// SegmentStore — журнал истории серий, разбитый на сегменты по времени.
+// Записи только дописываются в активный сегмент; закрытые сегменты
+// снабжаются индексом, со временем сливаются и удаляются по сроку хранения.
+type SegmentStore struct {
+	mu     sync.Mutex
+	dir    string
+	opts   SegmentOptions
+	sealed []*segment
+
+	active *segment
+	file   *os.File
+	writer *bufio.Writer
+	offset int64
+}
+
+func OpenSegments(dir string, opts SegmentOptions) (*SegmentStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	st := &SegmentStore{dir: dir, opts: opts}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []*segment
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(e.Name(), segmentExt+".tmp") || strings.HasSuffix(e.Name(), indexExt+".tmp") {
+			// недописанный результат слияния или индекса
+			if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if filepath.Ext(e.Name()) != segmentExt {
+			continue
+		}
+		seg, err := parseSegmentName(dir, e.Name())
+		if err != nil {
+			continue
+		}
+		segments = append(segments, seg)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].start.Before(segments[j].start) })
+	if segments, err = removeMerged(segments); err != nil {
+		return nil, err
+	}
+
+	for i, seg := range segments {
+		if i == len(segments)-1 && !fileExists(indexPath(seg.path)) {
+			// последний сегмент без индекса — активный на момент остановки
+			if err := st.reopenActive(seg); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !fileExists(indexPath(seg.path)) {
+			idx, _, err := scanSegment(seg.path)
+			if err != nil {
+				return nil, err
+			}
+			if err := writeIndex(seg.path, idx); err != nil {
+				return nil, err
+			}
+		}
+		st.sealed = append(st.sealed, seg)
+	}
+
+	return st, nil
+}
+
+// Append дописывает значения в активный сегмент, при необходимости
+// закрывая его и открывая сегмент для нового окна. Запоздавшие значения
+// для окон раньше активного дописываются в сегмент, которому
+// принадлежит их время.
+func (st *SegmentStore) Append(ctx context.Context, samples ...storage.Sample) error {
+	for _, smp := range samples {
+		if len(smp.Name) > maxRecordName {
+			return fmt.Errorf("%.64s...: %w", smp.Name, errNameTooLong)
+		}
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for _, smp := range samples {
+		start := smp.Timestamp.Truncate(st.opts.Partition)
+		if st.active == nil || !start.Equal(st.active.start) {
+			if st.isLate(start) {
+				if err := st.appendLate(smp); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := st.rotate(start); err != nil {
+				return err
+			}
+		}
+		if err := st.writeRecord(smp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Range возвращает значения серии name с from по to включительно,
+// упорядоченные по времени.
+func (st *SegmentStore) Range(ctx context.Context, name string, from, to time.Time) ([]storage.Sample, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.writer != nil {
+		if err := st.writer.Flush(); err != nil {
+			return nil, err
+		}
+	}
+
+	segments := append([]*segment{}, st.sealed...)
+	if st.active != nil {
+		segments = append(segments, st.active)
+	}
+
+	var result []storage.Sample
+	for _, seg := range segments {
+		idx, err := st.loadIndex(seg)
+		if err != nil {
+			return nil, err
+		}
+		offsets, ok := idx.Series[name]
+		if !ok || idx.MaxTime < from.UnixNano() || idx.MinTime > to.UnixNano() {
+			continue
+		}
+		samples, err := readRecords(seg.path, offsets)
+		if err != nil {
+			return nil, err
+		}
+		for _, smp := range samples {
+			if !smp.Timestamp.Before(from) && !smp.Timestamp.After(to) {
+				result = append(result, smp)
+			}
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Timestamp.Before(result[j].Timestamp) })
+	return result, nil
+}
+
+// Flush сбрасывает буфер активного сегмента на диск.
+func (st *SegmentStore) Flush() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.writer == nil {
+		return nil
+	}
+	if err := st.writer.Flush(); err != nil {
+		return err
+	}
+	return st.file.Sync()
+}
+
+// Close закрывает активный сегмент и записывает его индекс.
+func (st *SegmentStore) Close() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.seal()
+}
+
+// Maintain периодически сбрасывает буфер на диск, сливает старые
+// сегменты и удаляет сегменты старше срока хранения.
+func (st *SegmentStore) Maintain(interval int) {
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if err := st.Flush(); err != nil {
+			fmt.Println(err)
+		}
+		if err := st.Compact(now); err != nil {
+			fmt.Println(err)
+		}
+		if err := st.ApplyRetention(now); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// Compact сливает закрытые сегменты старше CompactAfter в сегменты
+// шириной CompactPartition.
+func (st *SegmentStore) Compact(now time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.opts.CompactPartition <= st.opts.Partition {
+		return nil
+	}
+	cutoff := now.Add(-st.opts.CompactAfter)
+
+	groups := make(map[time.Time][]*segment)
+	var kept []*segment
+	for _, seg := range st.sealed {
+		if seg.end.After(cutoff) || seg.end.Sub(seg.start) >= st.opts.CompactPartition {
+			kept = append(kept, seg)
+			continue
+		}
+		window := seg.start.Truncate(st.opts.CompactPartition)
+		groups[window] = append(groups[window], seg)
+	}
+
+	var firstErr error
+	for window, group := range groups {
+		if len(group) == 1 {
+			kept = append(kept, group[0])
+			continue
+		}
+		merged, err := st.merge(window, window.Add(st.opts.CompactPartition), group)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if merged == nil {
+			// исходные сегменты удаляются только после успешного слияния
+			kept = append(kept, group...)
+			continue
+		}
+		kept = append(kept, merged)
+	}
+
+	sort.Slice(kept, func(i, j int) bool { return kept[i].start.Before(kept[j].start) })
+	st.sealed = kept
+	return firstErr
+}
+
+// ApplyRetention удаляет сегменты, все значения которых старше срока хранения.
+func (st *SegmentStore) ApplyRetention(now time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.opts.Retention == 0 {
+		return nil
+	}
+	cutoff := now.Add(-st.opts.Retention).UnixNano()
+
+	var kept []*segment
+	for _, seg := range st.sealed {
+		idx, err := st.loadIndex(seg)
+		if err != nil {
+			return err
+		}
+		if idx.MaxTime >= cutoff {
+			kept = append(kept, seg)
+			continue
+		}
+		if err := removeSegment(seg.path); err != nil {
+			return err
+		}
+	}
+	st.sealed = kept
+	return nil
+}
+
+func (st *SegmentStore) rotate(start time.Time) error {
+	if err := st.seal(); err != nil {
+		return err
+	}
+
+	// окно уже было закрыто при предыдущей остановке — продолжаем его
+	if n := len(st.sealed); n > 0 && st.sealed[n-1].start.Equal(start) {
+		seg := st.sealed[n-1]
+		if err := os.Remove(indexPath(seg.path)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		st.sealed = st.sealed[:n-1]
+		return st.reopenActive(seg)
+	}
+
+	seg := &segment{
+		start: start,
+		end:   start.Add(st.opts.Partition),
+		index: newSegmentIndex(),
+	}
+	seg.path = filepath.Join(st.dir, segmentName(seg.start, seg.end))
+	if fileExists(seg.path) {
+		// файл остался от прежнего запуска: смещения считаются от его
+		// фактического размера
+		if err := os.Remove(indexPath(seg.path)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return st.reopenActive(seg)
+	}
+
+	file, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	st.active, st.file, st.offset = seg, file, 0
+	st.writer = bufio.NewWriter(file)
+	return nil
+}
+
+// isLate сообщает, относится ли окно start к уже закрытому прошлому:
+// оно раньше активного сегмента или попадает внутрь закрытого.
+func (st *SegmentStore) isLate(start time.Time) bool {
+	if st.active != nil {
+		return start.Before(st.active.start)
+	}
+	n := len(st.sealed)
+	if n == 0 {
+		return false
+	}
+	last := st.sealed[n-1]
+	return start.Before(last.start) || start.Before(last.end) && !start.Equal(last.start)
+}
+
+// appendLate дописывает значение в закрытый сегмент, покрывающий его
+// время, и обновляет индекс этого сегмента. Если такого сегмента нет,
+// создаётся закрытый сегмент для окна значения.
+func (st *SegmentStore) appendLate(smp storage.Sample) error {
+	var seg *segment
+	for _, s := range st.sealed {
+		if !smp.Timestamp.Before(s.start) && smp.Timestamp.Before(s.end) {
+			seg = s
+			break
+		}
+	}
+	if seg == nil {
+		start := smp.Timestamp.Truncate(st.opts.Partition)
+		seg = &segment{start: start, end: start.Add(st.opts.Partition), index: newSegmentIndex()}
+		seg.path = filepath.Join(st.dir, segmentName(seg.start, seg.end))
+		st.sealed = append(st.sealed, seg)
+		sort.Slice(st.sealed, func(i, j int) bool { return st.sealed[i].start.Before(st.sealed[j].start) })
+	}
+	idx, err := st.loadIndex(seg)
+	if errors.Is(err, os.ErrNotExist) {
+		idx = newSegmentIndex()
+		seg.index = idx
+	} else if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rec := encodeRecord(smp)
+	if _, err := file.Write(rec); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	idx.add(smp.Name, info.Size(), smp.Timestamp.UnixNano())
+	return writeIndex(seg.path, idx)
+}
+
+func (st *SegmentStore) reopenActive(seg *segment) error {
+	idx, size, err := scanSegment(seg.path)
+	if err != nil {
+		return err
+	}
+	// отбрасываем недописанный хвост, оставшийся после аварийной остановки
+	if err := os.Truncate(seg.path, size); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(seg.path, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	seg.index = idx
+	st.active, st.file, st.offset = seg, file, size
+	st.writer = bufio.NewWriter(file)
+	return nil
+}
+
+func (st *SegmentStore) seal() error {
+	if st.active == nil {
+		return nil
+	}
+	if err := st.writer.Flush(); err != nil {
+		return err
+	}
+	if err := st.file.Sync(); err != nil {
+		return err
+	}
+	if err := st.file.Close(); err != nil {
+		return err
+	}
+	if err := writeIndex(st.active.path, st.active.index); err != nil {
+		return err
+	}
+	st.sealed = append(st.sealed, st.active)
+	st.active, st.file, st.writer = nil, nil, nil
+	return nil
+}
+
+func (st *SegmentStore) writeRecord(smp storage.Sample) error {
+	rec := encodeRecord(smp)
+	if _, err := st.writer.Write(rec); err != nil {
+		return err
+	}
+	st.active.index.add(smp.Name, st.offset, smp.Timestamp.UnixNano())
+	st.offset += int64(len(rec))
+	return nil
+}
+
+// loadIndex возвращает индекс сегмента, читая его с диска при первом обращении.
+func (st *SegmentStore) loadIndex(seg *segment) (*segmentIndex, error) {
+	if seg.index != nil {
+		return seg.index, nil
+	}
+	data, err := os.ReadFile(indexPath(seg.path))
+	if err != nil {
+		return nil, err
+	}
+	idx := newSegmentIndex()
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, err
+	}
+	seg.index = idx
+	return idx, nil
+}
+
+func (st *SegmentStore) merge(start, end time.Time, group []*segment) (*segment, error) {
+	var samples []storage.Sample
+	for _, seg := range group {
+		_, err := readSegment(seg.path, func(smp storage.Sample, _ int64) {
+			samples = append(samples, smp)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Timestamp.Before(samples[j].Timestamp) })
+
+	merged := &segment{start: start, end: end, index: newSegmentIndex()}
+	merged.path = filepath.Join(st.dir, segmentName(start, end))
+
+	tmp := merged.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(file)
+	var offset int64
+	for _, smp := range samples {
+		rec := encodeRecord(smp)
+		if _, err := w.Write(rec); err != nil {
+			file.Close()
+			return nil, err
+		}
+		merged.index.add(smp.Name, offset, smp.Timestamp.UnixNano())
+		offset += int64(len(rec))
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+
+	// индекс пишется раньше переименования, чтобы после сбоя
+	// сегмент без индекса не был принят за активный
+	if err := writeIndex(merged.path, merged.index); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, merged.path); err != nil {
+		return nil, err
+	}
+	// после переименования слитый сегмент уже содержит все значения;
+	// исходные сегменты, которые не удалось удалить, уберёт OpenSegments
+	var firstErr error
+	for _, seg := range group {
+		if seg.path == merged.path {
+			continue
+		}
+		if err := removeSegment(seg.path); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return merged, firstErr
+}
+
+// removeMerged удаляет сегменты, окно которых целиком покрыто более
+// широким сегментом с индексом. Такие сегменты остаются, если процесс
+// остановился между переименованием слитого сегмента и удалением
+// исходных; их значения уже есть в слитом сегменте. segments
+// упорядочены по началу окна.
+func removeMerged(segments []*segment) ([]*segment, error) {
+	var kept []*segment
+	for _, seg := range segments {
+		if coveredBy(seg, segments) {
+			if err := removeSegment(seg.path); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		kept = append(kept, seg)
+	}
+	return kept, nil
+}
+
+func coveredBy(seg *segment, segments []*segment) bool {
+	for _, other := range segments {
+		if other == seg || other.end.Sub(other.start) <= seg.end.Sub(seg.start) {
+			continue
+		}
+		if !other.start.After(seg.start) && !other.end.Before(seg.end) && fileExists(indexPath(other.path)) {
+			return true
+		}
+	}
+	return false
+}
+
+func newSegmentIndex() *segmentIndex {
+	return &segmentIndex{
+		MinTime: math.MaxInt64,
+		MaxTime: math.MinInt64,
+		Series:  make(map[string][]int64),
+	}
+}
+
+func (idx *segmentIndex) add(name string, offset int64, ts int64) {
+	idx.Series[name] = append(idx.Series[name], offset)
+	if ts < idx.MinTime {
+		idx.MinTime = ts
+	}
+	if ts > idx.MaxTime {
+		idx.MaxTime = ts
+	}
+}
+
+// Запись сегмента: [длина uint32][crc32 uint32][полезная нагрузка], где
+// полезная нагрузка — [длина имени uvarint][имя][тип byte][время varint][значение float64].
+func encodeRecord(smp storage.Sample) []byte {
+	payload := make([]byte, 0, len(smp.Name)+2*binary.MaxVarintLen64+9)
+	payload = binary.AppendUvarint(payload, uint64(len(smp.Name)))
+	payload = append(payload, smp.Name...)
+	payload = append(payload, encodeType(smp.MType))
+	payload = binary.AppendVarint(payload, smp.Timestamp.UnixNano())
+	payload = binary.LittleEndian.AppendUint64(payload, math.Float64bits(smp.Value))
+
+	rec := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(rec[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(rec[4:8], crc32.ChecksumIEEE(payload))
+	return append(rec, payload...)
+}
+
+func decodeRecord(payload []byte) (storage.Sample, error) {
+	var smp storage.Sample
+
+	n, l := binary.Uvarint(payload)
+	if l <= 0 || uint64(len(payload)-l) < n+1 {
+		return smp, errCorruptRecord
+	}
+	payload = payload[l:]
+	smp.Name = string(payload[:n])
+	smp.MType = decodeType(payload[n])
+	payload = payload[n+1:]
+
+	ts, l := binary.Varint(payload)
+	if l <= 0 || len(payload)-l != 8 {
+		return smp, errCorruptRecord
+	}
+	smp.Timestamp = time.Unix(0, ts)
+	smp.Value = math.Float64frombits(binary.LittleEndian.Uint64(payload[l:]))
+	return smp, nil
+}
+
+func readRecord(r io.Reader) (storage.Sample, int64, error) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return storage.Sample{}, 0, err
+	}
+	// длина проверяется до выделения памяти: в повреждённом заголовке
+	// она может быть любой
+	size := binary.LittleEndian.Uint32(header[0:4])
+	if size > maxRecordSize {
+		return storage.Sample{}, 0, errCorruptRecord
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return storage.Sample{}, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:8]) {
+		return storage.Sample{}, 0, errCorruptRecord
+	}
+	smp, err := decodeRecord(payload)
+	return smp, int64(recordHeaderSize + len(payload)), err
+}
+
+func readRecords(path string, offsets []int64) ([]storage.Sample, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	result := make([]storage.Sample, 0, len(offsets))
+	for _, off := range offsets {
+		smp, _, err := readRecord(io.NewSectionReader(file, off, math.MaxInt64-off))
+		if err != nil {
+			return nil, fmt.Errorf("%s at offset %d: %w", path, off, err)
+		}
+		result = append(result, smp)
+	}
+	return result, nil
+}
+
+// readSegment передаёт fn все целые записи сегмента вместе с их смещениями
+// и возвращает размер корректной части файла.
+func readSegment(path string, fn func(smp storage.Sample, offset int64)) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var size int64
+	r := bufio.NewReader(file)
+	for {
+		smp, n, err := readRecord(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errCorruptRecord) {
+				return size, nil
+			}
+			return 0, err
+		}
+		fn(smp, size)
+		size += n
+	}
+}
+
+func scanSegment(path string) (*segmentIndex, int64, error) {
+	idx := newSegmentIndex()
+	size, err := readSegment(path, func(smp storage.Sample, offset int64) {
+		idx.add(smp.Name, offset, smp.Timestamp.UnixNano())
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return idx, size, nil
+}
+
+func writeIndex(segPath string, idx *segmentIndex) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	tmp := indexPath(segPath) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, indexPath(segPath))
+}
+
+// removeSegment удаляет сегмент и его индекс. Сегмент удаляется первым,
+// чтобы после сбоя не остался сегмент без индекса, похожий на активный.
+func removeSegment(segPath string) error {
+	if err := os.Remove(segPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(indexPath(segPath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func segmentName(start, end time.Time) string {
+	return fmt.Sprintf("%d-%d%s", start.Unix(), end.Unix(), segmentExt)
+}
+
+func parseSegmentName(dir, name string) (*segment, error) {
+	bounds := strings.SplitN(strings.TrimSuffix(name, segmentExt), "-", 2)
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("invalid segment name %q", name)
+	}
+	start, err := strconv.ParseInt(bounds[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	end, err := strconv.ParseInt(bounds[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &segment{
+		start: time.Unix(start, 0),
+		end:   time.Unix(end, 0),
+		path:  filepath.Join(dir, name),
+	}, nil
+}
+
+func indexPath(segPath string) string {
+	return strings.TrimSuffix(segPath, segmentExt) + indexExt
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func encodeType(t string) byte {
+	if t == "counter" {
+		return 1
+	}
+	return 0
+}
+
+func decodeType(b byte) string {
+	if b == 1 {
+		return "counter"
+	}
+	return "gauge"
+}
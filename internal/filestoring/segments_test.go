@@ -0,0 +1,251 @@
+package filestoring
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSegmentStoreRange(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	base := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	st, err := OpenSegments(dir, DefaultSegmentOptions)
+	require.NoError(t, err)
+	require.NoError(t, st.Append(ctx,
+		storage.Sample{Name: "Alloc", MType: "gauge", Timestamp: base, Value: 1},
+		storage.Sample{Name: "PollCount", MType: "counter", Timestamp: base.Add(time.Minute), Value: 5},
+		storage.Sample{Name: "Alloc", MType: "gauge", Timestamp: base.Add(90 * time.Minute), Value: 2},
+		storage.Sample{Name: "Alloc", MType: "gauge", Timestamp: base.Add(150 * time.Minute), Value: 3},
+	))
+
+	testCases := []struct {
+		name   string
+		series string
+		from   time.Time
+		to     time.Time
+		values []float64
+	}{
+		{name: "Range() Test 1", series: "Alloc", from: base, to: base.Add(3 * time.Hour), values: []float64{1, 2, 3}},
+		{name: "Range() Test 2", series: "Alloc", from: base.Add(time.Hour), to: base.Add(2 * time.Hour), values: []float64{2}},
+		{name: "Range() Test 3", series: "PollCount", from: base, to: base.Add(time.Hour), values: []float64{5}},
+		{name: "Range() Test 4", series: "Unknown", from: base, to: base.Add(time.Hour), values: nil},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			samples, err := st.Range(ctx, test.series, test.from, test.to)
+			require.NoError(t, err)
+			var values []float64
+			for _, s := range samples {
+				values = append(values, s.Value)
+			}
+			assert.Equal(t, test.values, values)
+		})
+	}
+
+	require.NoError(t, st.Close())
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"+indexExt))
+	assert.Len(t, matches, 3)
+}
+
+func TestSegmentStoreReopen(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	base := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	st, err := OpenSegments(dir, DefaultSegmentOptions)
+	require.NoError(t, err)
+	require.NoError(t, st.Append(ctx, storage.Sample{Name: "Alloc", MType: "gauge", Timestamp: base, Value: 1}))
+	require.NoError(t, st.Flush())
+
+	// имитируем аварийную остановку с недописанной записью
+	seg := filepath.Join(dir, segmentName(base, base.Add(time.Hour)))
+	f, err := os.OpenFile(seg, os.O_WRONLY|os.O_APPEND, 0666)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{42, 0, 0})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	st, err = OpenSegments(dir, DefaultSegmentOptions)
+	require.NoError(t, err)
+	require.NoError(t, st.Append(ctx, storage.Sample{Name: "Alloc", MType: "gauge", Timestamp: base.Add(time.Minute), Value: 2}))
+
+	samples, err := st.Range(ctx, "Alloc", base, base.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.Equal(t, "gauge", samples[1].MType)
+	assert.Equal(t, 2.0, samples[1].Value)
+
+	// после штатной остановки запись в то же окно продолжает сегмент
+	require.NoError(t, st.Close())
+	st, err = OpenSegments(dir, DefaultSegmentOptions)
+	require.NoError(t, err)
+	require.NoError(t, st.Append(ctx, storage.Sample{Name: "Alloc", MType: "gauge", Timestamp: base.Add(2 * time.Minute), Value: 3}))
+
+	samples, err = st.Range(ctx, "Alloc", base, base.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, samples, 3)
+	assert.Equal(t, 3.0, samples[2].Value)
+}
+
+func TestReadRecord(t *testing.T) {
+	valid := encodeRecord(storage.Sample{Name: "Alloc", MType: "gauge", Timestamp: time.Unix(0, 1), Value: 1.5})
+	header := func(size uint32) []byte {
+		rec := make([]byte, recordHeaderSize)
+		binary.LittleEndian.PutUint32(rec[0:4], size)
+		return rec
+	}
+	badCRC := append([]byte(nil), valid...)
+	badCRC[len(badCRC)-1]++
+
+	testCases := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{name: "readRecord() Test 1", data: valid},
+		{name: "readRecord() Test 2", data: header(math.MaxUint32), wantErr: errCorruptRecord},
+		{name: "readRecord() Test 3", data: header(maxRecordSize + 1), wantErr: errCorruptRecord},
+		{name: "readRecord() Test 4", data: badCRC, wantErr: errCorruptRecord},
+		{name: "readRecord() Test 5", data: valid[:len(valid)-1], wantErr: io.ErrUnexpectedEOF},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			smp, n, err := readRecord(bytes.NewReader(test.data))
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(valid)), n)
+			assert.Equal(t, "Alloc", smp.Name)
+			assert.Equal(t, 1.5, smp.Value)
+		})
+	}
+
+	// имя, которое не поместится в запись, не принимается
+	st, err := OpenSegments(t.TempDir(), DefaultSegmentOptions)
+	require.NoError(t, err)
+	err = st.Append(context.Background(), storage.Sample{Name: strings.Repeat("a", maxRecordName+1), MType: "gauge", Timestamp: time.Now()})
+	assert.ErrorIs(t, err, errNameTooLong)
+}
+
+func TestSegmentStoreLateSamples(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	base := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	st, err := OpenSegments(dir, DefaultSegmentOptions)
+	require.NoError(t, err)
+	require.NoError(t, st.Append(ctx,
+		storage.Sample{Name: "Alloc", MType: "gauge", Timestamp: base, Value: 1},
+		storage.Sample{Name: "Alloc", MType: "gauge", Timestamp: base.Add(2 * time.Hour), Value: 3},
+		// запоздавшие значения: для закрытого окна и для окна без сегмента
+		storage.Sample{Name: "Alloc", MType: "gauge", Timestamp: base.Add(30 * time.Minute), Value: 2},
+		storage.Sample{Name: "Alloc", MType: "gauge", Timestamp: base.Add(-time.Hour), Value: 0},
+	))
+
+	samples, err := st.Range(ctx, "Alloc", base.Add(-time.Hour), base.Add(3*time.Hour))
+	require.NoError(t, err)
+	var values []float64
+	for _, s := range samples {
+		values = append(values, s.Value)
+	}
+	assert.Equal(t, []float64{0, 1, 2, 3}, values)
+
+	// значения лежат в сегментах своих окон, индексы совпадают с файлами
+	require.NoError(t, st.Close())
+	st, err = OpenSegments(dir, DefaultSegmentOptions)
+	require.NoError(t, err)
+	samples, err = st.Range(ctx, "Alloc", base, base.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.Equal(t, 2.0, samples[1].Value)
+
+	require.NoError(t, st.Append(ctx, storage.Sample{Name: "Alloc", MType: "gauge", Timestamp: base.Add(45 * time.Minute), Value: 4}))
+	samples, err = st.Range(ctx, "Alloc", base, base.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, samples, 3)
+	assert.Equal(t, 4.0, samples[2].Value)
+}
+
+func TestSegmentStoreCompactAndRetention(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	base := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	st, err := OpenSegments(dir, DefaultSegmentOptions)
+	require.NoError(t, err)
+	for h := 0; h < 5; h++ {
+		require.NoError(t, st.Append(ctx, storage.Sample{Name: "Alloc", MType: "gauge", Timestamp: base.Add(time.Duration(h) * time.Hour), Value: float64(h)}))
+	}
+	require.NoError(t, st.Append(ctx, storage.Sample{Name: "Alloc", MType: "gauge", Timestamp: base.Add(48 * time.Hour), Value: 48}))
+
+	require.NoError(t, st.Compact(base.Add(48*time.Hour)))
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	assert.Len(t, segments, 2)
+
+	samples, err := st.Range(ctx, "Alloc", base, base.Add(72*time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, samples, 6)
+
+	require.NoError(t, st.ApplyRetention(base.Add(9*24*time.Hour)))
+	samples, err = st.Range(ctx, "Alloc", base, base.Add(72*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	assert.Equal(t, 48.0, samples[0].Value)
+}
+
+func TestSegmentStoreCompactRecovery(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	base := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	st, err := OpenSegments(dir, DefaultSegmentOptions)
+	require.NoError(t, err)
+	for h := 0; h < 3; h++ {
+		require.NoError(t, st.Append(ctx, storage.Sample{Name: "Alloc", MType: "gauge", Timestamp: base.Add(time.Duration(h) * time.Hour), Value: float64(h)}))
+	}
+	require.NoError(t, st.Append(ctx, storage.Sample{Name: "Alloc", MType: "gauge", Timestamp: base.Add(48 * time.Hour), Value: 48}))
+
+	// копии исходных сегментов: после сбоя между переименованием слитого
+	// сегмента и удалением исходных на диске остаются и те и другие
+	originals := make(map[string][]byte)
+	for _, seg := range st.sealed {
+		for _, path := range []string{seg.path, indexPath(seg.path)} {
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			originals[path] = data
+		}
+	}
+	require.NoError(t, st.Compact(base.Add(48*time.Hour)))
+	require.NoError(t, st.Close())
+	for path, data := range originals {
+		require.NoError(t, os.WriteFile(path, data, 0666))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "1-2"+segmentExt+".tmp"), []byte("partial"), 0666))
+
+	st, err = OpenSegments(dir, DefaultSegmentOptions)
+	require.NoError(t, err)
+	defer st.Close()
+	samples, err := st.Range(ctx, "Alloc", base, base.Add(72*time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, samples, 4)
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	assert.Len(t, segments, 2)
+	leftovers, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	assert.Empty(t, leftovers)
+}
@@ -0,0 +1,224 @@
+// Package graphite принимает метрики по протоколу Graphite plaintext:
+//
+//	path value [timestamp]
+//
+// Путь из частей, разделённых точками, превращается в имя метрики и
+// метки по шаблонам в стиле InfluxDB.
+package graphite
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/series"
+)
+
+// separator соединяет части пути, попавшие в имя метрики.
+const separator = "_"
+
+// Template задаёт, как части пути превращаются в имя и метки.
+//
+// Шаблон записывается как "[фильтр] шаблон [метка=значение,...]".
+// Фильтр — путь, в котором * совпадает с любой частью; он проверяется
+// по началу пути. Части шаблона означают:
+//
+//	measurement   часть имени метрики
+//	measurement*  эта и все оставшиеся части — часть имени
+//	field         суффикс имени после measurement
+//	field*        эта и все оставшиеся части — суффикс имени
+//	(пусто)       часть пропускается
+//	иное          значение метки с этим именем
+//
+// Например, "servers.* .host.measurement*" превращает
+// servers.web1.cpu.load в cpu_load{host="web1"}.
+type Template struct {
+	filter []string
+	parts  []string
+	tags   map[string]string
+}
+
+// ParseTemplate разбирает шаблон из строки.
+func ParseTemplate(s string) (Template, error) {
+	fields := strings.Fields(s)
+	var t Template
+	switch len(fields) {
+	case 1:
+		t.parts = strings.Split(fields[0], ".")
+	case 2:
+		if strings.Contains(fields[1], "=") {
+			t.parts = strings.Split(fields[0], ".")
+			t.tags = map[string]string{}
+			if err := parseTags(fields[1], t.tags); err != nil {
+				return Template{}, fmt.Errorf("template %q: %w", s, err)
+			}
+		} else {
+			t.filter = strings.Split(fields[0], ".")
+			t.parts = strings.Split(fields[1], ".")
+		}
+	case 3:
+		t.filter = strings.Split(fields[0], ".")
+		t.parts = strings.Split(fields[1], ".")
+		t.tags = map[string]string{}
+		if err := parseTags(fields[2], t.tags); err != nil {
+			return Template{}, fmt.Errorf("template %q: %w", s, err)
+		}
+	default:
+		return Template{}, fmt.Errorf("template %q: expected [filter] template [tags]", s)
+	}
+
+	var hasMeasurement bool
+	for _, p := range t.parts {
+		if p == "measurement" || p == "measurement*" {
+			hasMeasurement = true
+		}
+	}
+	if !hasMeasurement {
+		return Template{}, fmt.Errorf("template %q: no measurement part", s)
+	}
+	return t, nil
+}
+
+// ParseTemplates разбирает шаблоны, разделённые точкой с запятой.
+func ParseTemplates(s string) ([]Template, error) {
+	var templates []Template
+	for _, ts := range strings.Split(s, ";") {
+		if strings.TrimSpace(ts) == "" {
+			continue
+		}
+		t, err := ParseTemplate(ts)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
+
+func parseTags(s string, tags map[string]string) error {
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k == "" || v == "" {
+			return fmt.Errorf("invalid tag %q", kv)
+		}
+		tags[k] = v
+	}
+	return nil
+}
+
+// Match сообщает, подходит ли путь под фильтр шаблона. Шаблон без
+// фильтра подходит под любой путь.
+func (t Template) Match(path []string) bool {
+	if len(t.filter) > len(path) {
+		return false
+	}
+	for i, f := range t.filter {
+		if f != "*" && f != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply превращает путь в ключ серии. Значения меток из пути заменяют
+// метки шаблона с тем же именем; если метка повторяется в шаблоне,
+// её части соединяются.
+func (t Template) Apply(path []string) series.Key {
+	var measurement, field []string
+	extracted := make(map[string][]string)
+
+	for i, p := range t.parts {
+		if i >= len(path) {
+			break
+		}
+		switch p {
+		case "measurement":
+			measurement = append(measurement, path[i])
+		case "measurement*":
+			measurement = append(measurement, path[i:]...)
+		case "field":
+			field = append(field, path[i])
+		case "field*":
+			field = append(field, path[i:]...)
+		case "":
+		default:
+			extracted[p] = append(extracted[p], path[i])
+		}
+	}
+
+	tags := make(map[string]string, len(t.tags)+len(extracted))
+	for k, v := range t.tags {
+		tags[k] = v
+	}
+	for k, parts := range extracted {
+		tags[k] = strings.Join(parts, separator)
+	}
+
+	name := strings.Join(measurement, separator)
+	if name == "" {
+		name = strings.Join(path, separator)
+	}
+	if len(field) != 0 {
+		name += separator + strings.Join(field, separator)
+	}
+	return series.New(name, tags)
+}
+
+// Parser разбирает строки протокола. Для пути используется первый
+// подходящий шаблон; если таких нет, все части пути составляют имя.
+type Parser struct {
+	Templates []Template
+}
+
+var defaultTemplate = Template{parts: []string{"measurement*"}}
+
+// Point — одно значение из строки протокола.
+type Point struct {
+	Key   series.Key
+	Value float64
+	Time  time.Time
+}
+
+// Parse разбирает одну строку. Если метки времени нет или она равна -1,
+// используется now.
+func (p Parser) Parse(line string, now time.Time) (Point, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 && len(fields) != 3 {
+		return Point{}, fmt.Errorf("invalid line %q: expected path value [timestamp]", line)
+	}
+
+	path := strings.Split(fields[0], ".")
+	for _, part := range path {
+		if part == "" {
+			return Point{}, fmt.Errorf("invalid path %q", fields[0])
+		}
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return Point{}, fmt.Errorf("invalid value %q", fields[1])
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return Point{}, fmt.Errorf("value %q is not finite", fields[1])
+	}
+
+	ts := now
+	if len(fields) == 3 && fields[2] != "-1" {
+		sec, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return Point{}, fmt.Errorf("invalid timestamp %q", fields[2])
+		}
+		ts = time.Unix(0, int64(sec*float64(time.Second)))
+	}
+
+	t := defaultTemplate
+	for _, tmpl := range p.Templates {
+		if tmpl.Match(path) {
+			t = tmpl
+			break
+		}
+	}
+	return Point{Key: t.Apply(path), Value: value, Time: ts}, nil
+}
@@ -0,0 +1,58 @@
+package graphite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	templates, err := ParseTemplates("servers.* .host.measurement* region=eu; stats.*.*.* .dc.host.measurement.field; app.*.requests .app.measurement; dc.* .dc.host.dc.measurement dc=default,env=prod")
+	require.NoError(t, err)
+	parser := Parser{Templates: templates}
+	now := time.Unix(100, 0)
+
+	testCases := []struct {
+		name    string
+		line    string
+		wantKey string
+		want    float64
+		time    time.Time
+		wantErr bool
+	}{
+		{name: "Parse() Test 1", line: "servers.web1.cpu.load 0.5 1682935200", wantKey: `cpu_load{host="web1",region="eu"}`, want: 0.5, time: time.Unix(1682935200, 0)},
+		{name: "Parse() Test 2", line: "stats.dc1.db2.disk.free 42\n", wantKey: `disk_free{dc="dc1",host="db2"}`, want: 42, time: now},
+		{name: "Parse() Test 3", line: "app.shop.requests 7 -1", wantKey: `requests{app="shop"}`, want: 7, time: now},
+		{name: "Parse() Test 4", line: "app.shop.errors 1", wantKey: "app_shop_errors", want: 1, time: now},
+		{name: "Parse() Test 5", line: "dc.eu.web1.west.cpu 3", wantKey: `cpu{dc="eu_west",env="prod",host="web1"}`, want: 3, time: now},
+		{name: "Parse() Test 6", line: "a.b 1 2 3", wantErr: true},
+		{name: "Parse() Test 7", line: "a..b 1", wantErr: true},
+		{name: "Parse() Test 8", line: "a.b one", wantErr: true},
+		{name: "Parse() Test 9", line: "a.b 1 yesterday", wantErr: true},
+		{name: "Parse() Test 10", line: "a.b NaN", wantErr: true},
+		{name: "Parse() Test 11", line: "a.b +Inf", wantErr: true},
+		{name: "Parse() Test 12", line: "a.b -Infinity", wantErr: true},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := parser.Parse(test.line, now)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.wantKey, p.Key.String())
+			assert.Equal(t, test.want, p.Value)
+			assert.True(t, test.time.Equal(p.Time))
+		})
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	for _, s := range []string{"host.field", "a b c d", "measurement tag", "a.* measurement x=1,y"} {
+		_, err := ParseTemplate(s)
+		assert.Error(t, err, s)
+	}
+}
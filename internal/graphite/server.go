@@ -0,0 +1,183 @@
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/models"
+	"github.com/amidvn/go-metrics/internal/storage"
+	"go.uber.org/zap"
+)
+
+// сколько значений накапливается перед записью в хранилище
+const maxBatch = 1000
+
+// максимальная длина строки; более длинные строки пропускаются
+const maxLineLength = 64 << 10
+
+// как долго соединение может молчать, прежде чем его закроют
+const idleTimeout = 5 * time.Minute
+
+// Server принимает соединения Graphite и сохраняет значения как gauge.
+type Server struct {
+	parser  Parser
+	storage storage.Storage
+	logger  *zap.SugaredLogger
+
+	listener net.Listener
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+}
+
+func NewServer(s storage.Storage, templates []Template, logger *zap.SugaredLogger) *Server {
+	return &Server{
+		parser:  Parser{Templates: templates},
+		storage: s,
+		logger:  logger,
+		conns:   make(map[net.Conn]struct{}),
+	}
+}
+
+// Listen открывает TCP-порт и начинает принимать соединения.
+func (s *Server) Listen(address string) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	s.listener = l
+
+	s.wg.Add(1)
+	go s.serve()
+	return nil
+}
+
+// Addr возвращает адрес, на котором принимаются соединения.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Shutdown прекращает приём соединений и ждёт, пока клиенты закроют
+// открытые. Когда ctx завершается, оставшиеся соединения закрываются
+// принудительно. Уже прочитанные значения записываются в хранилище.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.listener.Close()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	<-done
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.logger.Errorw("graphite accept failed", "error", err)
+			}
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// handle читает строки соединения. Значения записываются пакетом, когда
+// прочитаны все пришедшие данные или пакет заполнен. Ошибочные строки
+// пропускаются.
+func (s *Server) handle(conn net.Conn) {
+	ctx := context.Background()
+	r := bufio.NewReaderSize(conn, maxLineLength)
+	var batch []models.Metrics
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.storage.StoreBatch(ctx, batch); err != nil {
+			s.logger.Errorw("graphite store failed", "error", err, "metrics", len(batch))
+		}
+		batch = batch[:0]
+	}
+	defer flush()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		line, err := readLine(r)
+		if errors.Is(err, errLineTooLong) {
+			s.logger.Debugw("graphite line rejected", "remote", conn.RemoteAddr().String(), "error", err)
+		} else if strings.TrimSpace(line) != "" {
+			p, perr := s.parser.Parse(line, time.Now())
+			if perr != nil {
+				s.logger.Debugw("graphite line rejected", "remote", conn.RemoteAddr().String(), "error", perr)
+			} else {
+				value := p.Value
+				batch = append(batch, models.Metrics{ID: p.Key.String(), MType: "gauge", Value: &value})
+			}
+		}
+		if err != nil && !errors.Is(err, errLineTooLong) {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				s.logger.Debugw("graphite connection closed", "remote", conn.RemoteAddr().String(), "error", err)
+			}
+			return
+		}
+		if len(batch) >= maxBatch || r.Buffered() == 0 {
+			flush()
+		}
+	}
+}
+
+var errLineTooLong = errors.New("line is too long")
+
+// readLine читает строку не длиннее буфера r. Если строка длиннее,
+// она дочитывается до конца без сохранения и возвращается
+// errLineTooLong.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if !errors.Is(err, bufio.ErrBufferFull) {
+		return string(line), err
+	}
+	for errors.Is(err, bufio.ErrBufferFull) {
+		_, err = r.ReadSlice('\n')
+	}
+	if err != nil {
+		return "", err
+	}
+	return "", errLineTooLong
+}
@@ -0,0 +1,45 @@
+package graphite
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestServer(t *testing.T) {
+	s := storage.New(0, "", false)
+	templates, err := ParseTemplates("servers.* .host.measurement*")
+	require.NoError(t, err)
+
+	srv := NewServer(s, templates, zap.NewNop().Sugar())
+	require.NoError(t, srv.Listen("127.0.0.1:0"))
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	long := "servers.web1." + strings.Repeat("x", maxLineLength) + " 1\n"
+	_, err = conn.Write([]byte("servers.web1.cpu 1.5 1682935200\nbroken\n" + long + "servers.web1.cpu 2.5\nqueue.size 3"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	ctx := context.Background()
+	shutdownCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	require.NoError(t, srv.Shutdown(shutdownCtx))
+
+	v, err := s.GetGaugeValue(ctx, `cpu{host="web1"}`)
+	require.NoError(t, err)
+	assert.Equal(t, 2.5, v)
+	v, err = s.GetGaugeValue(ctx, "queue_size")
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, v)
+	gauges, err := s.Gauges(ctx)
+	require.NoError(t, err)
+	assert.Len(t, gauges, 2)
+}
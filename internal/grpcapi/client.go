@@ -0,0 +1,83 @@
+package grpcapi
+
+import (
+	"context"
+
+	"github.com/amidvn/go-metrics/internal/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Client вызывает сервис Metrics, принимая и возвращая models.Metrics.
+type Client struct {
+	conn    *grpc.ClientConn
+	metrics MetricsClient
+}
+
+// Dial подключается к серверу. Соединение устанавливается при первом
+// вызове и восстанавливается после обрывов.
+func Dial(address string, opts ...grpc.DialOption) (*Client, error) {
+	opts = append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}, opts...)
+	conn, err := grpc.Dial(address, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, metrics: NewMetricsClient(conn)}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) Update(ctx context.Context, m models.Metrics) (models.Metrics, error) {
+	resp, err := c.metrics.Update(ctx, &UpdateRequest{Metric: toProto(m)})
+	if err != nil {
+		return models.Metrics{}, err
+	}
+	return fromProto(resp.GetMetric()), nil
+}
+
+func (c *Client) Updates(ctx context.Context, metrics []models.Metrics) (int64, error) {
+	resp, err := c.metrics.Updates(ctx, &UpdatesRequest{Metrics: toProtoList(metrics)})
+	if err != nil {
+		return 0, err
+	}
+	return resp.GetAccepted(), nil
+}
+
+func (c *Client) Value(ctx context.Context, mtype, id string) (models.Metrics, error) {
+	resp, err := c.metrics.Value(ctx, &ValueRequest{Id: id, Type: mtype})
+	if err != nil {
+		return models.Metrics{}, err
+	}
+	return fromProto(resp.GetMetric()), nil
+}
+
+// UpdateStream открывает поток пакетов обновлений.
+func (c *Client) UpdateStream(ctx context.Context) (*UpdateStreamClient, error) {
+	stream, err := c.metrics.UpdateStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &UpdateStreamClient{stream: stream}, nil
+}
+
+// UpdateStreamClient — клиентская сторона потока UpdateStream.
+type UpdateStreamClient struct {
+	stream Metrics_UpdateStreamClient
+}
+
+func (s *UpdateStreamClient) Send(metrics []models.Metrics) error {
+	return s.stream.Send(&UpdatesRequest{Metrics: toProtoList(metrics)})
+}
+
+// CloseAndRecv завершает поток и возвращает число сохранённых метрик.
+func (s *UpdateStreamClient) CloseAndRecv() (int64, error) {
+	resp, err := s.stream.CloseAndRecv()
+	if err != nil {
+		return 0, err
+	}
+	return resp.GetAccepted(), nil
+}
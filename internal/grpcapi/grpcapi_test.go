@@ -0,0 +1,140 @@
+package grpcapi
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/amidvn/go-metrics/internal/models"
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestConvert(t *testing.T) {
+	delta, value := int64(-5), 1.5
+	metrics := []models.Metrics{
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "Alloc", MType: "gauge", Value: &value},
+		{ID: "empty", MType: "gauge"},
+	}
+	data, err := proto.Marshal(&UpdatesRequest{Metrics: toProtoList(metrics)})
+	require.NoError(t, err)
+	var got UpdatesRequest
+	require.NoError(t, proto.Unmarshal(data, &got))
+	assert.Equal(t, metrics, fromProtoList(got.GetMetrics()))
+	assert.Equal(t, models.Metrics{}, fromProto(nil))
+}
+
+// TestGeneratedClient вызывает сервер через сгенерированную заглушку
+// с типом содержимого по умолчанию, как любой клиент из metrics.proto.
+func TestGeneratedClient(t *testing.T) {
+	ctx := context.Background()
+	srv := NewServer(storage.New(0, "", false), zap.NewNop().Sugar())
+	require.NoError(t, srv.Listen("127.0.0.1:0"))
+	defer srv.Shutdown(ctx)
+
+	conn, err := grpc.Dial(srv.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	c := NewMetricsClient(conn)
+
+	resp, err := c.Updates(ctx, &UpdatesRequest{Metrics: []*Metric{
+		{Id: "PollCount", Type: "counter", Delta: proto.Int64(4)},
+		{Id: "Alloc", Type: "gauge", Value: proto.Float64(2.5)},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), resp.GetAccepted())
+
+	value, err := c.Value(ctx, &ValueRequest{Id: "Alloc", Type: "gauge"})
+	require.NoError(t, err)
+	assert.Equal(t, 2.5, value.GetMetric().GetValue())
+
+	_, err = c.Update(ctx, &UpdateRequest{Metric: &Metric{Id: "x", Type: "counter"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	s := storage.New(0, "", false)
+	srv := NewServer(s, zap.NewNop().Sugar())
+	require.NoError(t, srv.Listen("127.0.0.1:0"))
+	defer srv.Shutdown(ctx)
+
+	c, err := Dial(srv.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+
+	delta, value := int64(2), 3.5
+	m, err := c.Update(ctx, models.Metrics{ID: "PollCount", MType: "counter", Delta: &delta})
+	require.NoError(t, err)
+	assert.Equal(t, "PollCount", m.ID)
+
+	accepted, err := c.Updates(ctx, []models.Metrics{
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "Alloc", MType: "gauge", Value: &value},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), accepted)
+
+	stream, err := c.UpdateStream(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send([]models.Metrics{{ID: "PollCount", MType: "counter", Delta: &delta}}))
+	require.NoError(t, stream.Send([]models.Metrics{{ID: "Sys", MType: "gauge", Value: &value}}))
+	accepted, err = stream.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), accepted)
+
+	m, err = c.Value(ctx, "counter", "PollCount")
+	require.NoError(t, err)
+	require.NotNil(t, m.Delta)
+	assert.Equal(t, int64(6), *m.Delta)
+	m, err = c.Value(ctx, "gauge", "Sys")
+	require.NoError(t, err)
+	require.NotNil(t, m.Value)
+	assert.Equal(t, 3.5, *m.Value)
+
+	testCases := []struct {
+		name string
+		call func() error
+		want codes.Code
+	}{
+		{name: "Errors() Test 1", want: codes.NotFound, call: func() error {
+			_, err := c.Value(ctx, "gauge", "missing")
+			return err
+		}},
+		{name: "Errors() Test 2", want: codes.InvalidArgument, call: func() error {
+			_, err := c.Update(ctx, models.Metrics{ID: "x", MType: "gauge"})
+			return err
+		}},
+		{name: "Errors() Test 3", want: codes.InvalidArgument, call: func() error {
+			_, err := c.Updates(ctx, []models.Metrics{{ID: "x", MType: "histogram", Value: &value}})
+			return err
+		}},
+		{name: "Errors() Test 4", want: codes.InvalidArgument, call: func() error {
+			_, err := c.Value(ctx, "summary", "x")
+			return err
+		}},
+		{name: "Errors() Test 5", want: codes.InvalidArgument, call: func() error {
+			nan := math.NaN()
+			_, err := c.Update(ctx, models.Metrics{ID: "x", MType: "gauge", Value: &nan})
+			return err
+		}},
+		{name: "Errors() Test 6", want: codes.InvalidArgument, call: func() error {
+			inf := math.Inf(1)
+			_, err := c.Updates(ctx, []models.Metrics{{ID: "x", MType: "gauge", Value: &inf}})
+			return err
+		}},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, status.Code(test.call()))
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package grpcapi
+
+import (
+	"github.com/amidvn/go-metrics/internal/models"
+	"google.golang.org/protobuf/proto"
+)
+
+// toProto переводит метрику в сообщение Metric. Пустые Delta и Value
+// остаются неустановленными полями.
+func toProto(m models.Metrics) *Metric {
+	return &Metric{Id: m.ID, Type: m.MType, Delta: m.Delta, Value: m.Value}
+}
+
+func fromProto(m *Metric) models.Metrics {
+	if m == nil {
+		return models.Metrics{}
+	}
+	res := models.Metrics{ID: m.GetId(), MType: m.GetType()}
+	if m.Delta != nil {
+		res.Delta = proto.Int64(m.GetDelta())
+	}
+	if m.Value != nil {
+		res.Value = proto.Float64(m.GetValue())
+	}
+	return res
+}
+
+func toProtoList(metrics []models.Metrics) []*Metric {
+	res := make([]*Metric, len(metrics))
+	for i, m := range metrics {
+		res[i] = toProto(m)
+	}
+	return res
+}
+
+func fromProtoList(metrics []*Metric) []models.Metrics {
+	res := make([]models.Metrics, len(metrics))
+	for i, m := range metrics {
+		res[i] = fromProto(m)
+	}
+	return res
+}
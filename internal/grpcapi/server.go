@@ -0,0 +1,164 @@
+// Package grpcapi — API сервера метрик по gRPC, повторяющее /update/,
+// /updates/ и /value/. Схема описана в metrics.proto, сообщения
+// и описание сервиса сгенерированы из неё.
+package grpcapi
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative metrics.proto
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/amidvn/go-metrics/internal/models"
+	"github.com/amidvn/go-metrics/internal/storage"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// service реализует MetricsServer поверх хранилища.
+type service struct {
+	UnimplementedMetricsServer
+	storage storage.Storage
+}
+
+func (s service) Update(ctx context.Context, req *UpdateRequest) (*UpdateResponse, error) {
+	m := fromProto(req.GetMetric())
+	var err error
+	switch m.MType {
+	case "counter":
+		if m.Delta == nil {
+			return nil, status.Error(codes.InvalidArgument, "missing delta for counter metric")
+		}
+		err = s.storage.UpdateCounter(ctx, m.ID, *m.Delta)
+	case "gauge":
+		if m.Value == nil {
+			return nil, status.Error(codes.InvalidArgument, "missing value for gauge metric")
+		}
+		if err := storage.ValidateMetric(m); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		err = s.storage.UpdateGauge(ctx, m.ID, *m.Value)
+	default:
+		return nil, status.Error(codes.InvalidArgument, "invalid metric type, can only be 'gauge' or 'counter'")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &UpdateResponse{Metric: toProto(m)}, nil
+}
+
+func (s service) Updates(ctx context.Context, req *UpdatesRequest) (*UpdatesResponse, error) {
+	if err := s.store(ctx, fromProtoList(req.GetMetrics())); err != nil {
+		return nil, err
+	}
+	return &UpdatesResponse{Accepted: int64(len(req.GetMetrics()))}, nil
+}
+
+func (s service) Value(ctx context.Context, req *ValueRequest) (*ValueResponse, error) {
+	m := models.Metrics{ID: req.GetId(), MType: req.GetType()}
+	var err error
+	switch m.MType {
+	case "counter":
+		var v int64
+		v, err = s.storage.GetCounterValue(ctx, m.ID)
+		m.Delta = &v
+	case "gauge":
+		var v float64
+		v, err = s.storage.GetGaugeValue(ctx, m.ID)
+		m.Value = &v
+	default:
+		return nil, status.Error(codes.InvalidArgument, "invalid metric type, can only be 'gauge' or 'counter'")
+	}
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return nil, status.Errorf(codes.NotFound, "%s %s not found", m.MType, m.ID)
+	case err != nil:
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &ValueResponse{Metric: toProto(m)}, nil
+}
+
+// UpdateStream сохраняет каждый пакет сразу после получения. При ошибке
+// поток прерывается; уже сохранённые пакеты остаются в хранилище.
+func (s service) UpdateStream(stream Metrics_UpdateStreamServer) error {
+	var accepted int64
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&UpdatesResponse{Accepted: accepted})
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.store(stream.Context(), fromProtoList(req.GetMetrics())); err != nil {
+			return err
+		}
+		accepted += int64(len(req.GetMetrics()))
+	}
+}
+
+func (s service) store(ctx context.Context, metrics []models.Metrics) error {
+	if err := storage.ValidateBatch(metrics); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := s.storage.StoreBatch(ctx, metrics); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// Server обслуживает API по gRPC на отдельном порту.
+type Server struct {
+	grpc     *grpc.Server
+	listener net.Listener
+	logger   *zap.SugaredLogger
+}
+
+func NewServer(s storage.Storage, logger *zap.SugaredLogger) *Server {
+	srv := grpc.NewServer()
+	RegisterMetricsServer(srv, service{storage: s})
+	return &Server{grpc: srv, logger: logger}
+}
+
+// Listen открывает TCP-порт и начинает обслуживать запросы.
+func (s *Server) Listen(address string) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	s.listener = l
+
+	go func() {
+		if err := s.grpc.Serve(l); err != nil {
+			s.logger.Errorw("grpc server stopped", "error", err)
+		}
+	}()
+	return nil
+}
+
+// Addr возвращает адрес, на котором принимаются соединения.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Shutdown дожидается завершения текущих вызовов; когда ctx
+// завершается, оставшиеся прерываются.
+func (s *Server) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.grpc.Stop()
+		<-done
+	}
+	return nil
+}
@@ -1,20 +1,24 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/amidvn/go-metrics/internal/database"
+	"github.com/amidvn/go-metrics/internal/exposition"
 	"github.com/amidvn/go-metrics/internal/models"
 	"github.com/amidvn/go-metrics/internal/storage"
 	"github.com/labstack/echo/v4"
 )
 
-func PostWebhook(s *storage.MemStorage) echo.HandlerFunc {
+func PostWebhook(s storage.Storage) echo.HandlerFunc {
 	return func(ctx echo.Context) error {
 		metricsType := ctx.Param("typeM")
 		metricsName := ctx.Param("nameM")
@@ -26,13 +30,20 @@ func PostWebhook(s *storage.MemStorage) echo.HandlerFunc {
 			if err != nil {
 				return ctx.String(http.StatusBadRequest, fmt.Sprintf("%s cannot be converted to an integer", metricsValue))
 			}
-			s.UpdateCounter(metricsName, value)
+			if err := s.UpdateCounter(ctx.Request().Context(), metricsName, value); err != nil {
+				return ctx.String(http.StatusInternalServerError, err.Error())
+			}
 		case "gauge":
 			value, err := strconv.ParseFloat(metricsValue, 64)
 			if err != nil {
 				return ctx.String(http.StatusBadRequest, fmt.Sprintf("%s cannot be converted to a float", metricsValue))
 			}
-			s.UpdateGauge(metricsName, value)
+			if err := storage.ValidateMetric(models.Metrics{ID: metricsName, MType: metricsType, Value: &value}); err != nil {
+				return ctx.String(http.StatusBadRequest, err.Error())
+			}
+			if err := s.UpdateGauge(ctx.Request().Context(), metricsName, value); err != nil {
+				return ctx.String(http.StatusInternalServerError, err.Error())
+			}
 		default:
 			return ctx.String(http.StatusBadRequest, "Invalid metric type. Can only be 'gauge' or 'counter'")
 		}
@@ -42,7 +53,7 @@ func PostWebhook(s *storage.MemStorage) echo.HandlerFunc {
 	}
 }
 
-func UpdateJSON(s *storage.MemStorage) echo.HandlerFunc {
+func UpdateJSON(s storage.Storage) echo.HandlerFunc {
 	return func(ctx echo.Context) error {
 		var metric models.Metrics
 		err := json.NewDecoder(ctx.Request().Body).Decode(&metric)
@@ -52,25 +63,51 @@ func UpdateJSON(s *storage.MemStorage) echo.HandlerFunc {
 
 		switch metric.MType {
 		case "counter":
-			s.UpdateCounter(metric.ID, *metric.Delta)
+			if metric.Delta == nil {
+				return ctx.String(http.StatusBadRequest, "Missing delta for counter metric")
+			}
+			err = s.UpdateCounter(ctx.Request().Context(), metric.ID, *metric.Delta)
 		case "gauge":
-			s.UpdateGauge(metric.ID, *metric.Value)
+			if metric.Value == nil {
+				return ctx.String(http.StatusBadRequest, "Missing value for gauge metric")
+			}
+			err = s.UpdateGauge(ctx.Request().Context(), metric.ID, *metric.Value)
 		default:
 			return ctx.String(http.StatusNotFound, "Invalid metric type. Can only be 'gauge' or 'counter'")
 		}
+		if err != nil {
+			return ctx.String(http.StatusInternalServerError, err.Error())
+		}
 
 		ctx.Response().Header().Set("Content-Type", "application/json")
 		return ctx.JSON(http.StatusOK, metric)
 	}
 }
 
-func MetricsValue(s *storage.MemStorage) echo.HandlerFunc {
+func MetricsValue(s storage.Storage) echo.HandlerFunc {
 	return func(ctx echo.Context) error {
 		typeM := ctx.Param("typeM")
 		nameM := ctx.Param("nameM")
 
-		val, status := s.GetValue(typeM, nameM)
-		err := ctx.String(status, val)
+		var val string
+		switch typeM {
+		case "counter":
+			v, err := s.GetCounterValue(ctx.Request().Context(), nameM)
+			if err != nil {
+				return valueError(ctx, err)
+			}
+			val = fmt.Sprint(v)
+		case "gauge":
+			v, err := s.GetGaugeValue(ctx.Request().Context(), nameM)
+			if err != nil {
+				return valueError(ctx, err)
+			}
+			val = fmt.Sprint(v)
+		default:
+			return ctx.String(http.StatusNotFound, "")
+		}
+
+		err := ctx.String(http.StatusOK, val)
 		if err != nil {
 			return err
 		}
@@ -79,7 +116,7 @@ func MetricsValue(s *storage.MemStorage) echo.HandlerFunc {
 	}
 }
 
-func GetValueJSON(s *storage.MemStorage) echo.HandlerFunc {
+func GetValueJSON(s storage.Storage) echo.HandlerFunc {
 	return func(ctx echo.Context) error {
 		var metric models.Metrics
 		err := json.NewDecoder(ctx.Request().Body).Decode(&metric)
@@ -87,12 +124,19 @@ func GetValueJSON(s *storage.MemStorage) echo.HandlerFunc {
 			return ctx.String(http.StatusBadRequest, fmt.Sprintf("Error in JSON decode: %s", err))
 		}
 
+		// отсутствующая метрика, как и раньше, возвращается с нулевым значением
 		switch metric.MType {
 		case "counter":
-			value := s.GetCounterValue(metric.ID)
+			value, err := s.GetCounterValue(ctx.Request().Context(), metric.ID)
+			if err != nil && !errors.Is(err, storage.ErrNotFound) {
+				return ctx.String(http.StatusInternalServerError, err.Error())
+			}
 			metric.Delta = &value
 		case "gauge":
-			value := s.GetGaugeValue(metric.ID)
+			value, err := s.GetGaugeValue(ctx.Request().Context(), metric.ID)
+			if err != nil && !errors.Is(err, storage.ErrNotFound) {
+				return ctx.String(http.StatusInternalServerError, err.Error())
+			}
 			metric.Value = &value
 		default:
 			return ctx.String(http.StatusNotFound, "Invalid metric type. Can only be 'gauge' or 'counter'")
@@ -103,10 +147,89 @@ func GetValueJSON(s *storage.MemStorage) echo.HandlerFunc {
 	}
 }
 
-func AllMetrics(s *storage.MemStorage) echo.HandlerFunc {
+func valueError(ctx echo.Context, err error) error {
+	if errors.Is(err, storage.ErrNotFound) {
+		return ctx.String(http.StatusNotFound, "")
+	}
+	return ctx.String(http.StatusInternalServerError, err.Error())
+}
+
+// форматы вывода списка метрик
+const (
+	formatHTML        = "text/html"
+	formatPrometheus  = "text/plain"
+	formatOpenMetrics = "application/openmetrics-text"
+	formatJSON        = "application/json"
+)
+
+// AllMetrics отдаёт все метрики. Формат выбирается по заголовку Accept,
+// по умолчанию — текстовый список.
+func AllMetrics(s storage.Storage) echo.HandlerFunc {
+	return listMetrics(s, formatHTML, formatPrometheus, formatOpenMetrics, formatJSON)
+}
+
+// PrometheusMetrics отдаёт все метрики для Prometheus: в текстовом
+// формате или, если клиент его поддерживает, в OpenMetrics.
+func PrometheusMetrics(s storage.Storage) echo.HandlerFunc {
+	return listMetrics(s, formatPrometheus, formatOpenMetrics, formatJSON)
+}
+
+// countersCreated — хранилище, которое знает время появления счётчиков.
+type countersCreated interface {
+	CountersCreated(ctx context.Context) map[string]time.Time
+}
+
+func listMetrics(s storage.Storage, offers ...string) echo.HandlerFunc {
 	return func(ctx echo.Context) error {
+		reqCtx := ctx.Request().Context()
+		gauges, err := s.Gauges(reqCtx)
+		if err != nil {
+			return ctx.String(http.StatusInternalServerError, err.Error())
+		}
+		counters, err := s.Counters(reqCtx)
+		if err != nil {
+			return ctx.String(http.StatusInternalServerError, err.Error())
+		}
+
+		format := exposition.Negotiate(ctx.Request().Header.Get(echo.HeaderAccept), offers...)
+		ctx.Response().Header().Add(echo.HeaderVary, echo.HeaderAccept)
+		w := ctx.Response()
+		switch format {
+		case formatPrometheus:
+			w.Header().Set("Content-Type", exposition.PrometheusContentType)
+			w.WriteHeader(http.StatusOK)
+			return exposition.WritePrometheus(w, exposition.Families(counters, gauges, nil))
+		case formatOpenMetrics:
+			var created map[string]time.Time
+			if cc, ok := s.(countersCreated); ok {
+				created = cc.CountersCreated(reqCtx)
+			}
+			w.Header().Set("Content-Type", exposition.OpenMetricsContentType)
+			w.WriteHeader(http.StatusOK)
+			return exposition.WriteOpenMetrics(w, exposition.Families(counters, gauges, created))
+		case formatJSON:
+			// тело собирается заранее, чтобы ошибка кодирования не
+			// оборвала уже начатый ответ 200
+			var buf bytes.Buffer
+			if err := exposition.WriteJSON(&buf, counters, gauges); err != nil {
+				return ctx.String(http.StatusInternalServerError, err.Error())
+			}
+			return ctx.Blob(http.StatusOK, exposition.JSONContentType, buf.Bytes())
+		}
+
+		var result string
+		result += "Gauge metrics:\n"
+		for n, v := range gauges {
+			result += fmt.Sprintf("- %s = %f\n", n, v)
+		}
+
+		result += "Counter metrics:\n"
+		for n, v := range counters {
+			result += fmt.Sprintf("- %s = %d\n", n, v)
+		}
+
 		ctx.Response().Header().Set("Content-Type", "text/html")
-		err := ctx.String(http.StatusOK, s.AllMetrics())
+		err = ctx.String(http.StatusOK, result)
 		if err != nil {
 			return err
 		}
@@ -118,11 +241,11 @@ func AllMetrics(s *storage.MemStorage) echo.HandlerFunc {
 func PingDB(db *database.DBConnection) echo.HandlerFunc {
 	return func(ctx echo.Context) error {
 		ctx.Response().Header().Set("Content-Type", "text/html")
-		err := database.CheckConnection(db)
+		err := database.CheckConnection(ctx.Request().Context(), db)
 		if err == nil {
 			err = ctx.String(http.StatusOK, "Connection database is OK")
 		} else {
-			err = ctx.String(http.StatusInternalServerError, "Connection database is OK")
+			err = ctx.String(http.StatusInternalServerError, "Connection database failed: "+err.Error())
 		}
 
 		if err != nil {
@@ -133,16 +256,134 @@ func PingDB(db *database.DBConnection) echo.HandlerFunc {
 	}
 }
 
-func UpdatesJSON(s *storage.MemStorage) echo.HandlerFunc {
+// результат обработки одной метрики пакета /updates/
+const (
+	statusAccepted = "accepted"
+	statusRejected = "rejected"
+)
+
+type updateResult struct {
+	Index  int    `json:"index"`
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type updatesResponse struct {
+	Accepted int            `json:"accepted"`
+	Rejected int            `json:"rejected"`
+	Results  []updateResult `json:"results"`
+}
+
+// UpdatesJSON сохраняет пакет метрик и возвращает результат по каждой
+// из них. Корректные метрики сохраняются, даже если в пакете есть
+// ошибочные; с параметром atomic=true пакет с ошибками не сохраняется
+// целиком. Если сохранена хотя бы одна метрика, ответ — 200 с
+// результатами по каждой; 400 — если не сохранено ничего.
+func UpdatesJSON(s storage.Storage) echo.HandlerFunc {
 	return func(ctx echo.Context) error {
+		atomic := false
+		if v := ctx.QueryParam("atomic"); v != "" {
+			var err error
+			if atomic, err = strconv.ParseBool(v); err != nil {
+				return ctx.String(http.StatusBadRequest, fmt.Sprintf("Invalid 'atomic' parameter: %s", v))
+			}
+		}
+
 		metrics := make([]models.Metrics, 0)
 		err := json.NewDecoder(ctx.Request().Body).Decode(&metrics)
 		if err != nil && !errors.Is(err, io.EOF) {
 			return ctx.String(http.StatusBadRequest, fmt.Sprintf("Error in JSON decode: %s", err))
 		}
 
-		s.StoreBatch(metrics)
+		resp := updatesResponse{Results: make([]updateResult, len(metrics))}
+		valid := make([]models.Metrics, 0, len(metrics))
+		for i, m := range metrics {
+			resp.Results[i] = updateResult{Index: i, ID: m.ID, Type: m.MType, Status: statusAccepted}
+			if err := storage.ValidateMetric(m); err != nil {
+				resp.Results[i].Status, resp.Results[i].Error = statusRejected, err.Error()
+				resp.Rejected++
+				continue
+			}
+			valid = append(valid, m)
+		}
+
+		if atomic && resp.Rejected != 0 {
+			for i := range resp.Results {
+				if resp.Results[i].Status == statusAccepted {
+					resp.Results[i].Status, resp.Results[i].Error = statusRejected, "batch contains invalid metrics"
+				}
+			}
+			resp.Rejected = len(metrics)
+			return ctx.JSON(http.StatusBadRequest, resp)
+		}
+
+		if err := s.StoreBatch(ctx.Request().Context(), valid); err != nil {
+			return ctx.String(http.StatusInternalServerError, err.Error())
+		}
+		resp.Accepted = len(valid)
+
+		if resp.Accepted == 0 && resp.Rejected != 0 {
+			return ctx.JSON(http.StatusBadRequest, resp)
+		}
+		return ctx.JSON(http.StatusOK, resp)
+	}
+}
+
+// jsonFloat кодируется в JSON как число, а NaN и бесконечности —
+// строками, так же как значения gauge в JSON-выводе /metrics.
+type jsonFloat = exposition.JSONFloat
+
+type historyPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     jsonFloat `json:"value"`
+}
 
-		return ctx.NoContent(http.StatusOK)
+// History возвращает значения серии за интервал from..to. Границы
+// принимаются в формате RFC 3339 или unix-секундах; по умолчанию —
+// последний час.
+func History(h storage.History) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		typeM := ctx.Param("typeM")
+		nameM := ctx.Param("nameM")
+		if typeM != "counter" && typeM != "gauge" {
+			return ctx.String(http.StatusNotFound, "Invalid metric type. Can only be 'gauge' or 'counter'")
+		}
+
+		to, err := parseTime(ctx.QueryParam("to"), time.Now())
+		if err != nil {
+			return ctx.String(http.StatusBadRequest, fmt.Sprintf("Invalid 'to' parameter: %s", err))
+		}
+		from, err := parseTime(ctx.QueryParam("from"), to.Add(-time.Hour))
+		if err != nil {
+			return ctx.String(http.StatusBadRequest, fmt.Sprintf("Invalid 'from' parameter: %s", err))
+		}
+		if from.After(to) {
+			return ctx.String(http.StatusBadRequest, "'from' must not be after 'to'")
+		}
+
+		samples, err := h.Range(ctx.Request().Context(), nameM, from, to)
+		if err != nil {
+			return ctx.String(http.StatusInternalServerError, err.Error())
+		}
+
+		points := make([]historyPoint, 0, len(samples))
+		for _, smp := range samples {
+			if smp.MType == typeM {
+				points = append(points, historyPoint{Timestamp: smp.Timestamp, Value: jsonFloat(smp.Value)})
+			}
+		}
+		return ctx.JSON(http.StatusOK, points)
+	}
+}
+
+func parseTime(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	if sec, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(sec*float64(time.Second))), nil
 	}
+	return time.Parse(time.RFC3339, value)
 }
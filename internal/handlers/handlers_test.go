@@ -1 +1,236 @@
 package handlers
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeHistory []storage.Sample
+
+func (h fakeHistory) Append(ctx context.Context, samples ...storage.Sample) error { return nil }
+
+func (h fakeHistory) Range(ctx context.Context, name string, from, to time.Time) ([]storage.Sample, error) {
+	var result []storage.Sample
+	for _, smp := range h {
+		if smp.Name == name && !smp.Timestamp.Before(from) && !smp.Timestamp.After(to) {
+			result = append(result, smp)
+		}
+	}
+	return result, nil
+}
+
+func TestHistory(t *testing.T) {
+	base := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	h := fakeHistory{
+		{Name: "Alloc", MType: "gauge", Timestamp: base, Value: 1},
+		{Name: "Alloc", MType: "gauge", Timestamp: base.Add(30 * time.Minute), Value: 2},
+		{Name: "Alloc", MType: "counter", Timestamp: base.Add(30 * time.Minute), Value: 5},
+	}
+
+	testCases := []struct {
+		name   string
+		url    string
+		status int
+		body   string
+	}{
+		{name: "History() Test 1", url: "/history/gauge/Alloc?from=2023-05-01T10:00:00Z&to=2023-05-01T11:00:00Z", status: http.StatusOK,
+			body: `[{"timestamp":"2023-05-01T10:00:00Z","value":1},{"timestamp":"2023-05-01T10:30:00Z","value":2}]`},
+		{name: "History() Test 2", url: "/history/counter/Alloc?from=1682935200&to=1682938800", status: http.StatusOK,
+			body: `[{"timestamp":"2023-05-01T10:30:00Z","value":5}]`},
+		{name: "History() Test 3", url: "/history/gauge/Alloc?from=yesterday", status: http.StatusBadRequest},
+		{name: "History() Test 4", url: "/history/histogram/Alloc", status: http.StatusNotFound},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			e := echo.New()
+			e.GET("/history/:typeM/:nameM", History(h))
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.url, nil))
+
+			assert.Equal(t, test.status, rec.Code)
+			if test.body != "" {
+				assert.JSONEq(t, test.body, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAllMetrics(t *testing.T) {
+	s := storage.New(0, "", false)
+	s.UpdateCounter(context.Background(), "PollCount", 2)
+	s.UpdateGauge(context.Background(), "Alloc", 1.5)
+
+	testCases := []struct {
+		name        string
+		url         string
+		accept      string
+		contentType string
+		body        string
+	}{
+		{name: "AllMetrics() Test 1", url: "/", contentType: "text/html",
+			body: "Gauge metrics:\n- Alloc = 1.500000\nCounter metrics:\n- PollCount = 2\n"},
+		{name: "AllMetrics() Test 2", url: "/", accept: "application/json", contentType: "application/json; charset=utf-8",
+			body: `[{"id":"PollCount","type":"counter","delta":2},{"id":"Alloc","type":"gauge","value":1.5}]` + "\n"},
+		{name: "AllMetrics() Test 3", url: "/metrics", contentType: "text/plain; version=0.0.4; charset=utf-8",
+			body: "# HELP Alloc gauge Alloc\n# TYPE Alloc gauge\nAlloc 1.5\n# HELP PollCount counter PollCount\n# TYPE PollCount counter\nPollCount 2\n"},
+		{name: "AllMetrics() Test 4", url: "/", accept: "application/openmetrics-text", contentType: "application/openmetrics-text; version=1.0.0; charset=utf-8"},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			e := echo.New()
+			e.GET("/", AllMetrics(s))
+			e.GET("/metrics", PrometheusMetrics(s))
+			req := httptest.NewRequest(http.MethodGet, test.url, nil)
+			if test.accept != "" {
+				req.Header.Set("Accept", test.accept)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, test.contentType, rec.Header().Get("Content-Type"))
+			if test.body != "" {
+				assert.Equal(t, test.body, rec.Body.String())
+			} else {
+				assert.Contains(t, rec.Body.String(), "PollCount_total 2\nPollCount_created ")
+				assert.True(t, strings.HasSuffix(rec.Body.String(), "# EOF\n"))
+			}
+		})
+	}
+
+	// значения, которых нет в JSON, передаются строками
+	s.UpdateGauge(context.Background(), "Ratio", math.NaN())
+	e := echo.New()
+	e.GET("/", AllMetrics(s))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[{"id":"PollCount","type":"counter","delta":2},`+
+		`{"id":"Alloc","type":"gauge","value":1.5},{"id":"Ratio","type":"gauge","value":"NaN"}]`, rec.Body.String())
+}
+
+func TestUpdatesJSON(t *testing.T) {
+	const batch = `[{"id":"c","type":"counter","delta":2},{"id":"g","type":"gauge"},{"id":"h","type":"histogram","value":1},{"id":"g2","type":"gauge","value":1.5}]`
+	testCases := []struct {
+		name     string
+		query    string
+		body     string
+		want     int
+		wantBody string
+		stored   bool
+	}{
+		{name: "UpdatesJSON() Test 1", body: `[{"id":"c","type":"counter","delta":2}]`, want: http.StatusOK, stored: true,
+			wantBody: `{"accepted":1,"rejected":0,"results":[{"index":0,"id":"c","type":"counter","status":"accepted"}]}`},
+		{name: "UpdatesJSON() Test 2", body: batch, want: http.StatusOK, stored: true,
+			wantBody: `{"accepted":2,"rejected":2,"results":[
+				{"index":0,"id":"c","type":"counter","status":"accepted"},
+				{"index":1,"id":"g","type":"gauge","status":"rejected","error":"gauge g: value is missing"},
+				{"index":2,"id":"h","type":"histogram","status":"rejected","error":"h: invalid type \"histogram\", can only be 'gauge' or 'counter'"},
+				{"index":3,"id":"g2","type":"gauge","status":"accepted"}]}`},
+		{name: "UpdatesJSON() Test 3", query: "?atomic=true", body: batch, want: http.StatusBadRequest,
+			wantBody: `{"accepted":0,"rejected":4,"results":[
+				{"index":0,"id":"c","type":"counter","status":"rejected","error":"batch contains invalid metrics"},
+				{"index":1,"id":"g","type":"gauge","status":"rejected","error":"gauge g: value is missing"},
+				{"index":2,"id":"h","type":"histogram","status":"rejected","error":"h: invalid type \"histogram\", can only be 'gauge' or 'counter'"},
+				{"index":3,"id":"g2","type":"gauge","status":"rejected","error":"batch contains invalid metrics"}]}`},
+		{name: "UpdatesJSON() Test 4", query: "?atomic=maybe", body: batch, want: http.StatusBadRequest},
+		{name: "UpdatesJSON() Test 5", body: `{`, want: http.StatusBadRequest},
+		{name: "UpdatesJSON() Test 6", body: `[{"id":"c","type":"counter"}]`, want: http.StatusBadRequest,
+			wantBody: `{"accepted":0,"rejected":1,"results":[{"index":0,"id":"c","type":"counter","status":"rejected","error":"counter c: delta is missing"}]}`},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			s := storage.New(0, "", false)
+			e := echo.New()
+			e.POST("/updates/", UpdatesJSON(s))
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/updates/"+test.query, strings.NewReader(test.body)))
+			assert.Equal(t, test.want, rec.Code)
+			if test.wantBody != "" {
+				assert.JSONEq(t, test.wantBody, rec.Body.String())
+			}
+
+			_, err := s.GetCounterValue(context.Background(), "c")
+			if test.stored {
+				require.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, storage.ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestPostWebhook(t *testing.T) {
+	s := storage.New(0, "", false)
+	e := echo.New()
+	e.POST("/update/:typeM/:nameM/:valueM", PostWebhook(s))
+
+	testCases := []struct {
+		name string
+		path string
+		want int
+	}{
+		{name: "PostWebhook() Test 1", path: "/update/gauge/Alloc/1.5", want: http.StatusOK},
+		{name: "PostWebhook() Test 2", path: "/update/counter/PollCount/2", want: http.StatusOK},
+		{name: "PostWebhook() Test 3", path: "/update/gauge/Alloc/NaN", want: http.StatusBadRequest},
+		{name: "PostWebhook() Test 4", path: "/update/gauge/Alloc/-Inf", want: http.StatusBadRequest},
+		{name: "PostWebhook() Test 5", path: "/update/gauge/Alloc/none", want: http.StatusBadRequest},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, test.path, nil))
+			assert.Equal(t, test.want, rec.Code)
+		})
+	}
+
+	// отклонённые значения не затирают сохранённое
+	g, err := s.GetGaugeValue(context.Background(), "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, g)
+}
+
+func TestGetValueJSON(t *testing.T) {
+	s := storage.New(0, "", false)
+	require.NoError(t, s.UpdateCounter(context.Background(), "PollCount", 5))
+	require.NoError(t, s.UpdateGauge(context.Background(), "Alloc", 1.5))
+	e := echo.New()
+	e.POST("/value/", GetValueJSON(s))
+
+	testCases := []struct {
+		name     string
+		body     string
+		want     int
+		wantBody string
+	}{
+		{name: "GetValueJSON() Test 1", body: `{"id":"PollCount","type":"counter"}`, want: http.StatusOK, wantBody: `{"id":"PollCount","type":"counter","delta":5}`},
+		{name: "GetValueJSON() Test 2", body: `{"id":"Alloc","type":"gauge"}`, want: http.StatusOK, wantBody: `{"id":"Alloc","type":"gauge","value":1.5}`},
+		{name: "GetValueJSON() Test 3", body: `{"id":"Missing","type":"counter"}`, want: http.StatusOK, wantBody: `{"id":"Missing","type":"counter","delta":0}`},
+		{name: "GetValueJSON() Test 4", body: `{"id":"Missing","type":"gauge"}`, want: http.StatusOK, wantBody: `{"id":"Missing","type":"gauge","value":0}`},
+		{name: "GetValueJSON() Test 5", body: `{"id":"Alloc","type":"histogram"}`, want: http.StatusNotFound},
+		{name: "GetValueJSON() Test 6", body: `{`, want: http.StatusBadRequest},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/value/", strings.NewReader(test.body)))
+			assert.Equal(t, test.want, rec.Code)
+			if test.wantBody != "" {
+				assert.JSONEq(t, test.wantBody, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/database"
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/labstack/echo/v4"
+)
+
+// сколько ждать ответа компонентов при проверке состояния
+const healthCheckTimeout = 2 * time.Second
+
+const (
+	statusOK       = "ok"
+	statusDegraded = "degraded"
+	statusFail     = "fail"
+)
+
+// HealthSources — части сервера, состояние которых проверяет Health.
+type HealthSources struct {
+	Storage storage.Storage
+	// DB — соединение с базой данных; не проверяется, если база не задана
+	DB *database.DBConnection
+	// Dumps — хранилище, которое периодически сохраняется в DumpTarget;
+	// nil, если периодического сохранения нет
+	Dumps        interface{ DumpStatus() (time.Time, error) }
+	DumpTarget   string
+	DumpInterval time.Duration
+	// Started — время запуска сервера, от него отсчитывается первое сохранение
+	Started time.Time
+}
+
+// pinger — хранилище, которое умеет дёшево проверить свою доступность.
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+type healthReport struct {
+	Status      string             `json:"status"`
+	Storage     storageHealth      `json:"storage"`
+	Database    *databaseHealth    `json:"database,omitempty"`
+	Persistence *persistenceHealth `json:"persistence,omitempty"`
+}
+
+type storageHealth struct {
+	Status   string `json:"status"`
+	Counters int    `json:"counters"`
+	Gauges   int    `json:"gauges"`
+	Error    string `json:"error,omitempty"`
+}
+
+type databaseHealth struct {
+	Status        string             `json:"status"`
+	LatencyMs     float64            `json:"latency_ms"`
+	SchemaVersion int64              `json:"schema_version"`
+	Pool          database.PoolStats `json:"pool"`
+	Error         string             `json:"error,omitempty"`
+}
+
+type persistenceHealth struct {
+	Status   string     `json:"status"`
+	Target   string     `json:"target"`
+	Interval string     `json:"interval"`
+	LastDump *time.Time `json:"last_dump,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// Health отдаёт подробный отчёт о состоянии сервера. Код ответа 503,
+// если сервер не может обслуживать запросы.
+func Health(src HealthSources) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		report := checkHealth(ctx.Request().Context(), src, true)
+		return ctx.JSON(healthCode(report.Status), report)
+	}
+}
+
+// Live сообщает, что процесс сервера работает.
+func Live() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		return ctx.JSON(http.StatusOK, map[string]string{"status": statusOK})
+	}
+}
+
+// Ready сообщает, готов ли сервер принимать запросы. Проверка не
+// перебирает серии хранилища, поэтому её можно вызывать часто.
+func Ready(src HealthSources) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		report := checkHealth(ctx.Request().Context(), src, false)
+		return ctx.JSON(healthCode(report.Status), map[string]string{"status": report.Status})
+	}
+}
+
+func healthCode(status string) int {
+	if status == statusFail {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusOK
+}
+
+// checkHealth проверяет компоненты сервера; с detailed в отчёт
+// попадает число серий хранилища.
+func checkHealth(ctx context.Context, src HealthSources, detailed bool) healthReport {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	report := healthReport{Status: statusOK}
+
+	if detailed {
+		report.Storage = countStorage(ctx, src.Storage)
+	} else {
+		report.Storage = pingStorage(ctx, src.Storage)
+	}
+	report.Status = worse(report.Status, report.Storage.Status)
+
+	if src.DB != nil && src.DB.Pool != nil {
+		report.Database = checkDatabase(ctx, src.DB)
+		report.Status = worse(report.Status, report.Database.Status)
+	}
+
+	if src.Dumps != nil {
+		report.Persistence = checkPersistence(src)
+		report.Status = worse(report.Status, report.Persistence.Status)
+	}
+	return report
+}
+
+// pingStorage проверяет доступность хранилища через Ping. Хранилище
+// без Ping считается доступным.
+func pingStorage(ctx context.Context, s storage.Storage) storageHealth {
+	h := storageHealth{Status: statusOK}
+	p, ok := s.(pinger)
+	if !ok {
+		return h
+	}
+	if err := p.Ping(ctx); err != nil {
+		h.Status, h.Error = statusFail, err.Error()
+	}
+	return h
+}
+
+// countStorage перебирает серии хранилища и сообщает их число.
+func countStorage(ctx context.Context, s storage.Storage) storageHealth {
+	h := storageHealth{Status: statusOK}
+	counters, err := s.Counters(ctx)
+	if err == nil {
+		var gauges map[string]float64
+		gauges, err = s.Gauges(ctx)
+		h.Counters, h.Gauges = len(counters), len(gauges)
+	}
+	if err != nil {
+		h.Status, h.Error = statusFail, err.Error()
+	}
+	return h
+}
+
+func checkDatabase(ctx context.Context, db *database.DBConnection) *databaseHealth {
+	h := &databaseHealth{Status: statusOK, Pool: db.Stats()}
+
+	latency, err := database.Ping(ctx, db)
+	h.LatencyMs = float64(latency.Microseconds()) / 1000
+	if err != nil {
+		h.Status, h.Error = statusFail, err.Error()
+		return h
+	}
+
+	h.SchemaVersion, err = database.SchemaVersion(ctx, db)
+	if err != nil {
+		h.Status, h.Error = statusDegraded, err.Error()
+	}
+	return h
+}
+
+// checkPersistence считает сохранение отстающим, если с последнего
+// успешного сохранения прошло больше двух интервалов.
+func checkPersistence(src HealthSources) *persistenceHealth {
+	h := &persistenceHealth{Status: statusOK, Target: src.DumpTarget, Interval: src.DumpInterval.String()}
+
+	last, err := src.Dumps.DumpStatus()
+	since := src.Started
+	if !last.IsZero() {
+		h.LastDump = &last
+		since = last
+	}
+
+	switch {
+	case err != nil:
+		h.Status, h.Error = statusDegraded, err.Error()
+	case src.DumpInterval > 0 && time.Since(since) > 2*src.DumpInterval:
+		h.Status, h.Error = statusDegraded, "last dump is overdue"
+	}
+	return h
+}
+
+// worse возвращает худшее из двух состояний.
+func worse(a, b string) string {
+	rank := map[string]int{statusOK: 0, statusDegraded: 1, statusFail: 2}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
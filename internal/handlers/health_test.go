@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/database"
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+	s := storage.New(0, "", false)
+	s.UpdateCounter(context.Background(), "PollCount", 1)
+	s.UpdateGauge(context.Background(), "Alloc", 1.5)
+
+	failed := storage.New(0, "", false)
+	failed.SetDumpResult(errors.New("disk full"))
+
+	testCases := []struct {
+		name   string
+		src    HealthSources
+		url    string
+		status int
+		body   string
+	}{
+		{name: "Health() Test 1", url: "/health", status: http.StatusOK,
+			src:  HealthSources{Storage: s, DB: database.New("", database.DefaultOptions)},
+			body: `{"status":"ok","storage":{"status":"ok","counters":1,"gauges":1}}`},
+		{name: "Health() Test 2", url: "/health", status: http.StatusOK,
+			src: HealthSources{Storage: failed, Dumps: failed, DumpTarget: "file", DumpInterval: time.Minute, Started: time.Now()},
+			body: `{"status":"degraded","storage":{"status":"ok","counters":0,"gauges":0},
+				"persistence":{"status":"degraded","target":"file","interval":"1m0s","error":"disk full"}}`},
+		{name: "Health() Test 3", url: "/health", status: http.StatusOK,
+			src: HealthSources{Storage: s, Dumps: s, DumpTarget: "database", DumpInterval: time.Second, Started: time.Now().Add(-time.Minute)},
+			body: `{"status":"degraded","storage":{"status":"ok","counters":1,"gauges":1},
+				"persistence":{"status":"degraded","target":"database","interval":"1s","error":"last dump is overdue"}}`},
+		{name: "Health() Test 4", url: "/health/ready", status: http.StatusOK,
+			src: HealthSources{Storage: s}, body: `{"status":"ok"}`},
+		{name: "Health() Test 5", url: "/health/live", status: http.StatusOK,
+			src: HealthSources{Storage: s}, body: `{"status":"ok"}`},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			e := echo.New()
+			e.GET("/health", Health(test.src))
+			e.GET("/health/live", Live())
+			e.GET("/health/ready", Ready(test.src))
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.url, nil))
+
+			assert.Equal(t, test.status, rec.Code)
+			assert.JSONEq(t, test.body, rec.Body.String())
+		})
+	}
+}
+
+// scanCountingStorage считает полные перечисления серий.
+type scanCountingStorage struct {
+	*storage.MemStorage
+	scans   int
+	pingErr error
+}
+
+func (s *scanCountingStorage) Counters(ctx context.Context) (map[string]int64, error) {
+	s.scans++
+	return s.MemStorage.Counters(ctx)
+}
+
+func (s *scanCountingStorage) Gauges(ctx context.Context) (map[string]float64, error) {
+	s.scans++
+	return s.MemStorage.Gauges(ctx)
+}
+
+func (s *scanCountingStorage) Ping(ctx context.Context) error { return s.pingErr }
+
+func TestReady(t *testing.T) {
+	s := &scanCountingStorage{MemStorage: storage.New(0, "", false)}
+	e := echo.New()
+	e.GET("/health/ready", Ready(HealthSources{Storage: s}))
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 0, s.scans)
+
+	s.pingErr = errors.New("connection refused")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"status":"fail"}`, rec.Body.String())
+	assert.Equal(t, 0, s.scans)
+}
+
+func TestPingDB(t *testing.T) {
+	e := echo.New()
+	e.GET("/ping", PingDB(database.New("", database.DefaultOptions)))
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ping", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, "Connection database failed: Empty connection string", rec.Body.String())
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/lineprotocol"
+	"github.com/amidvn/go-metrics/internal/models"
+	"github.com/amidvn/go-metrics/internal/series"
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/labstack/echo/v4"
+)
+
+// максимальная длина одной строки line protocol
+const maxLineLength = 1 << 20
+
+type lineError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+type partialWrite struct {
+	Error string      `json:"error"`
+	Lines []lineError `json:"lines"`
+	// Counters — счётчики, поднятые до ошибки хранилища
+	Counters []string `json:"counters,omitempty"`
+}
+
+type timedValue struct {
+	value float64
+	time  time.Time
+}
+
+type timedCount struct {
+	value int64
+	time  time.Time
+}
+
+// InfluxWrite принимает метрики в формате InfluxDB line protocol.
+// Каждое поле строки становится серией measurement_field с тегами
+// строки в качестве меток; поле value — серией measurement.
+// Числовые и логические поля сохраняются как gauge. Целые поля серий
+// с суффиксом _total, как у счётчиков Prometheus, сохраняются как
+// счётчики: переданное накопленное значение поднимает хранимое, но не
+// уменьшает его. Строковые поля пропускаются. Строка с беззнаковым
+// полем больше MaxInt64 или с отрицательным счётчиком отклоняется.
+//
+// Метка времени строки определяет только порядок точек одной серии
+// внутри запроса: сохраняется значение самой поздней. Хранилище
+// держит текущие значения, поэтому и они, и история записываются
+// с временем приёма запроса, а не с меткой строки.
+//
+// Корректные строки сохраняются, даже если в запросе есть ошибочные;
+// об ошибочных сообщается в ответе 400 с номерами строк. Gauge
+// сохраняются одним пакетом, а счётчики поднимаются по одному в порядке
+// имён. Если хранилище вернуло ошибку на счётчике, ответ 500 перечисляет
+// уже поднятые счётчики: gauge к этому моменту тоже сохранены.
+func InfluxWrite(s storage.Storage) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		precision, err := lineprotocol.ParsePrecision(ctx.QueryParam("precision"))
+		if err != nil {
+			return ctx.String(http.StatusBadRequest, err.Error())
+		}
+
+		now := time.Now()
+		gauges := make(map[string]timedValue)
+		counters := make(map[string]timedCount)
+		var lineErrors []lineError
+		var total int
+
+		scanner := bufio.NewScanner(ctx.Request().Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+		for n := 1; scanner.Scan(); n++ {
+			line := scanner.Text()
+			if lineprotocol.IsBlank(line) {
+				continue
+			}
+			total++
+
+			p, err := lineprotocol.Parse(line, precision, now)
+			if err == nil {
+				err = checkFields(p)
+			}
+			if err != nil {
+				lineErrors = append(lineErrors, lineError{Line: n, Error: err.Error()})
+				continue
+			}
+			for field, f := range p.Fields {
+				name := seriesName(p, field)
+				key := series.New(name, p.Tags).String()
+
+				switch {
+				case isCounter(name, f):
+					v := f.Int
+					if f.Type == lineprotocol.Unsigned {
+						v = int64(f.Uint)
+					}
+					if prev, ok := counters[key]; !ok || !p.Time.Before(prev.time) {
+						counters[key] = timedCount{value: v, time: p.Time}
+					}
+				default:
+					v, ok := f.Number()
+					if !ok {
+						continue
+					}
+					if prev, ok := gauges[key]; !ok || !p.Time.Before(prev.time) {
+						gauges[key] = timedValue{value: v, time: p.Time}
+					}
+				}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return ctx.String(http.StatusBadRequest, err.Error())
+		}
+
+		metrics := make([]models.Metrics, 0, len(gauges))
+		for key, tv := range gauges {
+			v := tv.value
+			metrics = append(metrics, models.Metrics{ID: key, MType: "gauge", Value: &v})
+		}
+		if err := s.StoreBatch(ctx.Request().Context(), metrics); err != nil {
+			return ctx.String(http.StatusInternalServerError, err.Error())
+		}
+		keys := make([]string, 0, len(counters))
+		for key := range counters {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for i, key := range keys {
+			if err := s.RaiseCounter(ctx.Request().Context(), key, counters[key].value); err != nil {
+				return ctx.JSON(http.StatusInternalServerError, partialWrite{
+					Error:    fmt.Sprintf("partial write: %d of %d counters raised: %s", i, len(keys), err),
+					Lines:    lineErrors,
+					Counters: keys[:i],
+				})
+			}
+		}
+
+		if len(lineErrors) != 0 {
+			return ctx.JSON(http.StatusBadRequest, partialWrite{
+				Error: fmt.Sprintf("partial write: %d of %d lines rejected", len(lineErrors), total),
+				Lines: lineErrors,
+			})
+		}
+		return ctx.NoContent(http.StatusNoContent)
+	}
+}
+
+// seriesName возвращает имя серии поля точки.
+func seriesName(p lineprotocol.Point, field string) string {
+	if field == "value" {
+		return p.Measurement
+	}
+	return p.Measurement + "_" + field
+}
+
+// isCounter сообщает, сохраняется ли поле серии name как счётчик.
+func isCounter(name string, f lineprotocol.Field) bool {
+	isInt := f.Type == lineprotocol.Integer || f.Type == lineprotocol.Unsigned
+	return isInt && strings.HasSuffix(name, "_total")
+}
+
+// checkFields проверяет, что беззнаковые поля точки помещаются в int64,
+// а счётчики не отрицательны. Строка проверяется целиком до сохранения,
+// чтобы не сохранить её частично.
+func checkFields(p lineprotocol.Point) error {
+	for name, f := range p.Fields {
+		if f.Type == lineprotocol.Unsigned && f.Uint > math.MaxInt64 {
+			return fmt.Errorf("field %s: unsigned value %d overflows int64", name, f.Uint)
+		}
+		if f.Type == lineprotocol.Integer && f.Int < 0 && isCounter(seriesName(p, name), f) {
+			return fmt.Errorf("field %s: negative counter value %d", name, f.Int)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxWrite(t *testing.T) {
+	ctx := context.Background()
+	s := storage.New(0, "", false)
+	s.UpdateCounter(ctx, `net_packets_total{host="a"}`, 40)
+	s.UpdateCounter(ctx, `net_errors_total{host="a"}`, 9)
+
+	e := echo.New()
+	e.POST("/write", InfluxWrite(s))
+
+	body := strings.Join([]string{
+		"# comment",
+		"cpu,host=a usage=0.25,value=1 1682935200",
+		"cpu,host=a usage=0.75 1682935100",
+		"net,host=a packets_total=100i,errors_total=5u,queue=7i,state=\"up\"",
+		"broken line",
+		"net,host=a queue=18446744073709551615u",
+		"net,host=b packets_total=-3i,queue=1i",
+		"",
+	}, "\n")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/write?precision=s", strings.NewReader(body)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.JSONEq(t, `{"error":"partial write: 3 of 6 lines rejected","lines":[`+
+		`{"line":5,"error":"field line: missing value"},`+
+		`{"line":6,"error":"field queue: unsigned value 18446744073709551615 overflows int64"},`+
+		`{"line":7,"error":"field packets_total: negative counter value -3"}]}`, rec.Body.String())
+
+	g, err := s.GetGaugeValue(ctx, `cpu_usage{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, 0.25, g)
+	g, err = s.GetGaugeValue(ctx, `cpu{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, g)
+	g, err = s.GetGaugeValue(ctx, `net_queue{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, 7.0, g)
+	c, err := s.GetCounterValue(ctx, `net_packets_total{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), c)
+	c, err = s.GetCounterValue(ctx, `net_errors_total{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(9), c)
+	// отклонённая строка не сохраняется и частично
+	_, err = s.GetCounterValue(ctx, `net_packets_total{host="b"}`)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = s.GetGaugeValue(ctx, `net_queue{host="b"}`)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	// повторная запись того же накопленного значения ничего не прибавляет
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/write", strings.NewReader("net,host=a packets_total=100i\n")))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	c, err = s.GetCounterValue(ctx, `net_packets_total{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), c)
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/write", strings.NewReader("mem used=1i\n")))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	// NaN и Inf в line protocol не допускаются
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/write", strings.NewReader("mem free=NaN\nmem free=2\nswap used=+Inf\n")))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.JSONEq(t, `{"error":"partial write: 2 of 3 lines rejected","lines":[`+
+		`{"line":1,"error":"field free: invalid number NaN"},`+
+		`{"line":3,"error":"field used: invalid number +Inf"}]}`, rec.Body.String())
+	g, err = s.GetGaugeValue(ctx, "mem_free")
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, g)
+	_, err = s.GetGaugeValue(ctx, "swap_used")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/write?precision=d", strings.NewReader("mem used=1i\n")))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+// failingCounterStorage возвращает ошибку при подъёме счётчика failOn.
+type failingCounterStorage struct {
+	*storage.MemStorage
+	failOn string
+}
+
+func (s *failingCounterStorage) RaiseCounter(ctx context.Context, n string, v int64) error {
+	if n == s.failOn {
+		return errors.New("storage is unavailable")
+	}
+	return s.MemStorage.RaiseCounter(ctx, n, v)
+}
+
+func TestInfluxWriteCounterError(t *testing.T) {
+	ctx := context.Background()
+	s := &failingCounterStorage{MemStorage: storage.New(0, "", false), failOn: "jobs_c_total"}
+	e := echo.New()
+	e.POST("/write", InfluxWrite(s))
+
+	body := "jobs a_total=1i,b_total=2i,c_total=3i,d_total=4i,queue=5i\nbroken line\n"
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/write", strings.NewReader(body)))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.JSONEq(t, `{"error":"partial write: 2 of 4 counters raised: storage is unavailable",`+
+		`"lines":[{"line":2,"error":"field line: missing value"}],`+
+		`"counters":["jobs_a_total","jobs_b_total"]}`, rec.Body.String())
+
+	g, err := s.GetGaugeValue(ctx, "jobs_queue")
+	require.NoError(t, err)
+	assert.Equal(t, 5.0, g)
+	c, err := s.GetCounterValue(ctx, "jobs_b_total")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), c)
+	_, err = s.GetCounterValue(ctx, "jobs_d_total")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/amidvn/go-metrics/internal/listing"
+	"github.com/amidvn/go-metrics/internal/series"
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/labstack/echo/v4"
+)
+
+type listItem struct {
+	ID     string            `json:"id"`
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Type   string            `json:"type"`
+	Value  jsonFloat         `json:"value"`
+}
+
+type listResponse struct {
+	Metrics    []listItem `json:"metrics"`
+	Total      int        `json:"total"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// ListMetrics возвращает список серий в JSON. Параметры запроса:
+// type — gauge или counter; name — шаблон имени с * и ?; name_re —
+// регулярное выражение для имени; label — условие на метку вида
+// host="web1", host!="web1", host=~"web.*" или host!~"web.*", может
+// повторяться; sort — name, type или value, с '-' для убывания;
+// limit — размер страницы; cursor — значение next_cursor из
+// предыдущего ответа.
+func ListMetrics(s storage.Storage) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		q := listing.Query{
+			Type:   ctx.QueryParam("type"),
+			Sort:   ctx.QueryParam("sort"),
+			Cursor: ctx.QueryParam("cursor"),
+		}
+
+		name, nameRe := ctx.QueryParam("name"), ctx.QueryParam("name_re")
+		switch {
+		case name != "" && nameRe != "":
+			return ctx.String(http.StatusBadRequest, "Only one of 'name' and 'name_re' may be set")
+		case name != "":
+			q.Name = listing.Glob(name)
+		case nameRe != "":
+			re, err := regexp.Compile("^(?:" + nameRe + ")$")
+			if err != nil {
+				return ctx.String(http.StatusBadRequest, fmt.Sprintf("Invalid 'name_re' parameter: %s", err))
+			}
+			q.Name = re
+		}
+
+		for _, l := range ctx.QueryParams()["label"] {
+			m, err := series.ParseMatcher(l)
+			if err != nil {
+				return ctx.String(http.StatusBadRequest, fmt.Sprintf("Invalid 'label' parameter: %s", err))
+			}
+			q.Matchers = append(q.Matchers, m)
+		}
+
+		if v := ctx.QueryParam("limit"); v != "" {
+			limit, err := strconv.Atoi(v)
+			if err != nil || limit <= 0 {
+				return ctx.String(http.StatusBadRequest, fmt.Sprintf("Invalid 'limit' parameter: %s", v))
+			}
+			q.Limit = limit
+		}
+		if err := q.Validate(); err != nil {
+			return ctx.String(http.StatusBadRequest, err.Error())
+		}
+
+		counters, err := s.Counters(ctx.Request().Context())
+		if err != nil {
+			return ctx.String(http.StatusInternalServerError, err.Error())
+		}
+		gauges, err := s.Gauges(ctx.Request().Context())
+		if err != nil {
+			return ctx.String(http.StatusInternalServerError, err.Error())
+		}
+
+		page, err := listing.List(counters, gauges, q)
+		if errors.Is(err, listing.ErrInvalidCursor) {
+			return ctx.String(http.StatusBadRequest, err.Error())
+		}
+		if err != nil {
+			return ctx.String(http.StatusInternalServerError, err.Error())
+		}
+
+		resp := listResponse{Metrics: make([]listItem, 0, len(page.Items)), Total: page.Total, NextCursor: page.Next}
+		for _, item := range page.Items {
+			li := listItem{ID: item.Key, Name: item.Name, Type: item.Type, Value: jsonFloat(item.Value)}
+			if len(item.Labels) != 0 {
+				li.Labels = make(map[string]string, len(item.Labels))
+				for _, l := range item.Labels {
+					li.Labels[l.Name] = l.Value
+				}
+			}
+			resp.Metrics = append(resp.Metrics, li)
+		}
+		return ctx.JSON(http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListMetrics(t *testing.T) {
+	ctx := context.Background()
+	s := storage.New(0, "", false)
+	s.UpdateCounter(ctx, `http_requests{host="web1"}`, 10)
+	s.UpdateCounter(ctx, `http_requests{host="web2"}`, 30)
+	s.UpdateGauge(ctx, "Alloc", 100)
+
+	e := echo.New()
+	e.GET("/api/v1/metrics", ListMetrics(s))
+	get := func(query url.Values) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/metrics?"+query.Encode(), nil))
+		return rec
+	}
+
+	rec := get(url.Values{"label": {`host!="web2"`}, "name": {"http_*"}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"metrics":[{"id":"http_requests{host=\"web1\"}","name":"http_requests","labels":{"host":"web1"},"type":"counter","value":10}],"total":1}`, rec.Body.String())
+
+	rec = get(url.Values{"sort": {"-value"}, "limit": {"2"}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp listResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.Metrics, 2)
+	assert.Equal(t, "Alloc", resp.Metrics[0].ID)
+	assert.Equal(t, 3, resp.Total)
+	require.NotEmpty(t, resp.NextCursor)
+
+	rec = get(url.Values{"sort": {"-value"}, "limit": {"2"}, "cursor": {resp.NextCursor}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"metrics":[{"id":"http_requests{host=\"web1\"}","name":"http_requests","labels":{"host":"web1"},"type":"counter","value":10}],"total":3}`, rec.Body.String())
+
+	for _, query := range []url.Values{
+		{"type": {"histogram"}},
+		{"name": {"a"}, "name_re": {"b"}},
+		{"name_re": {"("}},
+		{"label": {"host"}},
+		{"limit": {"0"}},
+		{"sort": {"name"}, "cursor": {resp.NextCursor}},
+	} {
+		assert.Equal(t, http.StatusBadRequest, get(query).Code, query.Encode())
+	}
+
+	// значения, которых нет в JSON, передаются строками
+	s.UpdateGauge(ctx, "Ratio", math.NaN())
+	s.UpdateGauge(ctx, "Peak", math.Inf(1))
+	rec = get(url.Values{"type": {"gauge"}, "sort": {"value"}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"metrics":[`+
+		`{"id":"Ratio","name":"Ratio","type":"gauge","value":"NaN"},`+
+		`{"id":"Alloc","name":"Alloc","type":"gauge","value":100},`+
+		`{"id":"Peak","name":"Peak","type":"gauge","value":"+Inf"}],"total":3}`, rec.Body.String())
+}
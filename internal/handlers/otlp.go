@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/amidvn/go-metrics/internal/otlp"
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/labstack/echo/v4"
+)
+
+// максимальный размер тела запроса OTLP
+const maxOTLPBody = 32 << 20
+
+// OTLPMetrics принимает метрики OpenTelemetry по OTLP/HTTP. Запрос
+// и ответ кодируются в protobuf или JSON по заголовку Content-Type.
+// О точках неподдерживаемых типов сообщается в partial_success ответа.
+func OTLPMetrics(s storage.Storage) echo.HandlerFunc {
+	tracker := otlp.NewTracker()
+	return func(ctx echo.Context) error {
+		mediaType, _, _ := mime.ParseMediaType(ctx.Request().Header.Get(echo.HeaderContentType))
+		var (
+			unmarshal func([]byte) (otlp.Request, error)
+			response  func(int, string) []byte
+		)
+		switch mediaType {
+		case "application/x-protobuf":
+			unmarshal, response = otlp.Unmarshal, otlp.MarshalResponse
+		case "application/json":
+			unmarshal, response = otlp.UnmarshalJSON, otlp.MarshalResponseJSON
+		default:
+			return ctx.String(http.StatusUnsupportedMediaType, "Content-Type must be application/x-protobuf or application/json")
+		}
+
+		body, err := io.ReadAll(io.LimitReader(ctx.Request().Body, maxOTLPBody+1))
+		if err != nil {
+			return ctx.String(http.StatusBadRequest, err.Error())
+		}
+		if len(body) > maxOTLPBody {
+			return ctx.String(http.StatusRequestEntityTooLarge, "request body is too large")
+		}
+
+		req, err := unmarshal(body)
+		if err != nil {
+			return ctx.String(http.StatusBadRequest, fmt.Sprintf("Error in OTLP decode: %s", err))
+		}
+		rejected, err := req.Store(ctx.Request().Context(), s, tracker)
+		if err != nil {
+			return ctx.String(http.StatusInternalServerError, err.Error())
+		}
+
+		var message string
+		if rejected != 0 {
+			message = fmt.Sprintf("%d data points of unsupported or invalid metrics rejected", rejected)
+		}
+		return ctx.Blob(http.StatusOK, mediaType, response(rejected, message))
+	}
+}
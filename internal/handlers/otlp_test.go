@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amidvn/go-metrics/internal/otlp"
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOTLPMetrics(t *testing.T) {
+	ctx := context.Background()
+	s := storage.New(0, "", false)
+	e := echo.New()
+	e.POST("/v1/metrics", OTLPMetrics(s))
+
+	body := otlp.Marshal(otlp.Request{ResourceMetrics: []otlp.ResourceMetrics{{
+		Attributes: map[string]string{"service.name": "shop"},
+		Metrics: []otlp.Metric{
+			{Name: "queue.size", Kind: otlp.Gauge, Points: []otlp.NumberPoint{{Value: 3}}},
+			{Name: "rpc.duration", Unsupported: 1},
+		},
+	}}})
+
+	testCases := []struct {
+		name        string
+		contentType string
+		body        []byte
+		want        int
+		wantBody    string
+	}{
+		{name: "OTLPMetrics() Test 1", contentType: "application/x-protobuf", body: body, want: http.StatusOK,
+			wantBody: string(otlp.MarshalResponse(1, "1 data points of unsupported or invalid metrics rejected"))},
+		{name: "OTLPMetrics() Test 2", contentType: "application/json", want: http.StatusOK, wantBody: "{}",
+			body: []byte(`{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"name":"temp","gauge":{"dataPoints":[{"asDouble":21.5}]}}]}]}]}`)},
+		{name: "OTLPMetrics() Test 3", contentType: "application/json", body: []byte("{"), want: http.StatusBadRequest},
+		{name: "OTLPMetrics() Test 4", contentType: "text/plain", body: body, want: http.StatusUnsupportedMediaType},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(test.body))
+			req.Header.Set(echo.HeaderContentType, test.contentType)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(t, test.want, rec.Code)
+			if test.wantBody != "" {
+				assert.Equal(t, test.wantBody, rec.Body.String())
+			}
+		})
+	}
+
+	v, err := s.GetGaugeValue(ctx, `queue_size{service_name="shop"}`)
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, v)
+	v, err = s.GetGaugeValue(ctx, "temp")
+	require.NoError(t, err)
+	assert.Equal(t, 21.5, v)
+}
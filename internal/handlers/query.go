@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/query"
+	"github.com/amidvn/go-metrics/internal/series"
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/labstack/echo/v4"
+)
+
+// DefaultQueryStep — шаг запроса /api/v1/query_range по умолчанию.
+const DefaultQueryStep = time.Minute
+
+type rangeSeries struct {
+	ID     string            `json:"id"`
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Type   string            `json:"type"`
+	Points []historyPoint    `json:"points"`
+}
+
+type rangeResponse struct {
+	Series []rangeSeries `json:"series"`
+}
+
+// rangeRequest — тело POST-запроса в JSON. Поля совпадают
+// с параметрами GET-запроса.
+type rangeRequest struct {
+	Series []string  `json:"series"`
+	Type   string    `json:"type"`
+	Start  jsonParam `json:"start"`
+	End    jsonParam `json:"end"`
+	Step   jsonParam `json:"step"`
+	Fn     string    `json:"fn"`
+	P      jsonParam `json:"p"`
+}
+
+// jsonParam принимает строку или число, чтобы start, end и step
+// можно было передавать так же, как в параметрах запроса.
+type jsonParam string
+
+func (p *jsonParam) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*p = jsonParam(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*p = jsonParam(n)
+	return nil
+}
+
+// QueryRange возвращает значения серий за интервал на сетке с заданным
+// шагом. Параметры передаются в строке GET-запроса, в форме или в JSON
+// POST-запроса: series — селектор серий вида name{label="value"},
+// может повторяться; type — gauge или counter; start и end — границы
+// в секундах Unix или RFC3339, по умолчанию последний час; step —
+// шаг в виде 30s или в секундах; fn — avg, min, max, sum, last, rate
+// или percentile; p — процентиль для percentile.
+func QueryRange(s storage.Storage, h storage.History) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		params, err := rangeParams(ctx)
+		if err != nil {
+			return ctx.String(http.StatusBadRequest, fmt.Sprintf("Invalid request body: %s", err))
+		}
+
+		q := query.RangeQuery{Type: params.Get("type"), Func: params.Get("fn")}
+		for _, v := range params["series"] {
+			sel, err := series.ParseSelector(v)
+			if err != nil {
+				return ctx.String(http.StatusBadRequest, fmt.Sprintf("Invalid 'series' parameter: %s", err))
+			}
+			q.Selectors = append(q.Selectors, sel)
+		}
+		if q.End, err = parseTime(params.Get("end"), time.Now()); err != nil {
+			return ctx.String(http.StatusBadRequest, fmt.Sprintf("Invalid 'end' parameter: %s", err))
+		}
+		if q.Start, err = parseTime(params.Get("start"), q.End.Add(-time.Hour)); err != nil {
+			return ctx.String(http.StatusBadRequest, fmt.Sprintf("Invalid 'start' parameter: %s", err))
+		}
+		if q.Step, err = parseStep(params.Get("step")); err != nil {
+			return ctx.String(http.StatusBadRequest, fmt.Sprintf("Invalid 'step' parameter: %s", err))
+		}
+		if v := params.Get("p"); v != "" {
+			if q.Percentile, err = strconv.ParseFloat(v, 64); err != nil {
+				return ctx.String(http.StatusBadRequest, fmt.Sprintf("Invalid 'p' parameter: %s", v))
+			}
+		}
+		if err := q.Validate(); err != nil {
+			return ctx.String(http.StatusBadRequest, err.Error())
+		}
+
+		result, err := query.Range(ctx.Request().Context(), s, h, q)
+		if err != nil {
+			return ctx.String(http.StatusInternalServerError, err.Error())
+		}
+
+		resp := rangeResponse{Series: matrixSeries(result)}
+		return ctx.JSON(http.StatusOK, resp)
+	}
+}
+
+type vectorItem struct {
+	ID     string            `json:"id"`
+	Name   string            `json:"name,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  jsonFloat         `json:"value"`
+}
+
+type queryResponse struct {
+	Type   query.ValueType `json:"type"`
+	Time   time.Time       `json:"time"`
+	Result any             `json:"result"`
+}
+
+// Query вычисляет выражение из параметра query, например
+// sum by (host) (rate(PollCount[5m])). Параметр time — момент
+// вычисления в секундах Unix или RFC3339; без него берутся текущие
+// значения хранилища. Интервалы и time требуют включённой истории,
+// h может быть nil. Параметры принимаются в строке GET-запроса или
+// в форме POST-запроса.
+func Query(s storage.Storage, h storage.History) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		input := ctx.FormValue("query")
+		if input == "" {
+			return ctx.String(http.StatusBadRequest, "Missing 'query' parameter")
+		}
+		expr, err := query.Parse(input)
+		if err != nil {
+			return ctx.String(http.StatusBadRequest, err.Error())
+		}
+
+		ev := query.Evaluator{Storage: s, History: h}
+		if v := ctx.FormValue("time"); v != "" {
+			if ev.Time, err = parseTime(v, time.Time{}); err != nil {
+				return ctx.String(http.StatusBadRequest, fmt.Sprintf("Invalid 'time' parameter: %s", err))
+			}
+		}
+		at := ev.Time
+		if at.IsZero() {
+			at = time.Now()
+		}
+
+		value, err := ev.Eval(ctx.Request().Context(), expr)
+		switch {
+		case errors.Is(err, query.ErrBadQuery), errors.Is(err, query.ErrNoHistory):
+			return ctx.String(http.StatusBadRequest, err.Error())
+		case err != nil:
+			return ctx.String(http.StatusInternalServerError, err.Error())
+		}
+
+		resp := queryResponse{Type: value.Type(), Time: at.UTC()}
+		switch value := value.(type) {
+		case query.Scalar:
+			resp.Result = jsonFloat(value)
+		case query.Vector:
+			items := make([]vectorItem, 0, len(value))
+			for _, smp := range value {
+				items = append(items, vectorItem{ID: smp.Key.String(), Name: smp.Key.Name, Labels: labelMap(smp.Key.Labels), Value: jsonFloat(smp.Value)})
+			}
+			resp.Result = items
+		case query.Matrix:
+			resp.Result = matrixSeries(value)
+		}
+		return ctx.JSON(http.StatusOK, resp)
+	}
+}
+
+func labelMap(labels []series.Label) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(labels))
+	for _, l := range labels {
+		m[l.Name] = l.Value
+	}
+	return m
+}
+
+func matrixSeries(result []query.Series) []rangeSeries {
+	out := make([]rangeSeries, 0, len(result))
+	for _, sr := range result {
+		rs := rangeSeries{ID: sr.Key, Name: sr.Name, Labels: labelMap(sr.Labels), Type: sr.Type, Points: make([]historyPoint, 0, len(sr.Points))}
+		for _, p := range sr.Points {
+			rs.Points = append(rs.Points, historyPoint{Timestamp: p.Time.UTC(), Value: jsonFloat(p.Value)})
+		}
+		out = append(out, rs)
+	}
+	return out
+}
+
+// rangeParams собирает параметры запроса из строки запроса, формы
+// или JSON-тела.
+func rangeParams(ctx echo.Context) (url.Values, error) {
+	req := ctx.Request()
+	if req.Method != http.MethodPost {
+		return ctx.QueryParams(), nil
+	}
+	if !strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+		return ctx.FormParams()
+	}
+
+	var body rangeRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	params := url.Values{"series": body.Series}
+	for name, v := range map[string]string{
+		"type":  body.Type,
+		"start": string(body.Start),
+		"end":   string(body.End),
+		"step":  string(body.Step),
+		"fn":    body.Fn,
+		"p":     string(body.P),
+	} {
+		if v != "" {
+			params.Set(name, v)
+		}
+	}
+	return params, nil
+}
+
+// parseStep разбирает шаг в виде длительности (30s, 5m) или числа секунд.
+func parseStep(value string) (time.Duration, error) {
+	if value == "" {
+		return DefaultQueryStep, nil
+	}
+	if sec, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(sec * float64(time.Second)), nil
+	}
+	return time.ParseDuration(value)
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryRange(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	s := storage.New(0, "", false)
+	s.UpdateGauge(ctx, `cpu{host="a"}`, 0)
+	s.UpdateCounter(ctx, "requests", 0)
+	s.UpdateGauge(ctx, "ratio", 0)
+	s.UpdateGauge(ctx, "peak", 0)
+	h := fakeHistory{
+		{Name: `cpu{host="a"}`, MType: "gauge", Timestamp: base.Add(10 * time.Second), Value: 1},
+		{Name: `cpu{host="a"}`, MType: "gauge", Timestamp: base.Add(50 * time.Second), Value: 3},
+		{Name: "requests", MType: "counter", Timestamp: base.Add(-30 * time.Second), Value: 10},
+		{Name: "requests", MType: "counter", Timestamp: base.Add(30 * time.Second), Value: 40},
+		{Name: "ratio", MType: "gauge", Timestamp: base.Add(10 * time.Second), Value: math.NaN()},
+		{Name: "peak", MType: "gauge", Timestamp: base.Add(10 * time.Second), Value: math.Inf(1)},
+		{Name: "peak", MType: "gauge", Timestamp: base.Add(20 * time.Second), Value: math.Inf(-1)},
+	}
+
+	e := echo.New()
+	e.GET("/api/v1/query_range", QueryRange(s, h))
+	e.POST("/api/v1/query_range", QueryRange(s, h))
+
+	testCases := []struct {
+		name        string
+		method      string
+		contentType string
+		query       url.Values
+		body        string
+		status      int
+		want        string
+	}{
+		{name: "QueryRange() Test 1", method: http.MethodGet, status: http.StatusOK,
+			query: url.Values{"series": {`cpu{host=~"a|b"}`}, "start": {"2023-05-01T10:00:30Z"}, "end": {"2023-05-01T10:01:00Z"}, "step": {"1m"}},
+			want:  `{"series":[{"id":"cpu{host=\"a\"}","name":"cpu","labels":{"host":"a"},"type":"gauge","points":[{"timestamp":"2023-05-01T10:01:00Z","value":2}]}]}`},
+		{name: "QueryRange() Test 2", method: http.MethodPost, contentType: echo.MIMEApplicationForm, status: http.StatusOK,
+			body: url.Values{"series": {"cpu", "requests"}, "start": {"1682935260"}, "end": {"1682935260"}, "step": {"60"}, "fn": {"max"}, "type": {"counter"}}.Encode(),
+			want: `{"series":[{"id":"requests","name":"requests","type":"counter","points":[{"timestamp":"2023-05-01T10:01:00Z","value":40}]}]}`},
+		{name: "QueryRange() Test 3", method: http.MethodPost, contentType: echo.MIMEApplicationJSON, status: http.StatusOK,
+			body: `{"series":["requests"],"start":1682935260,"end":"2023-05-01T10:01:00Z","step":"1m","fn":"rate"}`,
+			want: `{"series":[{"id":"requests","name":"requests","type":"counter","points":[{"timestamp":"2023-05-01T10:01:00Z","value":0.5}]}]}`},
+		{name: "QueryRange() Test 4", method: http.MethodGet, status: http.StatusOK,
+			query: url.Values{"series": {"memory"}, "start": {"1682935200"}, "end": {"1682935260"}},
+			want:  `{"series":[]}`},
+		{name: "QueryRange() Test 5", method: http.MethodGet, status: http.StatusBadRequest,
+			query: url.Values{"series": {`cpu{host=a}`}}},
+		{name: "QueryRange() Test 6", method: http.MethodGet, status: http.StatusBadRequest,
+			query: url.Values{"series": {"cpu"}, "step": {"often"}}},
+		{name: "QueryRange() Test 7", method: http.MethodGet, status: http.StatusBadRequest,
+			query: url.Values{"series": {"cpu"}, "fn": {"percentile"}, "p": {"150"}}},
+		{name: "QueryRange() Test 8", method: http.MethodGet, status: http.StatusBadRequest},
+		{name: "QueryRange() Test 9", method: http.MethodPost, contentType: echo.MIMEApplicationJSON, status: http.StatusBadRequest,
+			body: `{"series":`},
+		// значения, которых нет в JSON, передаются строками
+		{name: "QueryRange() Test 10", method: http.MethodGet, status: http.StatusOK,
+			query: url.Values{"series": {"ratio", "peak"}, "start": {"1682935260"}, "end": {"1682935260"}, "step": {"1m"}, "fn": {"max"}},
+			want: `{"series":[` +
+				`{"id":"peak","name":"peak","type":"gauge","points":[{"timestamp":"2023-05-01T10:01:00Z","value":"+Inf"}]},` +
+				`{"id":"ratio","name":"ratio","type":"gauge","points":[{"timestamp":"2023-05-01T10:01:00Z","value":"NaN"}]}]}`},
+		{name: "QueryRange() Test 11", method: http.MethodGet, status: http.StatusOK,
+			query: url.Values{"series": {"peak"}, "start": {"1682935260"}, "end": {"1682935260"}, "step": {"1m"}, "fn": {"sum"}},
+			want:  `{"series":[{"id":"peak","name":"peak","type":"gauge","points":[{"timestamp":"2023-05-01T10:01:00Z","value":"NaN"}]}]}`},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, "/api/v1/query_range?"+test.query.Encode(), strings.NewReader(test.body))
+			if test.contentType != "" {
+				req.Header.Set(echo.HeaderContentType, test.contentType)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.status, rec.Code, rec.Body.String())
+			if test.want != "" {
+				assert.JSONEq(t, test.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	s := storage.New(0, "", false)
+	s.UpdateGauge(ctx, `HeapAlloc{host="a"}`, 100)
+	s.UpdateGauge(ctx, `HeapAlloc{host="b"}`, 300)
+	s.UpdateCounter(ctx, "PollCount", 40)
+	h := fakeHistory{
+		{Name: "PollCount", MType: "counter", Timestamp: base.Add(-4 * time.Minute), Value: 10},
+		{Name: "PollCount", MType: "counter", Timestamp: base, Value: 40},
+	}
+
+	e := echo.New()
+	e.GET("/api/v1/query", Query(s, h))
+	e.POST("/api/v1/query", Query(s, h))
+	e.GET("/nohistory", Query(s, nil))
+
+	testCases := []struct {
+		name   string
+		method string
+		url    string
+		form   url.Values
+		status int
+		want   string
+	}{
+		{name: "Query() Test 1", method: http.MethodGet, url: "/api/v1/query?" + url.Values{"query": {`sum(HeapAlloc) / 2`}, "time": {"2023-05-01T10:00:00Z"}}.Encode(),
+			status: http.StatusOK, want: `{"type":"vector","time":"2023-05-01T10:00:00Z","result":[]}`},
+		{name: "Query() Test 2", method: http.MethodPost, url: "/api/v1/query", form: url.Values{"query": {`HeapAlloc{host="b"}`}},
+			status: http.StatusOK},
+		{name: "Query() Test 3", method: http.MethodGet, url: "/api/v1/query?" + url.Values{"query": {`rate(PollCount[5m]) * 60`}, "time": {"1682935200"}}.Encode(),
+			status: http.StatusOK, want: `{"type":"vector","time":"2023-05-01T10:00:00Z","result":[{"id":"","value":6}]}`},
+		{name: "Query() Test 4", method: http.MethodGet, url: "/api/v1/query?" + url.Values{"query": {`PollCount[5m]`}, "time": {"1682935200"}}.Encode(),
+			status: http.StatusOK, want: `{"type":"matrix","time":"2023-05-01T10:00:00Z","result":[{"id":"PollCount","name":"PollCount","type":"counter",` +
+				`"points":[{"timestamp":"2023-05-01T09:56:00Z","value":10},{"timestamp":"2023-05-01T10:00:00Z","value":40}]}]}`},
+		{name: "Query() Test 5", method: http.MethodGet, url: "/api/v1/query?" + url.Values{"query": {`2 * 3`}, "time": {"1682935200"}}.Encode(),
+			status: http.StatusOK, want: `{"type":"scalar","time":"2023-05-01T10:00:00Z","result":6}`},
+		{name: "Query() Test 6", method: http.MethodGet, url: "/api/v1/query", status: http.StatusBadRequest},
+		{name: "Query() Test 7", method: http.MethodGet, url: "/api/v1/query?" + url.Values{"query": {`sum(`}}.Encode(), status: http.StatusBadRequest},
+		{name: "Query() Test 8", method: http.MethodGet, url: "/api/v1/query?" + url.Values{"query": {`x`}, "time": {"yesterday"}}.Encode(), status: http.StatusBadRequest},
+		{name: "Query() Test 9", method: http.MethodGet, url: "/nohistory?" + url.Values{"query": {`rate(PollCount[5m])`}}.Encode(), status: http.StatusBadRequest},
+		// значения, которых нет в JSON, передаются строками
+		{name: "Query() Test 10", method: http.MethodGet, url: "/nohistory?" + url.Values{"query": {`1 / 0`}, "time": {"1682935200"}}.Encode(),
+			status: http.StatusOK, want: `{"type":"scalar","time":"2023-05-01T10:00:00Z","result":"+Inf"}`},
+		{name: "Query() Test 11", method: http.MethodGet, url: "/nohistory?" + url.Values{"query": {`scalar(nothing)`}, "time": {"1682935200"}}.Encode(),
+			status: http.StatusOK, want: `{"type":"scalar","time":"2023-05-01T10:00:00Z","result":"NaN"}`},
+		{name: "Query() Test 12", method: http.MethodGet, url: "/nohistory?" + url.Values{"query": {`ln(vector(0))`}, "time": {"1682935200"}}.Encode(),
+			status: http.StatusOK, want: `{"type":"vector","time":"2023-05-01T10:00:00Z","result":[{"id":"","value":"-Inf"}]}`},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.url, strings.NewReader(test.form.Encode()))
+			if test.form != nil {
+				req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.status, rec.Code, rec.Body.String())
+			if test.want != "" {
+				assert.JSONEq(t, test.want, rec.Body.String())
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/query?"+url.Values{"query": {`HeapAlloc > 200`}}.Encode(), nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"result":[{"id":"HeapAlloc{host=\"b\"}","name":"HeapAlloc","labels":{"host":"b"},"value":300}]`)
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/nohistory?"+url.Values{"query": {`HeapAlloc{host="a"} / 0`}}.Encode(), nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"result":[{"id":"{host=\"a\"}","labels":{"host":"a"},"value":"+Inf"}]`)
+}
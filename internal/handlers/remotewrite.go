@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/amidvn/go-metrics/internal/remotewrite"
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/labstack/echo/v4"
+)
+
+// максимальный размер сжатого тела запроса remote_write
+const maxRemoteWriteBody = 32 << 20
+
+// максимальный размер тела remote_write после распаковки
+const maxRemoteWriteDecoded = 128 << 20
+
+// RemoteWrite принимает данные по протоколу Prometheus remote_write
+// и сохраняет их тем же пакетом, что и /updates/.
+func RemoteWrite(s storage.Storage) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		body, err := io.ReadAll(io.LimitReader(ctx.Request().Body, maxRemoteWriteBody+1))
+		if err != nil {
+			return ctx.String(http.StatusBadRequest, err.Error())
+		}
+		if len(body) > maxRemoteWriteBody {
+			return ctx.String(http.StatusRequestEntityTooLarge, "request body is too large")
+		}
+
+		req, err := remotewrite.Decode(body, maxRemoteWriteDecoded)
+		if errors.Is(err, remotewrite.ErrTooLarge) {
+			return ctx.String(http.StatusRequestEntityTooLarge, err.Error())
+		}
+		if err != nil {
+			return ctx.String(http.StatusBadRequest, fmt.Sprintf("Error in remote write decode: %s", err))
+		}
+		if err := s.StoreBatch(ctx.Request().Context(), req.Metrics()); err != nil {
+			return ctx.String(http.StatusInternalServerError, err.Error())
+		}
+
+		return ctx.NoContent(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amidvn/go-metrics/internal/remotewrite"
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteWrite(t *testing.T) {
+	s := storage.New(0, "", false)
+	e := echo.New()
+	e.POST("/api/v1/write", RemoteWrite(s))
+
+	body := remotewrite.Encode(remotewrite.WriteRequest{Timeseries: []remotewrite.TimeSeries{{
+		Labels:  []remotewrite.Label{{Name: "__name__", Value: "node_load1"}, {Name: "instance", Value: "a"}},
+		Samples: []remotewrite.Sample{{Value: 0.5, Timestamp: 1000}},
+	}}})
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	v, err := s.GetGaugeValue(context.Background(), `node_load1{instance="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, v)
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader([]byte("garbage"))))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff, 0x0f})))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
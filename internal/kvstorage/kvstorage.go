@@ -0,0 +1,244 @@
+// Package kvstorage реализует хранилище метрик во встроенной базе
+// ключ-значение bbolt. Каждое обновление сохраняется на диск в отдельной
+// транзакции, поэтому периодическое сохранение снапшотов не нужно.
+package kvstorage
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/models"
+	"github.com/amidvn/go-metrics/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	counterBucket = []byte("counter")
+	gaugeBucket   = []byte("gauge")
+)
+
+// сколько ждать блокировку файла, если он открыт другим процессом
+const openTimeout = time.Second
+
+type KVStorage struct {
+	storage.Recorder
+
+	db *bolt.DB
+}
+
+// Open открывает файл базы path, создавая его при необходимости.
+func Open(path string) (*KVStorage, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{counterBucket, gaugeBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &KVStorage{db: db}, nil
+}
+
+func (s *KVStorage) Close() error {
+	return s.db.Close()
+}
+
+// Ping проверяет, что файл базы открыт и доступен для чтения.
+func (s *KVStorage) Ping(ctx context.Context) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(counterBucket) == nil || tx.Bucket(gaugeBucket) == nil {
+			return errors.New("metric buckets are missing")
+		}
+		return nil
+	})
+}
+
+// UpdateCounter и UpdateGauge выполняются через Batch: параллельные
+// обновления объединяются в одну транзакцию и одну запись на диск.
+func (s *KVStorage) UpdateCounter(ctx context.Context, n string, v int64) error {
+	var total int64
+	err := s.db.Batch(func(tx *bolt.Tx) error {
+		total = incrementCounter(tx, n, v)
+		return tx.Bucket(counterBucket).Put([]byte(n), encodeCounter(total))
+	})
+	if err != nil {
+		return err
+	}
+	s.Record(ctx, "counter", n, float64(total))
+	return nil
+}
+
+func (s *KVStorage) UpdateGauge(ctx context.Context, n string, v float64) error {
+	err := s.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(gaugeBucket).Put([]byte(n), encodeGauge(v))
+	})
+	if err != nil {
+		return err
+	}
+	s.Record(ctx, "gauge", n, v)
+	return nil
+}
+
+func (s *KVStorage) RaiseCounter(ctx context.Context, n string, v int64) error {
+	if v < 0 {
+		return fmt.Errorf("%s: %w", n, storage.ErrNegativeCounter)
+	}
+	var raised bool
+	err := s.db.Batch(func(tx *bolt.Tx) error {
+		raised = false
+		if data := tx.Bucket(counterBucket).Get([]byte(n)); data != nil && decodeCounter(data) >= v {
+			return nil
+		}
+		raised = true
+		return tx.Bucket(counterBucket).Put([]byte(n), encodeCounter(v))
+	})
+	if err != nil {
+		return err
+	}
+	if raised {
+		s.Record(ctx, "counter", n, float64(v))
+	}
+	return nil
+}
+
+func (s *KVStorage) AddGauge(ctx context.Context, n string, v float64) error {
+	var value float64
+	err := s.db.Batch(func(tx *bolt.Tx) error {
+		value = v
+		if data := tx.Bucket(gaugeBucket).Get([]byte(n)); data != nil {
+			value += decodeGauge(data)
+		}
+		return tx.Bucket(gaugeBucket).Put([]byte(n), encodeGauge(value))
+	})
+	if err != nil {
+		return err
+	}
+	s.Record(ctx, "gauge", n, value)
+	return nil
+}
+
+func (s *KVStorage) GetCounterValue(ctx context.Context, id string) (int64, error) {
+	var v int64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(counterBucket).Get([]byte(id))
+		if data == nil {
+			return storage.ErrNotFound
+		}
+		v = decodeCounter(data)
+		return nil
+	})
+	return v, err
+}
+
+func (s *KVStorage) GetGaugeValue(ctx context.Context, id string) (float64, error) {
+	var v float64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(gaugeBucket).Get([]byte(id))
+		if data == nil {
+			return storage.ErrNotFound
+		}
+		v = decodeGauge(data)
+		return nil
+	})
+	return v, err
+}
+
+func (s *KVStorage) Counters(ctx context.Context) (map[string]int64, error) {
+	result := make(map[string]int64)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(counterBucket).ForEach(func(k, v []byte) error {
+			result[string(k)] = decodeCounter(v)
+			return nil
+		})
+	})
+	return result, err
+}
+
+func (s *KVStorage) Gauges(ctx context.Context) (map[string]float64, error) {
+	result := make(map[string]float64)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(gaugeBucket).ForEach(func(k, v []byte) error {
+			result[string(k)] = decodeGauge(v)
+			return nil
+		})
+	})
+	return result, err
+}
+
+// StoreBatch сохраняет пакет одной транзакцией: либо все метрики
+// пакета, либо ни одной.
+func (s *KVStorage) StoreBatch(ctx context.Context, metrics []models.Metrics) error {
+	if err := storage.ValidateBatch(metrics); err != nil {
+		return err
+	}
+
+	samples := make([]storage.Sample, 0, len(metrics))
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		samples = samples[:0]
+		for _, m := range metrics {
+			switch m.MType {
+			case "counter":
+				total := incrementCounter(tx, m.ID, *m.Delta)
+				if err := tx.Bucket(counterBucket).Put([]byte(m.ID), encodeCounter(total)); err != nil {
+					return err
+				}
+				samples = append(samples, storage.Sample{Name: m.ID, MType: m.MType, Value: float64(total)})
+			case "gauge":
+				if err := tx.Bucket(gaugeBucket).Put([]byte(m.ID), encodeGauge(*m.Value)); err != nil {
+					return err
+				}
+				samples = append(samples, storage.Sample{Name: m.ID, MType: m.MType, Value: *m.Value})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.RecordSamples(ctx, samples)
+	return nil
+}
+
+// incrementCounter возвращает значение счётчика n после прибавления v.
+func incrementCounter(tx *bolt.Tx, n string, v int64) int64 {
+	if data := tx.Bucket(counterBucket).Get([]byte(n)); data != nil {
+		return decodeCounter(data) + v
+	}
+	return v
+}
+
+func encodeCounter(v int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(v))
+}
+
+func decodeCounter(data []byte) int64 {
+	return int64(binary.BigEndian.Uint64(data))
+}
+
+func encodeGauge(v float64) []byte {
+	return binary.BigEndian.AppendUint64(nil, math.Float64bits(v))
+}
+
+func decodeGauge(data []byte) float64 {
+	return math.Float64frombits(binary.BigEndian.Uint64(data))
+}
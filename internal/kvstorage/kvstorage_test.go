@@ -0,0 +1,76 @@
+package kvstorage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/amidvn/go-metrics/internal/models"
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVStorage(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data", "metrics.db")
+
+	s, err := Open(path)
+	require.NoError(t, err)
+	require.NoError(t, s.UpdateCounter(ctx, "PollCount", 2))
+	require.NoError(t, s.UpdateCounter(ctx, "PollCount", 3))
+	require.NoError(t, s.UpdateGauge(ctx, "Alloc", 1.5))
+
+	delta, value := int64(5), 2.5
+	require.NoError(t, s.StoreBatch(ctx, []models.Metrics{
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "Alloc", MType: "gauge", Value: &value},
+	}))
+	// пакет с ошибкой не применяется целиком
+	assert.Error(t, s.StoreBatch(ctx, []models.Metrics{
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "Alloc", MType: "gauge"},
+	}))
+	require.NoError(t, s.Close())
+
+	s, err = Open(path)
+	require.NoError(t, err)
+	defer s.Close()
+
+	c, err := s.GetCounterValue(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), c)
+	g, err := s.GetGaugeValue(ctx, "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, 2.5, g)
+
+	require.NoError(t, s.RaiseCounter(ctx, "PollCount", 7))
+	require.NoError(t, s.RaiseCounter(ctx, "Requests", 7))
+	assert.ErrorIs(t, s.RaiseCounter(ctx, "Requests", -1), storage.ErrNegativeCounter)
+	c, err = s.GetCounterValue(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), c)
+	c, err = s.GetCounterValue(ctx, "Requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), c)
+	require.NoError(t, s.RaiseCounter(ctx, "Requests", 12))
+	c, err = s.GetCounterValue(ctx, "Requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(12), c)
+
+	require.NoError(t, s.AddGauge(ctx, "Alloc", 0.5))
+	require.NoError(t, s.AddGauge(ctx, "Inflight", -2))
+	g, err = s.GetGaugeValue(ctx, "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, g)
+
+	_, err = s.GetGaugeValue(ctx, "Missing")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	counters, err := s.Counters(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"PollCount": 10, "Requests": 12}, counters)
+	gauges, err := s.Gauges(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"Alloc": 3.0, "Inflight": -2}, gauges)
+}
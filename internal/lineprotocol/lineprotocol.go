@@ -0,0 +1,240 @@
+// Package lineprotocol разбирает строки InfluxDB line protocol:
+//
+//	measurement[,tag=value...] field=value[,field=value...] [timestamp]
+package lineprotocol
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type FieldType int
+
+const (
+	Float FieldType = iota
+	Integer
+	Unsigned
+	Boolean
+	String
+)
+
+type Field struct {
+	Type  FieldType
+	Float float64
+	Int   int64
+	Uint  uint64
+	Bool  bool
+	Str   string
+}
+
+// Number возвращает числовое значение поля; логические значения
+// превращаются в 1 и 0. Для строк ok == false.
+func (f Field) Number() (v float64, ok bool) {
+	switch f.Type {
+	case Float:
+		return f.Float, true
+	case Integer:
+		return float64(f.Int), true
+	case Unsigned:
+		return float64(f.Uint), true
+	case Boolean:
+		if f.Bool {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]Field
+	// Time — метка времени строки или момент приёма, если её нет
+	Time time.Time
+}
+
+// ParsePrecision разбирает параметр precision в вариантах InfluxDB 1.x
+// (n, u, ms, s, m, h) и 2.x (ns, us, ms, s). Пустая строка — наносекунды.
+func ParsePrecision(s string) (time.Duration, error) {
+	switch s {
+	case "", "n", "ns":
+		return time.Nanosecond, nil
+	case "u", "us", "µs":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	}
+	return 0, fmt.Errorf("invalid precision %q", s)
+}
+
+// IsBlank сообщает, что строку нужно пропустить: она пуста
+// или является комментарием.
+func IsBlank(line string) bool {
+	line = strings.TrimSpace(line)
+	return line == "" || strings.HasPrefix(line, "#")
+}
+
+// Parse разбирает одну строку. Метка времени умножается на precision;
+// при её отсутствии используется now.
+func Parse(line string, precision time.Duration, now time.Time) (Point, error) {
+	line = strings.TrimRight(line, "\r")
+	key, rest, err := cut(line, ' ', false)
+	if err != nil {
+		return Point{}, err
+	}
+	fields, ts, err := cut(rest, ' ', true)
+	if err != nil {
+		return Point{}, err
+	}
+
+	p := Point{Tags: make(map[string]string), Fields: make(map[string]Field), Time: now}
+
+	parts, err := split(key, ',', false)
+	if err != nil {
+		return Point{}, err
+	}
+	p.Measurement = unescape(parts[0])
+	if p.Measurement == "" {
+		return Point{}, errors.New("missing measurement")
+	}
+	for _, tag := range parts[1:] {
+		k, v, err := cut(tag, '=', false)
+		if err != nil || k == "" || v == "" {
+			return Point{}, fmt.Errorf("invalid tag %q", tag)
+		}
+		p.Tags[unescape(k)] = unescape(v)
+	}
+
+	if fields == "" {
+		return Point{}, errors.New("missing fields")
+	}
+	parts, err = split(fields, ',', true)
+	if err != nil {
+		return Point{}, err
+	}
+	for _, field := range parts {
+		k, v, err := cut(field, '=', false)
+		if err != nil || k == "" {
+			return Point{}, fmt.Errorf("invalid field %q", field)
+		}
+		f, err := parseValue(v)
+		if err != nil {
+			return Point{}, fmt.Errorf("field %s: %w", unescape(k), err)
+		}
+		p.Fields[unescape(k)] = f
+	}
+
+	if ts = strings.TrimSpace(ts); ts != "" {
+		n, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return Point{}, fmt.Errorf("invalid timestamp %q", ts)
+		}
+		// время в наносекундах должно поместиться в int64
+		if n > math.MaxInt64/int64(precision) || n < math.MinInt64/int64(precision) {
+			return Point{}, fmt.Errorf("timestamp %s is out of range", ts)
+		}
+		p.Time = time.Unix(0, n*int64(precision))
+	}
+	return p, nil
+}
+
+func parseValue(v string) (Field, error) {
+	switch {
+	case v == "":
+		return Field{}, errors.New("missing value")
+	case strings.HasPrefix(v, `"`):
+		if len(v) < 2 || !strings.HasSuffix(v, `"`) {
+			return Field{}, fmt.Errorf("unterminated string %s", v)
+		}
+		s := strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(v[1 : len(v)-1])
+		return Field{Type: String, Str: s}, nil
+	case strings.HasSuffix(v, "i"):
+		n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+		if err != nil {
+			return Field{}, fmt.Errorf("invalid integer %s", v)
+		}
+		return Field{Type: Integer, Int: n}, nil
+	case strings.HasSuffix(v, "u"):
+		n, err := strconv.ParseUint(v[:len(v)-1], 10, 64)
+		if err != nil {
+			return Field{}, fmt.Errorf("invalid unsigned integer %s", v)
+		}
+		return Field{Type: Unsigned, Uint: n}, nil
+	}
+
+	switch v {
+	case "t", "T", "true", "True", "TRUE":
+		return Field{Type: Boolean, Bool: true}, nil
+	case "f", "F", "false", "False", "FALSE":
+		return Field{Type: Boolean, Bool: false}, nil
+	}
+	// ParseFloat принимает NaN и Inf, которых в line protocol нет
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return Field{}, fmt.Errorf("invalid number %s", v)
+	}
+	return Field{Type: Float, Float: f}, nil
+}
+
+// cut делит s по первому неэкранированному разделителю sep. В quoted
+// режиме разделители внутри строк в кавычках не учитываются.
+func cut(s string, sep byte, quoted bool) (string, string, error) {
+	i, err := index(s, sep, quoted)
+	if err != nil {
+		return "", "", err
+	}
+	if i < 0 {
+		return s, "", nil
+	}
+	return s[:i], s[i+1:], nil
+}
+
+func split(s string, sep byte, quoted bool) ([]string, error) {
+	var result []string
+	for {
+		i, err := index(s, sep, quoted)
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 {
+			return append(result, s), nil
+		}
+		result = append(result, s[:i])
+		s = s[i+1:]
+	}
+}
+
+func index(s string, sep byte, quoted bool) (int, error) {
+	inQuotes := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case quoted && c == '"':
+			inQuotes = !inQuotes
+		case c == sep && !inQuotes:
+			return i, nil
+		}
+	}
+	if inQuotes {
+		return 0, errors.New("unterminated string")
+	}
+	return -1, nil
+}
+
+var unescaper = strings.NewReplacer(`\,`, `,`, `\ `, ` `, `\=`, `=`, `\\`, `\`)
+
+func unescape(s string) string {
+	return unescaper.Replace(s)
+}
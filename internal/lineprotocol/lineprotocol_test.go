@@ -0,0 +1,71 @@
+package lineprotocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Unix(100, 0)
+	testCases := []struct {
+		name      string
+		line      string
+		precision time.Duration
+		want      Point
+		wantErr   bool
+	}{
+		{name: "Parse() Test 1", line: "cpu,host=a,region=eu usage=0.5,count=3i,ok=t 1682935200000000000", precision: time.Nanosecond,
+			want: Point{Measurement: "cpu", Tags: map[string]string{"host": "a", "region": "eu"},
+				Fields: map[string]Field{"usage": {Type: Float, Float: 0.5}, "count": {Type: Integer, Int: 3}, "ok": {Type: Boolean, Bool: true}},
+				Time:   time.Unix(1682935200, 0)}},
+		{name: "Parse() Test 2", line: `disk\ io,path=/var\,log bytes=10u,msg="a \"b\", c d"`, precision: time.Second,
+			want: Point{Measurement: "disk io", Tags: map[string]string{"path": "/var,log"},
+				Fields: map[string]Field{"bytes": {Type: Unsigned, Uint: 10}, "msg": {Type: String, Str: `a "b", c d`}},
+				Time:   now}},
+		{name: "Parse() Test 3", line: "mem used=-1.5e3 1682935200", precision: time.Second,
+			want: Point{Measurement: "mem", Tags: map[string]string{}, Fields: map[string]Field{"used": {Type: Float, Float: -1500}},
+				Time: time.Unix(1682935200, 0)}},
+		{name: "Parse() Test 4", line: "mem", wantErr: true},
+		{name: "Parse() Test 5", line: "mem used=abc", wantErr: true},
+		{name: "Parse() Test 6", line: "mem,host used=1", wantErr: true},
+		{name: "Parse() Test 7", line: `mem msg="open`, wantErr: true},
+		{name: "Parse() Test 8", line: "mem used=1 soon", wantErr: true},
+		{name: "Parse() Test 9", line: ",host=a used=1", wantErr: true},
+		{name: "Parse() Test 10", line: "mem used=NaN", wantErr: true},
+		{name: "Parse() Test 11", line: "mem used=-Inf", wantErr: true},
+		{name: "Parse() Test 12", line: "mem used=1,free=Infinity", wantErr: true},
+		{name: "Parse() Test 13", line: "mem used=1e400", wantErr: true},
+		{name: "Parse() Test 14", line: "mem used=1 9300000000000000", precision: time.Millisecond, wantErr: true},
+		{name: "Parse() Test 15", line: "mem used=1 -9300000000000", precision: time.Second, wantErr: true},
+		{name: "Parse() Test 16", line: "mem used=1 9223372036", precision: time.Second,
+			want: Point{Measurement: "mem", Tags: map[string]string{}, Fields: map[string]Field{"used": {Type: Float, Float: 1}},
+				Time: time.Unix(9223372036, 0)}},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := Parse(test.line, test.precision, now)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want.Measurement, p.Measurement)
+			assert.Equal(t, test.want.Tags, p.Tags)
+			assert.Equal(t, test.want.Fields, p.Fields)
+			assert.True(t, test.want.Time.Equal(p.Time))
+		})
+	}
+}
+
+func TestParsePrecision(t *testing.T) {
+	for s, want := range map[string]time.Duration{"": time.Nanosecond, "u": time.Microsecond, "us": time.Microsecond, "ms": time.Millisecond, "s": time.Second, "h": time.Hour} {
+		got, err := ParsePrecision(s)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	_, err := ParsePrecision("d")
+	assert.Error(t, err)
+}
@@ -0,0 +1,248 @@
+// Package listing отбирает, упорядочивает и разбивает на страницы
+// серии хранилища для API списка метрик.
+package listing
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/amidvn/go-metrics/internal/series"
+)
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// ErrInvalidCursor возвращается, если курсор повреждён или получен
+// с другим порядком сортировки.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Item — серия в списке.
+type Item struct {
+	Key   string
+	Name  string
+	Type  string
+	Value float64
+	// Labels — метки серии в порядке имён
+	Labels []series.Label
+}
+
+// Query — условия отбора и порядок списка.
+type Query struct {
+	// Type — gauge или counter; пустой — оба типа
+	Type string
+	// Name — регулярное выражение для имени метрики
+	Name *regexp.Regexp
+	// Matchers — условия на метки, должны выполняться все
+	Matchers []series.Matcher
+	// Sort — name, type или value; с '-' в начале — по убыванию
+	Sort   string
+	Limit  int
+	Cursor string
+}
+
+// Page — страница списка.
+type Page struct {
+	Items []Item
+	// Total — число серий, подходящих под условия
+	Total int
+	// Next — курсор следующей страницы; пустой на последней
+	Next string
+}
+
+// Glob превращает шаблон с * и ? в регулярное выражение для Query.Name.
+func Glob(pattern string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
+
+// Validate проверяет порядок сортировки и ограничение размера страницы.
+func (q *Query) Validate() error {
+	switch strings.TrimPrefix(q.Sort, "-") {
+	case "":
+		q.Sort = "name"
+	case "name", "type", "value":
+	default:
+		return fmt.Errorf("invalid sort %q, can be name, type or value", q.Sort)
+	}
+	switch q.Type {
+	case "", "gauge", "counter":
+	default:
+		return fmt.Errorf("invalid type %q, can only be 'gauge' or 'counter'", q.Type)
+	}
+	if q.Limit == 0 {
+		q.Limit = DefaultLimit
+	}
+	if q.Limit < 0 || q.Limit > MaxLimit {
+		return fmt.Errorf("limit must be between 1 and %d", MaxLimit)
+	}
+	return nil
+}
+
+// cursor — позиция последней серии страницы. Значение записывается
+// строкой, чтобы курсор можно было построить и для NaN и бесконечностей.
+type cursor struct {
+	Sort  string `json:"s"`
+	Name  string `json:"n"`
+	Key   string `json:"k"`
+	Type  string `json:"t"`
+	Value string `json:"v,omitempty"`
+}
+
+// List отбирает серии по условиям запроса и возвращает страницу,
+// следующую за курсором. Query должен пройти Validate.
+func List(counters map[string]int64, gauges map[string]float64, q Query) (Page, error) {
+	var after *cursor
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil || c.Sort != q.Sort {
+			return Page{}, ErrInvalidCursor
+		}
+		after = &c
+	}
+	var afterValue float64
+	if after != nil && after.Value != "" {
+		v, err := strconv.ParseFloat(after.Value, 64)
+		if err != nil {
+			return Page{}, ErrInvalidCursor
+		}
+		afterValue = v
+	}
+
+	var items []Item
+	add := func(mtype, key string, v float64) {
+		if q.Type != "" && q.Type != mtype {
+			return
+		}
+		k, err := series.Parse(key)
+		if err != nil {
+			k = series.Key{Name: key}
+		}
+		if q.Name != nil && !q.Name.MatchString(k.Name) {
+			return
+		}
+		for _, m := range q.Matchers {
+			if !m.Matches(k) {
+				return
+			}
+		}
+		items = append(items, Item{Key: key, Name: k.Name, Type: mtype, Value: v, Labels: k.Labels})
+	}
+	for key, v := range counters {
+		add("counter", key, float64(v))
+	}
+	for key, v := range gauges {
+		add("gauge", key, v)
+	}
+
+	less := lessFunc(q.Sort)
+	sort.Slice(items, func(i, j int) bool { return less(items[i], items[j]) })
+
+	page := Page{Total: len(items)}
+	start := 0
+	if after != nil {
+		last := Item{Name: after.Name, Key: after.Key, Type: after.Type, Value: afterValue}
+		start = sort.Search(len(items), func(i int) bool { return less(last, items[i]) })
+	}
+	end := start + q.Limit
+	if end >= len(items) {
+		end = len(items)
+	} else {
+		last := items[end-1]
+		page.Next = encodeCursor(cursor{
+			Sort: q.Sort, Name: last.Name, Key: last.Key, Type: last.Type,
+			Value: strconv.FormatFloat(last.Value, 'g', -1, 64),
+		})
+	}
+	page.Items = items[start:end]
+	return page, nil
+}
+
+// lessFunc возвращает порядок серий. При равенстве основного ключа
+// серии упорядочиваются по ключу и типу, поэтому порядок полный
+// и курсор однозначно задаёт позицию.
+func lessFunc(sortBy string) func(a, b Item) bool {
+	desc := strings.HasPrefix(sortBy, "-")
+	tie := func(a, b Item) bool {
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		return a.Type < b.Type
+	}
+	var primary func(a, b Item) int
+	switch strings.TrimPrefix(sortBy, "-") {
+	case "value":
+		primary = func(a, b Item) int { return compare(a.Value, b.Value) }
+	case "type":
+		primary = func(a, b Item) int { return strings.Compare(a.Type, b.Type) }
+	default:
+		primary = func(a, b Item) int { return strings.Compare(a.Name, b.Name) }
+	}
+	return func(a, b Item) bool {
+		c := primary(a, b)
+		if c == 0 {
+			if desc {
+				return tie(b, a)
+			}
+			return tie(a, b)
+		}
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	}
+}
+
+// compare упорядочивает значения; NaN меньше любого числа.
+func compare(a, b float64) int {
+	switch {
+	case math.IsNaN(a) && math.IsNaN(b):
+		return 0
+	case math.IsNaN(a):
+		return -1
+	case math.IsNaN(b):
+		return 1
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// encodeCursor кодирует курсор. Поля курсора — строки, поэтому
+// json.Marshal не может вернуть ошибку.
+func encodeCursor(c cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, err
+	}
+	var c cursor
+	err = json.Unmarshal(b, &c)
+	return c, err
+}
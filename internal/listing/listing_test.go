@@ -0,0 +1,131 @@
+package listing
+
+import (
+	"math"
+	"testing"
+
+	"github.com/amidvn/go-metrics/internal/series"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testCounters = map[string]int64{
+		`http_requests{host="web1"}`: 10,
+		`http_requests{host="web2"}`: 30,
+		"PollCount":                  5,
+	}
+	testGauges = map[string]float64{
+		"Alloc":                     100,
+		`cpu{host="web1"}`:          0.5,
+		`http_requests_rate{a="b"}`: 2,
+	}
+)
+
+func keys(p Page) []string {
+	var result []string
+	for _, item := range p.Items {
+		result = append(result, item.Key)
+	}
+	return result
+}
+
+func TestList(t *testing.T) {
+	host, err := series.ParseMatcher(`host=~"web.*"`)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{name: "List() Test 1", query: Query{}, want: []string{"Alloc", "PollCount", `cpu{host="web1"}`, `http_requests{host="web1"}`, `http_requests{host="web2"}`, `http_requests_rate{a="b"}`}},
+		{name: "List() Test 2", query: Query{Type: "counter", Sort: "-value"}, want: []string{`http_requests{host="web2"}`, `http_requests{host="web1"}`, "PollCount"}},
+		{name: "List() Test 3", query: Query{Name: Glob("http_*")}, want: []string{`http_requests{host="web1"}`, `http_requests{host="web2"}`, `http_requests_rate{a="b"}`}},
+		{name: "List() Test 4", query: Query{Matchers: []series.Matcher{host}, Sort: "type"}, want: []string{`http_requests{host="web1"}`, `http_requests{host="web2"}`, `cpu{host="web1"}`}},
+		{name: "List() Test 5", query: Query{Name: Glob("?lloc")}, want: []string{"Alloc"}},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			q := test.query
+			require.NoError(t, q.Validate())
+			page, err := List(testCounters, testGauges, q)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, keys(page))
+			assert.Equal(t, len(test.want), page.Total)
+			assert.Empty(t, page.Next)
+		})
+	}
+}
+
+func TestListPagination(t *testing.T) {
+	for _, sortBy := range []string{"name", "-name", "value", "-value", "type"} {
+		q := Query{Sort: sortBy}
+		require.NoError(t, q.Validate())
+		all, err := List(testCounters, testGauges, q)
+		require.NoError(t, err)
+
+		q.Limit = 4
+		var paged []string
+		for {
+			page, err := List(testCounters, testGauges, q)
+			require.NoError(t, err)
+			assert.Equal(t, 6, page.Total)
+			paged = append(paged, keys(page)...)
+			if page.Next == "" {
+				break
+			}
+			q.Cursor = page.Next
+		}
+		assert.Equal(t, keys(all), paged, sortBy)
+
+		q.Sort = "type"
+		if sortBy != "type" {
+			_, err = List(testCounters, testGauges, q)
+			assert.ErrorIs(t, err, ErrInvalidCursor)
+		}
+	}
+
+	_, err := List(testCounters, testGauges, Query{Sort: "name", Limit: 1, Cursor: "%%%"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestListPaginationNonFinite(t *testing.T) {
+	gauges := map[string]float64{
+		"a":    math.NaN(),
+		"b":    math.NaN(),
+		"low":  math.Inf(-1),
+		"high": math.Inf(1),
+		"zero": 0,
+	}
+	testCases := []struct {
+		name string
+		sort string
+		want []string
+	}{
+		{name: "List() Test 1", sort: "value", want: []string{"a", "b", "low", "zero", "high"}},
+		{name: "List() Test 2", sort: "-value", want: []string{"high", "zero", "low", "b", "a"}},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			q := Query{Sort: test.sort, Limit: 1}
+			var paged []string
+			for {
+				page, err := List(nil, gauges, q)
+				require.NoError(t, err)
+				paged = append(paged, keys(page)...)
+				if page.Next == "" {
+					break
+				}
+				q.Cursor = page.Next
+			}
+			assert.Equal(t, test.want, paged)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, q := range []Query{{Sort: "size"}, {Type: "histogram"}, {Limit: MaxLimit + 1}, {Limit: -1}} {
+		assert.Error(t, q.Validate())
+	}
+}
@@ -0,0 +1,226 @@
+package otlp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Структуры OTLP/JSON: имена полей в lowerCamelCase, 64-битные целые
+// передаются строками или числами.
+
+type jsonRequest struct {
+	ResourceMetrics []struct {
+		Resource struct {
+			Attributes []jsonKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeMetrics []struct {
+			Metrics []jsonMetric `json:"metrics"`
+		} `json:"scopeMetrics"`
+	} `json:"resourceMetrics"`
+}
+
+type jsonMetric struct {
+	Name  string `json:"name"`
+	Unit  string `json:"unit"`
+	Gauge *struct {
+		DataPoints []jsonNumberPoint `json:"dataPoints"`
+	} `json:"gauge"`
+	Sum *struct {
+		DataPoints             []jsonNumberPoint `json:"dataPoints"`
+		AggregationTemporality Temporality       `json:"aggregationTemporality"`
+		IsMonotonic            bool              `json:"isMonotonic"`
+	} `json:"sum"`
+	Histogram *struct {
+		DataPoints             []jsonHistogramPoint `json:"dataPoints"`
+		AggregationTemporality Temporality          `json:"aggregationTemporality"`
+	} `json:"histogram"`
+	ExponentialHistogram *jsonUnsupported `json:"exponentialHistogram"`
+	Summary              *jsonUnsupported `json:"summary"`
+}
+
+type jsonUnsupported struct {
+	DataPoints []json.RawMessage `json:"dataPoints"`
+}
+
+type jsonNumberPoint struct {
+	Attributes        []jsonKeyValue `json:"attributes"`
+	StartTimeUnixNano jsonInt        `json:"startTimeUnixNano"`
+	TimeUnixNano      jsonInt        `json:"timeUnixNano"`
+	AsDouble          *float64       `json:"asDouble"`
+	AsInt             *jsonInt       `json:"asInt"`
+}
+
+type jsonHistogramPoint struct {
+	Attributes        []jsonKeyValue `json:"attributes"`
+	StartTimeUnixNano jsonInt        `json:"startTimeUnixNano"`
+	TimeUnixNano      jsonInt        `json:"timeUnixNano"`
+	Count             jsonInt        `json:"count"`
+	Sum               float64        `json:"sum"`
+	BucketCounts      []jsonInt      `json:"bucketCounts"`
+	ExplicitBounds    []float64      `json:"explicitBounds"`
+}
+
+type jsonKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue *string  `json:"stringValue"`
+		BoolValue   *bool    `json:"boolValue"`
+		IntValue    *jsonInt `json:"intValue"`
+		DoubleValue *float64 `json:"doubleValue"`
+	} `json:"value"`
+}
+
+// jsonInt — 64-битное целое, записанное строкой или числом.
+type jsonInt uint64
+
+func (i *jsonInt) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		*i = 0
+		return nil
+	}
+	if strings.HasPrefix(s, "-") {
+		n, err := strconv.ParseInt(s, 10, 64)
+		*i = jsonInt(n)
+		return err
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	*i = jsonInt(n)
+	return err
+}
+
+// UnmarshalJSON принимает temporality числом или именем значения
+// перечисления.
+func (t *Temporality) UnmarshalJSON(b []byte) error {
+	switch strings.Trim(string(b), `"`) {
+	case "0", "AGGREGATION_TEMPORALITY_UNSPECIFIED":
+		*t = TemporalityUnspecified
+	case "1", "AGGREGATION_TEMPORALITY_DELTA":
+		*t = Delta
+	case "2", "AGGREGATION_TEMPORALITY_CUMULATIVE":
+		*t = Cumulative
+	default:
+		return fmt.Errorf("invalid aggregation temporality %s", b)
+	}
+	return nil
+}
+
+// UnmarshalJSON разбирает запрос в кодировке OTLP/JSON.
+func UnmarshalJSON(data []byte) (Request, error) {
+	var jr jsonRequest
+	if err := json.Unmarshal(data, &jr); err != nil {
+		return Request{}, err
+	}
+
+	var req Request
+	for _, jrm := range jr.ResourceMetrics {
+		rm := ResourceMetrics{Attributes: jsonAttributes(jrm.Resource.Attributes)}
+		for _, sm := range jrm.ScopeMetrics {
+			for _, jm := range sm.Metrics {
+				rm.Metrics = append(rm.Metrics, jm.metric())
+			}
+		}
+		req.ResourceMetrics = append(req.ResourceMetrics, rm)
+	}
+	return req, nil
+}
+
+func (jm jsonMetric) metric() Metric {
+	m := Metric{Name: jm.Name, Unit: jm.Unit}
+	switch {
+	case jm.Gauge != nil:
+		m.Kind = Gauge
+		m.Points = jsonNumberPoints(jm.Gauge.DataPoints)
+	case jm.Sum != nil:
+		m.Kind = Sum
+		m.Temporality = jm.Sum.AggregationTemporality
+		m.Monotonic = jm.Sum.IsMonotonic
+		m.Points = jsonNumberPoints(jm.Sum.DataPoints)
+	case jm.Histogram != nil:
+		m.Kind = Histogram
+		m.Temporality = jm.Histogram.AggregationTemporality
+		for _, jp := range jm.Histogram.DataPoints {
+			h := HistogramPoint{
+				Attributes:     jsonAttributes(jp.Attributes),
+				StartTime:      uint64(jp.StartTimeUnixNano),
+				Time:           uint64(jp.TimeUnixNano),
+				Count:          uint64(jp.Count),
+				Sum:            jp.Sum,
+				ExplicitBounds: jp.ExplicitBounds,
+			}
+			for _, c := range jp.BucketCounts {
+				h.BucketCounts = append(h.BucketCounts, uint64(c))
+			}
+			m.Histograms = append(m.Histograms, h)
+		}
+	case jm.ExponentialHistogram != nil:
+		m.Unsupported = len(jm.ExponentialHistogram.DataPoints)
+	case jm.Summary != nil:
+		m.Unsupported = len(jm.Summary.DataPoints)
+	}
+	return m
+}
+
+func jsonNumberPoints(jps []jsonNumberPoint) []NumberPoint {
+	points := make([]NumberPoint, 0, len(jps))
+	for _, jp := range jps {
+		p := NumberPoint{
+			Attributes: jsonAttributes(jp.Attributes),
+			StartTime:  uint64(jp.StartTimeUnixNano),
+			Time:       uint64(jp.TimeUnixNano),
+		}
+		switch {
+		case jp.AsInt != nil:
+			p.Int = int64(*jp.AsInt)
+			p.Value, p.IsInt = float64(p.Int), true
+		case jp.AsDouble != nil:
+			p.Value = *jp.AsDouble
+		default:
+			p.NoValue = true
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+func jsonAttributes(kvs []jsonKeyValue) map[string]string {
+	attrs := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		var value any
+		switch v := kv.Value; {
+		case v.StringValue != nil:
+			value = *v.StringValue
+		case v.BoolValue != nil:
+			value = *v.BoolValue
+		case v.IntValue != nil:
+			value = int64(*v.IntValue)
+		case v.DoubleValue != nil:
+			value = *v.DoubleValue
+		}
+		if s, ok := formatAttribute(value); ok && kv.Key != "" {
+			attrs[kv.Key] = s
+		}
+	}
+	return attrs
+}
+
+type jsonResponse struct {
+	PartialSuccess *jsonPartialSuccess `json:"partialSuccess,omitempty"`
+}
+
+type jsonPartialSuccess struct {
+	RejectedDataPoints string `json:"rejectedDataPoints"`
+	ErrorMessage       string `json:"errorMessage"`
+}
+
+// MarshalResponseJSON сериализует ExportMetricsServiceResponse в OTLP/JSON.
+func MarshalResponseJSON(rejected int, message string) []byte {
+	var resp jsonResponse
+	if rejected != 0 {
+		resp.PartialSuccess = &jsonPartialSuccess{RejectedDataPoints: strconv.Itoa(rejected), ErrorMessage: message}
+	}
+	b, _ := json.Marshal(resp)
+	return b
+}
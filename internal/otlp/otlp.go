@@ -0,0 +1,330 @@
+// Package otlp принимает метрики OpenTelemetry, переданные по OTLP/HTTP
+// в кодировке protobuf или JSON. Как и в remotewrite, сообщения
+// разбираются вручную: из схемы нужны только метрики.
+package otlp
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/exposition"
+	"github.com/amidvn/go-metrics/internal/models"
+	"github.com/amidvn/go-metrics/internal/series"
+	"github.com/amidvn/go-metrics/internal/storage"
+)
+
+// Temporality — как накапливаются значения сумм и гистограмм.
+type Temporality int32
+
+const (
+	TemporalityUnspecified Temporality = iota
+	// Delta — точка содержит изменение с предыдущей точки
+	Delta
+	// Cumulative — точка содержит значение с момента StartTime
+	Cumulative
+)
+
+type Kind int
+
+const (
+	// Unsupported — экспоненциальные гистограммы и summary
+	Unsupported Kind = iota
+	Gauge
+	Sum
+	Histogram
+)
+
+type Request struct {
+	ResourceMetrics []ResourceMetrics
+}
+
+// ResourceMetrics — метрики одного ресурса. Группировка по
+// instrumentation scope не сохраняется.
+type ResourceMetrics struct {
+	Attributes map[string]string
+	Metrics    []Metric
+}
+
+type Metric struct {
+	Name        string
+	Unit        string
+	Kind        Kind
+	Temporality Temporality
+	Monotonic   bool
+	Points      []NumberPoint
+	Histograms  []HistogramPoint
+	// Unsupported — число точек метрики неподдерживаемого типа
+	Unsupported int
+}
+
+type NumberPoint struct {
+	Attributes map[string]string
+	// StartTime — начало накопления, наносекунды Unix; 0, если неизвестно
+	StartTime uint64
+	// Time — наносекунды Unix
+	Time uint64
+	// Value — значение точки, в том числе целое
+	Value float64
+	Int   int64
+	IsInt bool
+	// NoValue — в точке нет ни целого, ни дробного значения
+	NoValue bool
+}
+
+type HistogramPoint struct {
+	Attributes map[string]string
+	StartTime  uint64
+	Time       uint64
+	Count      uint64
+	Sum        float64
+	// BucketCounts — число значений в каждой корзине; корзин на одну
+	// больше, чем границ
+	BucketCounts   []uint64
+	ExplicitBounds []float64
+}
+
+type timedInt struct {
+	value int64
+	start uint64
+	time  uint64
+}
+
+type timedFloat struct {
+	value float64
+	time  uint64
+}
+
+// updates — значения запроса, сгруппированные по ключам серий.
+type updates struct {
+	// накопительные значения счётчиков, последние по времени
+	counters      map[string]timedInt
+	counterDeltas map[string]int64
+	gauges        map[string]timedFloat
+	gaugeDeltas   map[string]float64
+	rejected      int
+}
+
+// сколько Tracker помнит серию, для которой не приходило точек. Точка
+// забытой серии принимается как первая: счётчик поднимется до неё,
+// когда значение источника превысит хранимое.
+const trackerTTL = time.Hour
+
+// Tracker запоминает накопительные счётчики источников между запросами,
+// чтобы отличать перезапуск источника от точки, пришедшей не по порядку.
+type Tracker struct {
+	mu     sync.Mutex
+	series map[string]cumulative
+	// время последней очистки от забытых серий
+	pruned time.Time
+}
+
+type cumulative struct {
+	start uint64
+	last  int64
+	// offset — сумма последних значений до перезапусков источника
+	offset int64
+	// updated — время последней принятой точки
+	updated time.Time
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{series: make(map[string]cumulative)}
+}
+
+// prune удаляет серии, для которых дольше trackerTTL не было точек.
+// Вызывается под t.mu.
+func (t *Tracker) prune(now time.Time) {
+	for key, c := range t.series {
+		if now.Sub(c.updated) > trackerTTL {
+			delete(t.series, key)
+		}
+	}
+	t.pruned = now
+}
+
+// advance учитывает накопительную точку и возвращает значение, до
+// которого нужно поднять счётчик. Перезапуск источника определяется
+// по более позднему времени начала: значения нового запуска
+// прибавляются к накопленному до него. Точки прежнего запуска и точки
+// меньше последней в том же запуске пропускаются.
+func (t *Tracker) advance(key string, p timedInt, now time.Time) (int64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.Sub(t.pruned) >= trackerTTL/4 {
+		t.prune(now)
+	}
+	c := t.series[key]
+	if p.start != 0 && c.start != 0 && p.start != c.start {
+		if p.start < c.start {
+			return 0, false
+		}
+		c = cumulative{start: p.start, offset: c.offset + c.last}
+	}
+	if p.value < c.last {
+		return 0, false
+	}
+	if c.start == 0 {
+		c.start = p.start
+	}
+	c.last = p.value
+	c.updated = now
+	t.series[key] = c
+	return c.offset + c.last, true
+}
+
+// Store сохраняет запрос в хранилище и возвращает число отклонённых
+// точек.
+//
+// Атрибуты ресурса и точки становятся метками серии, атрибуты точки
+// важнее. Gauge сохраняется как gauge. Монотонная сумма с целыми
+// значениями сохраняется как счётчик, остальные суммы — как gauge.
+// Гистограмма сохраняется в виде Prometheus: счётчики name_count и
+// name_bucket{le="..."} с накопленным по корзинам числом значений
+// и gauge name_sum.
+//
+// Изменения прибавляются к хранимым значениям атомарно. Накопительный
+// счётчик поднимается до значения источника с учётом его перезапусков,
+// которые отслеживает t; после перезапуска сервера счётчик растёт,
+// когда значение источника превысит хранимое.
+func (r Request) Store(ctx context.Context, s storage.Storage, t *Tracker) (int, error) {
+	u := updates{
+		counters:      make(map[string]timedInt),
+		counterDeltas: make(map[string]int64),
+		gauges:        make(map[string]timedFloat),
+		gaugeDeltas:   make(map[string]float64),
+	}
+	for _, rm := range r.ResourceMetrics {
+		for _, m := range rm.Metrics {
+			u.add(rm.Attributes, m)
+		}
+	}
+
+	metrics := make([]models.Metrics, 0, len(u.counterDeltas)+len(u.gauges))
+	for key, delta := range u.counterDeltas {
+		delta := delta
+		metrics = append(metrics, models.Metrics{ID: key, MType: "counter", Delta: &delta})
+	}
+	for key, tv := range u.gauges {
+		v := tv.value
+		metrics = append(metrics, models.Metrics{ID: key, MType: "gauge", Value: &v})
+	}
+	if len(metrics) != 0 {
+		if err := s.StoreBatch(ctx, metrics); err != nil {
+			return 0, err
+		}
+	}
+	for key, delta := range u.gaugeDeltas {
+		if err := s.AddGauge(ctx, key, delta); err != nil {
+			return 0, err
+		}
+	}
+	now := time.Now()
+	for key, tv := range u.counters {
+		v, ok := t.advance(key, tv, now)
+		if !ok {
+			continue
+		}
+		if err := s.RaiseCounter(ctx, key, v); err != nil {
+			return 0, err
+		}
+	}
+	return u.rejected, nil
+}
+
+func (u *updates) add(resource map[string]string, m Metric) {
+	u.rejected += m.Unsupported
+	if m.Name == "" {
+		u.rejected += len(m.Points) + len(m.Histograms)
+		return
+	}
+	name := exposition.MetricName(m.Name)
+	delta := m.Temporality == Delta
+
+	for _, p := range m.Points {
+		if p.NoValue || math.IsNaN(p.Value) || math.IsInf(p.Value, 0) {
+			u.rejected++
+			continue
+		}
+		key := seriesKey(name, resource, p.Attributes, nil)
+		switch {
+		case m.Kind == Sum && m.Monotonic && p.IsInt:
+			u.addCounter(key, timedInt{value: p.Int, start: p.StartTime, time: p.Time}, delta)
+		case m.Kind == Sum:
+			u.addGauge(key, p.Value, p.Time, delta)
+		default:
+			u.addGauge(key, p.Value, p.Time, false)
+		}
+	}
+
+	for _, h := range m.Histograms {
+		if len(h.BucketCounts) != 0 && len(h.BucketCounts) != len(h.ExplicitBounds)+1 ||
+			math.IsNaN(h.Sum) || math.IsInf(h.Sum, 0) {
+			u.rejected++
+			continue
+		}
+		u.addCounter(seriesKey(name+"_count", resource, h.Attributes, nil), timedInt{value: int64(h.Count), start: h.StartTime, time: h.Time}, delta)
+		u.addGauge(seriesKey(name+"_sum", resource, h.Attributes, nil), h.Sum, h.Time, delta)
+
+		var cumulative uint64
+		for i, c := range h.BucketCounts {
+			cumulative += c
+			le := "+Inf"
+			if i < len(h.ExplicitBounds) {
+				le = exposition.FormatValue(h.ExplicitBounds[i])
+			}
+			key := seriesKey(name+"_bucket", resource, h.Attributes, map[string]string{"le": le})
+			u.addCounter(key, timedInt{value: int64(cumulative), start: h.StartTime, time: h.Time}, delta)
+		}
+	}
+}
+
+func (u *updates) addCounter(key string, p timedInt, delta bool) {
+	if delta {
+		u.counterDeltas[key] += p.value
+		return
+	}
+	if prev, ok := u.counters[key]; !ok || p.time >= prev.time {
+		u.counters[key] = p
+	}
+}
+
+func (u *updates) addGauge(key string, v float64, t uint64, delta bool) {
+	if delta {
+		u.gaugeDeltas[key] += v
+		return
+	}
+	if prev, ok := u.gauges[key]; !ok || t >= prev.time {
+		u.gauges[key] = timedFloat{value: v, time: t}
+	}
+}
+
+// seriesKey собирает ключ серии из имени и атрибутов; имена атрибутов
+// приводятся к виду, допустимому для меток.
+func seriesKey(name string, attrs ...map[string]string) string {
+	labels := make(map[string]string)
+	for _, a := range attrs {
+		for k, v := range a {
+			labels[exposition.LabelName(k)] = v
+		}
+	}
+	return series.New(name, labels).String()
+}
+
+// formatAttribute возвращает значение атрибута в виде строки.
+func formatAttribute(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), true
+	}
+	return "", false
+}
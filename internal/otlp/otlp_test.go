@@ -0,0 +1,182 @@
+package otlp
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testRequest = Request{ResourceMetrics: []ResourceMetrics{{
+	Attributes: map[string]string{"service.name": "shop"},
+	Metrics: []Metric{
+		{Name: "http.requests", Kind: Sum, Temporality: Cumulative, Monotonic: true, Points: []NumberPoint{
+			{Attributes: map[string]string{"method": "GET"}, StartTime: 1, Time: 1, Value: 10, Int: 10, IsInt: true},
+			{Attributes: map[string]string{"method": "GET"}, StartTime: 1, Time: 2, Value: 15, Int: 15, IsInt: true},
+		}},
+		{Name: "queue.size", Kind: Gauge, Points: []NumberPoint{
+			{Attributes: map[string]string{}, Time: 1, Value: 2.5},
+		}},
+		{Name: "latency", Kind: Histogram, Temporality: Cumulative, Histograms: []HistogramPoint{
+			{Attributes: map[string]string{}, Time: 1, Count: 6, Sum: 1.5, BucketCounts: []uint64{1, 3, 2}, ExplicitBounds: []float64{0.1, 0.5}},
+		}},
+		{Name: "errors", Kind: Sum, Temporality: Delta, Monotonic: true, Points: []NumberPoint{
+			{Attributes: map[string]string{}, Time: 1, Value: 2, Int: 2, IsInt: true},
+			{Attributes: map[string]string{}, Time: 2, Value: 3, Int: 3, IsInt: true},
+		}},
+	},
+}}}
+
+func TestUnmarshal(t *testing.T) {
+	req, err := Unmarshal(Marshal(testRequest))
+	require.NoError(t, err)
+	assert.Equal(t, testRequest, req)
+
+	_, err = Unmarshal([]byte{0x0a, 0x05, 0x01})
+	assert.Error(t, err)
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	body := `{"resourceMetrics":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"shop"}},{"key":"pid","value":{"intValue":"42"}}]},
+		"scopeMetrics":[{"scope":{"name":"app"},"metrics":[
+			{"name":"http.requests","sum":{"aggregationTemporality":2,"isMonotonic":true,"dataPoints":[{"attributes":[{"key":"method","value":{"stringValue":"GET"}}],"startTimeUnixNano":"1","timeUnixNano":"1","asInt":"10"}]}},
+			{"name":"queue.size","gauge":{"dataPoints":[{"timeUnixNano":"1","asDouble":2.5},{"timeUnixNano":"2"}]}},
+			{"name":"latency","histogram":{"aggregationTemporality":"AGGREGATION_TEMPORALITY_DELTA","dataPoints":[{"count":"6","sum":1.5,"bucketCounts":["1","3",2],"explicitBounds":[0.1,0.5]}]}},
+			{"name":"rpc","summary":{"dataPoints":[{},{}]}}
+		]}]}]}`
+	req, err := UnmarshalJSON([]byte(body))
+	require.NoError(t, err)
+	require.Len(t, req.ResourceMetrics, 1)
+	rm := req.ResourceMetrics[0]
+	assert.Equal(t, map[string]string{"service.name": "shop", "pid": "42"}, rm.Attributes)
+	require.Len(t, rm.Metrics, 4)
+
+	assert.Equal(t, Cumulative, rm.Metrics[0].Temporality)
+	assert.Equal(t, []NumberPoint{{Attributes: map[string]string{"method": "GET"}, StartTime: 1, Time: 1, Value: 10, Int: 10, IsInt: true}}, rm.Metrics[0].Points)
+	assert.True(t, rm.Metrics[1].Points[1].NoValue)
+	assert.Equal(t, Delta, rm.Metrics[2].Temporality)
+	assert.Equal(t, []uint64{1, 3, 2}, rm.Metrics[2].Histograms[0].BucketCounts)
+	assert.Equal(t, 2, rm.Metrics[3].Unsupported)
+
+	_, err = UnmarshalJSON([]byte(`{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"sum":{"aggregationTemporality":7}}]}]}]}`))
+	assert.Error(t, err)
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	s := storage.New(0, "", false)
+	require.NoError(t, s.UpdateCounter(ctx, `http_requests{method="GET",service_name="shop"}`, 4))
+	require.NoError(t, s.UpdateCounter(ctx, `latency_count{service_name="shop"}`, 100))
+	tracker := NewTracker()
+
+	req := testRequest
+	req.ResourceMetrics = append(req.ResourceMetrics, ResourceMetrics{Metrics: []Metric{
+		{Name: "", Kind: Gauge, Points: []NumberPoint{{Value: 1}}},
+		{Name: "rpc", Unsupported: 2},
+	}})
+	rejected, err := req.Store(ctx, s, tracker)
+	require.NoError(t, err)
+	assert.Equal(t, 3, rejected)
+
+	counters, err := s.Counters(ctx)
+	require.NoError(t, err)
+	gauges, err := s.Gauges(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(15), counters[`http_requests{method="GET",service_name="shop"}`])
+	assert.Equal(t, int64(5), counters[`errors{service_name="shop"}`])
+	// хранимый счётчик больше накопленного: значение уже учтено
+	assert.Equal(t, int64(100), counters[`latency_count{service_name="shop"}`])
+	assert.Equal(t, int64(1), counters[`latency_bucket{le="0.1",service_name="shop"}`])
+	assert.Equal(t, int64(4), counters[`latency_bucket{le="0.5",service_name="shop"}`])
+	assert.Equal(t, int64(6), counters[`latency_bucket{le="+Inf",service_name="shop"}`])
+	assert.Equal(t, 1.5, gauges[`latency_sum{service_name="shop"}`])
+	assert.Equal(t, 2.5, gauges[`queue_size{service_name="shop"}`])
+
+	point := func(start, time uint64, v int64) Request {
+		return Request{ResourceMetrics: []ResourceMetrics{{Metrics: []Metric{
+			{Name: "jobs", Kind: Sum, Temporality: Cumulative, Monotonic: true, Points: []NumberPoint{
+				{StartTime: start, Time: time, Value: float64(v), Int: v, IsInt: true},
+			}},
+			{Name: "inflight", Kind: Sum, Temporality: Delta, Points: []NumberPoint{
+				{Time: time, Value: 1.5},
+			}},
+		}}}}
+	}
+	testCases := []struct {
+		name     string
+		req      Request
+		jobs     int64
+		inflight float64
+	}{
+		{name: "Store() Test 1", req: point(10, 11, 7), jobs: 7, inflight: 1.5},
+		{name: "Store() Test 2", req: point(10, 12, 9), jobs: 9, inflight: 3},
+		// точка не по порядку не считается перезапуском
+		{name: "Store() Test 3", req: point(10, 11, 7), jobs: 9, inflight: 4.5},
+		// новый запуск источника продолжает счётчик
+		{name: "Store() Test 4", req: point(20, 21, 2), jobs: 11, inflight: 6},
+		{name: "Store() Test 5", req: point(20, 22, 5), jobs: 14, inflight: 7.5},
+		// точка прежнего запуска пропускается
+		{name: "Store() Test 6", req: point(10, 13, 12), jobs: 14, inflight: 9},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := test.req.Store(ctx, s, tracker)
+			require.NoError(t, err)
+			c, err := s.GetCounterValue(ctx, "jobs")
+			require.NoError(t, err)
+			assert.Equal(t, test.jobs, c)
+			g, err := s.GetGaugeValue(ctx, "inflight")
+			require.NoError(t, err)
+			assert.Equal(t, test.inflight, g)
+		})
+	}
+}
+
+func TestStoreNonFinite(t *testing.T) {
+	ctx := context.Background()
+	s := storage.New(0, "", false)
+
+	req := Request{ResourceMetrics: []ResourceMetrics{{Metrics: []Metric{
+		{Name: "temp", Kind: Gauge, Points: []NumberPoint{{Value: math.NaN()}, {Attributes: map[string]string{"room": "a"}, Value: 21}}},
+		{Name: "load", Kind: Sum, Temporality: Delta, Points: []NumberPoint{{Value: math.Inf(1)}}},
+		{Name: "latency", Kind: Histogram, Temporality: Cumulative, Histograms: []HistogramPoint{
+			{Count: 1, Sum: math.Inf(-1), BucketCounts: []uint64{1}},
+		}},
+	}}}}
+	rejected, err := req.Store(ctx, s, NewTracker())
+	require.NoError(t, err)
+	assert.Equal(t, 3, rejected)
+
+	counters, err := s.Counters(ctx)
+	require.NoError(t, err)
+	gauges, err := s.Gauges(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, counters)
+	assert.Equal(t, map[string]float64{`temp{room="a"}`: 21}, gauges)
+}
+
+func TestTrackerPrune(t *testing.T) {
+	tracker := NewTracker()
+	now := time.Unix(1000, 0)
+
+	_, ok := tracker.advance("a", timedInt{value: 10, start: 1}, now)
+	require.True(t, ok)
+	_, ok = tracker.advance("b", timedInt{value: 5, start: 1}, now.Add(trackerTTL/2))
+	require.True(t, ok)
+
+	// серия a дольше trackerTTL без точек и забывается
+	_, ok = tracker.advance("b", timedInt{value: 6, start: 1}, now.Add(trackerTTL*3/2))
+	require.True(t, ok)
+	assert.Len(t, tracker.series, 1)
+	assert.Contains(t, tracker.series, "b")
+
+	// точка забытой серии принимается как первая
+	v, ok := tracker.advance("a", timedInt{value: 3, start: 1}, now.Add(trackerTTL*2))
+	require.True(t, ok)
+	assert.Equal(t, int64(3), v)
+}
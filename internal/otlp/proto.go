@@ -0,0 +1,411 @@
+package otlp
+
+import (
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Unmarshal разбирает сообщение ExportMetricsServiceRequest в кодировке
+// protobuf. Неизвестные поля пропускаются.
+func Unmarshal(data []byte) (Request, error) {
+	var req Request
+	err := walk(data, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+		if num == 1 && typ == protowire.BytesType {
+			rm, err := unmarshalResourceMetrics(v)
+			if err != nil {
+				return fmt.Errorf("resource_metrics: %w", err)
+			}
+			req.ResourceMetrics = append(req.ResourceMetrics, rm)
+		}
+		return nil
+	})
+	return req, err
+}
+
+func unmarshalResourceMetrics(data []byte) (ResourceMetrics, error) {
+	rm := ResourceMetrics{Attributes: make(map[string]string)}
+	err := walk(data, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			// Resource: атрибуты в поле 1
+			return walk(v, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+				if num == 1 && typ == protowire.BytesType {
+					return unmarshalKeyValue(v, rm.Attributes)
+				}
+				return nil
+			})
+		case 2:
+			// ScopeMetrics: метрики в поле 2
+			return walk(v, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+				if num == 2 && typ == protowire.BytesType {
+					m, err := unmarshalMetric(v)
+					if err != nil {
+						return fmt.Errorf("metric: %w", err)
+					}
+					rm.Metrics = append(rm.Metrics, m)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return rm, err
+}
+
+func unmarshalMetric(data []byte) (Metric, error) {
+	var m Metric
+	err := walk(data, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			m.Name = string(v)
+		case 3:
+			m.Unit = string(v)
+		case 5:
+			m.Kind = Gauge
+			return unmarshalNumberPoints(v, &m)
+		case 7:
+			m.Kind = Sum
+			return unmarshalNumberPoints(v, &m)
+		case 9:
+			m.Kind = Histogram
+			return walk(v, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+				switch {
+				case num == 1 && typ == protowire.BytesType:
+					h, err := unmarshalHistogramPoint(v)
+					if err != nil {
+						return err
+					}
+					m.Histograms = append(m.Histograms, h)
+				case num == 2 && typ == protowire.VarintType:
+					m.Temporality = Temporality(n)
+				}
+				return nil
+			})
+		case 10, 11:
+			// экспоненциальная гистограмма и summary: считаются только точки
+			return walk(v, func(num protowire.Number, typ protowire.Type, _ []byte, _ uint64) error {
+				if num == 1 && typ == protowire.BytesType {
+					m.Unsupported++
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return m, err
+}
+
+// unmarshalNumberPoints разбирает сообщение Gauge или Sum.
+func unmarshalNumberPoints(data []byte, m *Metric) error {
+	return walk(data, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			p, err := unmarshalNumberPoint(v)
+			if err != nil {
+				return err
+			}
+			m.Points = append(m.Points, p)
+		case num == 2 && typ == protowire.VarintType:
+			m.Temporality = Temporality(n)
+		case num == 3 && typ == protowire.VarintType:
+			m.Monotonic = n != 0
+		}
+		return nil
+	})
+}
+
+func unmarshalNumberPoint(data []byte) (NumberPoint, error) {
+	p := NumberPoint{Attributes: make(map[string]string), NoValue: true}
+	err := walk(data, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch {
+		case num == 7 && typ == protowire.BytesType:
+			return unmarshalKeyValue(v, p.Attributes)
+		case num == 2 && typ == protowire.Fixed64Type:
+			p.StartTime = n
+		case num == 3 && typ == protowire.Fixed64Type:
+			p.Time = n
+		case num == 4 && typ == protowire.Fixed64Type:
+			p.Value, p.IsInt, p.NoValue = math.Float64frombits(n), false, false
+		case num == 6 && typ == protowire.Fixed64Type:
+			p.Int = int64(n)
+			p.Value, p.IsInt, p.NoValue = float64(p.Int), true, false
+		}
+		return nil
+	})
+	return p, err
+}
+
+func unmarshalHistogramPoint(data []byte) (HistogramPoint, error) {
+	h := HistogramPoint{Attributes: make(map[string]string)}
+	err := walk(data, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch {
+		case num == 9 && typ == protowire.BytesType:
+			return unmarshalKeyValue(v, h.Attributes)
+		case num == 2 && typ == protowire.Fixed64Type:
+			h.StartTime = n
+		case num == 3 && typ == protowire.Fixed64Type:
+			h.Time = n
+		case num == 4 && typ == protowire.Fixed64Type:
+			h.Count = n
+		case num == 5 && typ == protowire.Fixed64Type:
+			h.Sum = math.Float64frombits(n)
+		case num == 6 && typ == protowire.Fixed64Type:
+			h.BucketCounts = append(h.BucketCounts, n)
+		case num == 6 && typ == protowire.BytesType:
+			counts, err := unpackFixed64(v)
+			if err != nil {
+				return err
+			}
+			h.BucketCounts = append(h.BucketCounts, counts...)
+		case num == 7 && typ == protowire.Fixed64Type:
+			h.ExplicitBounds = append(h.ExplicitBounds, math.Float64frombits(n))
+		case num == 7 && typ == protowire.BytesType:
+			bounds, err := unpackFixed64(v)
+			if err != nil {
+				return err
+			}
+			for _, b := range bounds {
+				h.ExplicitBounds = append(h.ExplicitBounds, math.Float64frombits(b))
+			}
+		}
+		return nil
+	})
+	return h, err
+}
+
+// unmarshalKeyValue добавляет атрибут в attrs. Атрибуты со значениями
+// составных типов пропускаются.
+func unmarshalKeyValue(data []byte, attrs map[string]string) error {
+	var (
+		key   string
+		value any
+	)
+	err := walk(data, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			key = string(v)
+		case num == 2 && typ == protowire.BytesType:
+			return walk(v, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+				switch {
+				case num == 1 && typ == protowire.BytesType:
+					value = string(v)
+				case num == 2 && typ == protowire.VarintType:
+					value = n != 0
+				case num == 3 && typ == protowire.VarintType:
+					value = int64(n)
+				case num == 4 && typ == protowire.Fixed64Type:
+					value = math.Float64frombits(n)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if s, ok := formatAttribute(value); ok && key != "" {
+		attrs[key] = s
+	}
+	return nil
+}
+
+func unpackFixed64(data []byte) ([]uint64, error) {
+	values := make([]uint64, 0, len(data)/8)
+	for len(data) > 0 {
+		v, n := protowire.ConsumeFixed64(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		values = append(values, v)
+		data = data[n:]
+	}
+	return values, nil
+}
+
+// walk перебирает поля сообщения так же, как одноимённая функция
+// пакета remotewrite.
+func walk(data []byte, fn func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var (
+			bytes []byte
+			value uint64
+		)
+		switch typ {
+		case protowire.VarintType:
+			value, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			value, n = protowire.ConsumeFixed64(data)
+		case protowire.Fixed32Type:
+			var v32 uint32
+			v32, n = protowire.ConsumeFixed32(data)
+			value = uint64(v32)
+		case protowire.BytesType:
+			bytes, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if err := fn(num, typ, bytes, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarshalResponse сериализует ExportMetricsServiceResponse. Если точек
+// не отклонено, ответ пустой.
+func MarshalResponse(rejected int, message string) []byte {
+	if rejected == 0 {
+		return nil
+	}
+	var ps []byte
+	ps = protowire.AppendTag(ps, 1, protowire.VarintType)
+	ps = protowire.AppendVarint(ps, uint64(rejected))
+	ps = protowire.AppendTag(ps, 2, protowire.BytesType)
+	ps = protowire.AppendString(ps, message)
+
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	return protowire.AppendBytes(b, ps)
+}
+
+// Marshal сериализует запрос так, как это делает экспортёр OpenTelemetry.
+// Метрики каждого ресурса помещаются в один scope.
+func Marshal(req Request) []byte {
+	var b []byte
+	for _, rm := range req.ResourceMetrics {
+		var res []byte
+		for k, v := range rm.Attributes {
+			res = appendKeyValue(res, 1, k, v)
+		}
+		var scope []byte
+		for _, m := range rm.Metrics {
+			scope = protowire.AppendTag(scope, 2, protowire.BytesType)
+			scope = protowire.AppendBytes(scope, marshalMetric(m))
+		}
+
+		var rmb []byte
+		rmb = protowire.AppendTag(rmb, 1, protowire.BytesType)
+		rmb = protowire.AppendBytes(rmb, res)
+		rmb = protowire.AppendTag(rmb, 2, protowire.BytesType)
+		rmb = protowire.AppendBytes(rmb, scope)
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, rmb)
+	}
+	return b
+}
+
+func marshalMetric(m Metric) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, m.Name)
+	if m.Unit != "" {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, m.Unit)
+	}
+
+	var data []byte
+	for _, p := range m.Points {
+		var pb []byte
+		for k, v := range p.Attributes {
+			pb = appendKeyValue(pb, 7, k, v)
+		}
+		if p.StartTime != 0 {
+			pb = protowire.AppendTag(pb, 2, protowire.Fixed64Type)
+			pb = protowire.AppendFixed64(pb, p.StartTime)
+		}
+		pb = protowire.AppendTag(pb, 3, protowire.Fixed64Type)
+		pb = protowire.AppendFixed64(pb, p.Time)
+		if p.IsInt {
+			pb = protowire.AppendTag(pb, 6, protowire.Fixed64Type)
+			pb = protowire.AppendFixed64(pb, uint64(p.Int))
+		} else {
+			pb = protowire.AppendTag(pb, 4, protowire.Fixed64Type)
+			pb = protowire.AppendFixed64(pb, math.Float64bits(p.Value))
+		}
+		data = protowire.AppendTag(data, 1, protowire.BytesType)
+		data = protowire.AppendBytes(data, pb)
+	}
+	for _, h := range m.Histograms {
+		var hb []byte
+		for k, v := range h.Attributes {
+			hb = appendKeyValue(hb, 9, k, v)
+		}
+		if h.StartTime != 0 {
+			hb = protowire.AppendTag(hb, 2, protowire.Fixed64Type)
+			hb = protowire.AppendFixed64(hb, h.StartTime)
+		}
+		hb = protowire.AppendTag(hb, 3, protowire.Fixed64Type)
+		hb = protowire.AppendFixed64(hb, h.Time)
+		hb = protowire.AppendTag(hb, 4, protowire.Fixed64Type)
+		hb = protowire.AppendFixed64(hb, h.Count)
+		hb = protowire.AppendTag(hb, 5, protowire.Fixed64Type)
+		hb = protowire.AppendFixed64(hb, math.Float64bits(h.Sum))
+		var counts, bounds []byte
+		for _, c := range h.BucketCounts {
+			counts = protowire.AppendFixed64(counts, c)
+		}
+		for _, bound := range h.ExplicitBounds {
+			bounds = protowire.AppendFixed64(bounds, math.Float64bits(bound))
+		}
+		hb = protowire.AppendTag(hb, 6, protowire.BytesType)
+		hb = protowire.AppendBytes(hb, counts)
+		hb = protowire.AppendTag(hb, 7, protowire.BytesType)
+		hb = protowire.AppendBytes(hb, bounds)
+		data = protowire.AppendTag(data, 1, protowire.BytesType)
+		data = protowire.AppendBytes(data, hb)
+	}
+	for i := 0; i < m.Unsupported; i++ {
+		data = protowire.AppendTag(data, 1, protowire.BytesType)
+		data = protowire.AppendBytes(data, nil)
+	}
+	if m.Temporality != TemporalityUnspecified {
+		data = protowire.AppendTag(data, 2, protowire.VarintType)
+		data = protowire.AppendVarint(data, uint64(m.Temporality))
+	}
+	if m.Monotonic {
+		data = protowire.AppendTag(data, 3, protowire.VarintType)
+		data = protowire.AppendVarint(data, 1)
+	}
+
+	field := map[Kind]protowire.Number{Gauge: 5, Sum: 7, Histogram: 9}[m.Kind]
+	if field == 0 {
+		field = 11
+	}
+	b = protowire.AppendTag(b, field, protowire.BytesType)
+	return protowire.AppendBytes(b, data)
+}
+
+func appendKeyValue(b []byte, field protowire.Number, key, value string) []byte {
+	var av []byte
+	av = protowire.AppendTag(av, 1, protowire.BytesType)
+	av = protowire.AppendString(av, value)
+
+	var kv []byte
+	kv = protowire.AppendTag(kv, 1, protowire.BytesType)
+	kv = protowire.AppendString(kv, key)
+	kv = protowire.AppendTag(kv, 2, protowire.BytesType)
+	kv = protowire.AppendBytes(kv, av)
+
+	b = protowire.AppendTag(b, field, protowire.BytesType)
+	return protowire.AppendBytes(b, kv)
+}
@@ -0,0 +1,140 @@
+package query
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/series"
+)
+
+// ValueType — тип значения выражения.
+type ValueType string
+
+const (
+	ValueScalar ValueType = "scalar"
+	ValueVector ValueType = "vector"
+	ValueMatrix ValueType = "matrix"
+)
+
+// Expr — узел разобранного выражения.
+type Expr interface {
+	// Type возвращает тип значения, которое получится при вычислении.
+	Type() ValueType
+	String() string
+}
+
+// NumberLiteral — числовая константа.
+type NumberLiteral struct {
+	Value float64
+}
+
+// VectorSelector выбирает серии по имени и меткам. С ненулевым Range
+// возвращает значения серий за этот интервал, иначе — последнее
+// значение каждой серии.
+type VectorSelector struct {
+	Selector series.Selector
+	Range    time.Duration
+}
+
+// UnaryExpr — унарный минус.
+type UnaryExpr struct {
+	Expr Expr
+}
+
+// BinaryExpr — арифметическая операция или сравнение. Сравнение
+// оставляет в векторе только серии, для которых условие выполнено.
+type BinaryExpr struct {
+	Op  string
+	LHS Expr
+	RHS Expr
+}
+
+// Call — вызов функции.
+type Call struct {
+	Func string
+	Args []Expr
+}
+
+// AggregateExpr — агрегация вектора по группам меток. Группы задаются
+// списком меток в by или списком исключённых меток в without.
+type AggregateExpr struct {
+	Op       string
+	Expr     Expr
+	Grouping []string
+	Without  bool
+}
+
+func (e *NumberLiteral) Type() ValueType { return ValueScalar }
+
+func (e *VectorSelector) Type() ValueType {
+	if e.Range > 0 {
+		return ValueMatrix
+	}
+	return ValueVector
+}
+
+func (e *UnaryExpr) Type() ValueType { return e.Expr.Type() }
+
+func (e *BinaryExpr) Type() ValueType {
+	if e.LHS.Type() == ValueScalar && e.RHS.Type() == ValueScalar {
+		return ValueScalar
+	}
+	return ValueVector
+}
+
+func (e *Call) Type() ValueType { return functions[e.Func].ret }
+
+func (e *AggregateExpr) Type() ValueType { return ValueVector }
+
+func (e *NumberLiteral) String() string {
+	return strconv.FormatFloat(e.Value, 'g', -1, 64)
+}
+
+func (e *VectorSelector) String() string {
+	if e.Range > 0 {
+		return e.Selector.String() + "[" + formatDuration(e.Range) + "]"
+	}
+	return e.Selector.String()
+}
+
+func (e *UnaryExpr) String() string { return "-" + e.Expr.String() }
+
+func (e *BinaryExpr) String() string {
+	return "(" + e.LHS.String() + " " + e.Op + " " + e.RHS.String() + ")"
+}
+
+func (e *Call) String() string {
+	args := make([]string, 0, len(e.Args))
+	for _, a := range e.Args {
+		args = append(args, a.String())
+	}
+	return e.Func + "(" + strings.Join(args, ", ") + ")"
+}
+
+func (e *AggregateExpr) String() string {
+	s := e.Op
+	switch {
+	case e.Without:
+		s += " without (" + strings.Join(e.Grouping, ", ") + ")"
+	case len(e.Grouping) != 0:
+		s += " by (" + strings.Join(e.Grouping, ", ") + ")"
+	}
+	return s + " (" + e.Expr.String() + ")"
+}
+
+// formatDuration записывает интервал в виде 1h30m, как в выражениях.
+func formatDuration(d time.Duration) string {
+	var sb strings.Builder
+	for _, u := range durationUnits {
+		if n := d / u.d; n > 0 {
+			sb.WriteString(strconv.FormatInt(int64(n), 10))
+			sb.WriteString(u.name)
+			d -= n * u.d
+		}
+	}
+	if sb.Len() == 0 {
+		return "0s"
+	}
+	return sb.String()
+}
@@ -0,0 +1,386 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/series"
+	"github.com/amidvn/go-metrics/internal/storage"
+)
+
+// Lookback — насколько далеко в прошлом ищется последнее значение
+// серии при вычислении на заданный момент.
+const Lookback = 5 * time.Minute
+
+// ErrNoHistory возвращается, если выражению нужна история значений,
+// а она не включена.
+var ErrNoHistory = errors.New("history is disabled")
+
+// Value — результат вычисления: Scalar, Vector или Matrix.
+type Value interface {
+	Type() ValueType
+}
+
+// Scalar — число.
+type Scalar float64
+
+// Sample — значение серии в векторе.
+type Sample struct {
+	Key   series.Key
+	Value float64
+}
+
+// Vector — набор серий с одним значением у каждой.
+type Vector []Sample
+
+// Matrix — набор серий со значениями за интервал.
+type Matrix []Series
+
+func (Scalar) Type() ValueType { return ValueScalar }
+func (Vector) Type() ValueType { return ValueVector }
+func (Matrix) Type() ValueType { return ValueMatrix }
+
+// Evaluator вычисляет выражения над хранилищем и историей.
+type Evaluator struct {
+	Storage storage.Storage
+	// History нужна для интервалов и вычисления на момент в прошлом;
+	// может быть nil
+	History storage.History
+	// Time — момент вычисления. Нулевой момент означает текущие
+	// значения хранилища.
+	Time time.Time
+}
+
+// Eval вычисляет выражение. Серии вектора упорядочены по ключу.
+func (ev Evaluator) Eval(ctx context.Context, expr Expr) (Value, error) {
+	e := &evaluation{Evaluator: ev, ctx: ctx}
+	if e.Time.IsZero() {
+		e.current = true
+		e.Time = time.Now()
+	}
+	v, err := e.eval(expr)
+	if err != nil {
+		return nil, err
+	}
+	if vec, ok := v.(Vector); ok {
+		sort.Slice(vec, func(i, j int) bool { return vec[i].Key.String() < vec[j].Key.String() })
+	}
+	return v, nil
+}
+
+type evaluation struct {
+	Evaluator
+	ctx     context.Context
+	current bool
+}
+
+func (e *evaluation) eval(expr Expr) (Value, error) {
+	switch expr := expr.(type) {
+	case *NumberLiteral:
+		return Scalar(expr.Value), nil
+	case *VectorSelector:
+		if expr.Range > 0 {
+			return e.matrix(expr)
+		}
+		return e.vector(expr)
+	case *UnaryExpr:
+		v, err := e.eval(expr.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return e.binary("*", Scalar(-1), v)
+	case *BinaryExpr:
+		lhs, err := e.eval(expr.LHS)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := e.eval(expr.RHS)
+		if err != nil {
+			return nil, err
+		}
+		return e.binary(expr.Op, lhs, rhs)
+	case *Call:
+		return e.call(expr)
+	case *AggregateExpr:
+		v, err := e.eval(expr.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return aggregateVector(expr, v.(Vector)), nil
+	}
+	return nil, fmt.Errorf("%w: unsupported expression %s", ErrBadQuery, expr)
+}
+
+// vector возвращает последние значения серий: текущие из хранилища
+// или из истории не ранее Lookback до момента вычисления.
+func (e *evaluation) vector(vs *VectorSelector) (Value, error) {
+	matched, err := match(e.ctx, e.Storage, []series.Selector{vs.Selector}, "")
+	if err != nil {
+		return nil, err
+	}
+	var vec Vector
+	for _, sr := range matched {
+		key := series.Key{Name: sr.Name, Labels: sr.Labels}
+		if e.current {
+			vec = append(vec, Sample{Key: key, Value: sr.Points[0].Value})
+			continue
+		}
+		points, err := e.points(sr, Lookback)
+		if err != nil {
+			return nil, err
+		}
+		if len(points) != 0 {
+			vec = append(vec, Sample{Key: key, Value: points[len(points)-1].Value})
+		}
+	}
+	return vec, nil
+}
+
+func (e *evaluation) matrix(vs *VectorSelector) (Value, error) {
+	matched, err := match(e.ctx, e.Storage, []series.Selector{vs.Selector}, "")
+	if err != nil {
+		return nil, err
+	}
+	var m Matrix
+	for _, sr := range matched {
+		sr.Points, err = e.points(sr, vs.Range)
+		if err != nil {
+			return nil, err
+		}
+		if len(sr.Points) != 0 {
+			m = append(m, sr)
+		}
+	}
+	return m, nil
+}
+
+// points возвращает значения серии из истории в интервале (t-d, t].
+func (e *evaluation) points(sr Series, d time.Duration) ([]Point, error) {
+	if e.History == nil {
+		return nil, ErrNoHistory
+	}
+	from := e.Time.Add(-d)
+	samples, err := e.History.Range(e.ctx, sr.Key, from, e.Time)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Timestamp.Before(samples[j].Timestamp) })
+
+	var points []Point
+	for _, smp := range samples {
+		if smp.MType == sr.Type && smp.Timestamp.After(from) {
+			points = append(points, Point{Time: smp.Timestamp, Value: smp.Value})
+		}
+	}
+	return points, nil
+}
+
+func (e *evaluation) call(c *Call) (Value, error) {
+	switch c.Func {
+	case "time":
+		return Scalar(float64(e.Time.UnixNano()) / float64(time.Second)), nil
+	case "vector":
+		v, err := e.eval(c.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		return Vector{{Value: float64(v.(Scalar))}}, nil
+	}
+
+	arg, err := e.eval(c.Args[0])
+	if err != nil {
+		return nil, err
+	}
+	if c.Func == "scalar" {
+		if vec := arg.(Vector); len(vec) == 1 {
+			return Scalar(vec[0].Value), nil
+		}
+		return Scalar(math.NaN()), nil
+	}
+	if fn, ok := mathFunctions[c.Func]; ok {
+		var out Vector
+		for _, smp := range arg.(Vector) {
+			out = append(out, Sample{Key: dropName(smp.Key), Value: fn(smp.Value)})
+		}
+		return out, nil
+	}
+
+	fn := rangeFunctions[c.Func]
+	d := c.Args[0].(*VectorSelector).Range
+	var out Vector
+	for _, sr := range arg.(Matrix) {
+		if v, ok := fn(sr.Points, d); ok {
+			out = append(out, Sample{Key: series.Key{Labels: sr.Labels}, Value: v})
+		}
+	}
+	return out, nil
+}
+
+// binary применяет бинарный оператор. Серии двух векторов
+// сопоставляются по одинаковому набору меток без учёта имени.
+// Арифметика убирает имя метрики из результата, сравнение оставляет
+// серии левого операнда, для которых условие выполнено.
+func (e *evaluation) binary(op string, lhs, rhs Value) (Value, error) {
+	ls, lScalar := lhs.(Scalar)
+	rs, rScalar := rhs.(Scalar)
+	switch {
+	case lScalar && rScalar:
+		if isComparison(op) {
+			if compareValues(op, float64(ls), float64(rs)) {
+				return Scalar(1), nil
+			}
+			return Scalar(0), nil
+		}
+		return Scalar(arith(op, float64(ls), float64(rs))), nil
+	case rScalar:
+		return vectorScalar(op, lhs.(Vector), float64(rs), false), nil
+	case lScalar:
+		return vectorScalar(op, rhs.(Vector), float64(ls), true), nil
+	}
+
+	index := make(map[string]Sample)
+	for _, smp := range rhs.(Vector) {
+		sig := signature(smp.Key)
+		if _, ok := index[sig]; ok {
+			return nil, fmt.Errorf("%w: many-to-many matching not allowed: duplicate series %s on the right side of %s", ErrBadQuery, sig, op)
+		}
+		index[sig] = smp
+	}
+	seen := make(map[string]bool)
+	var out Vector
+	for _, smp := range lhs.(Vector) {
+		sig := signature(smp.Key)
+		if seen[sig] {
+			return nil, fmt.Errorf("%w: many-to-many matching not allowed: duplicate series %s on the left side of %s", ErrBadQuery, sig, op)
+		}
+		seen[sig] = true
+		r, ok := index[sig]
+		if !ok {
+			continue
+		}
+		if isComparison(op) {
+			if compareValues(op, smp.Value, r.Value) {
+				out = append(out, smp)
+			}
+			continue
+		}
+		out = append(out, Sample{Key: dropName(smp.Key), Value: arith(op, smp.Value, r.Value)})
+	}
+	return out, nil
+}
+
+// vectorScalar применяет оператор к каждой серии вектора; swap
+// означает, что скаляр стоит слева.
+func vectorScalar(op string, vec Vector, s float64, swap bool) Vector {
+	var out Vector
+	for _, smp := range vec {
+		a, b := smp.Value, s
+		if swap {
+			a, b = b, a
+		}
+		if isComparison(op) {
+			if compareValues(op, a, b) {
+				out = append(out, smp)
+			}
+			continue
+		}
+		out = append(out, Sample{Key: dropName(smp.Key), Value: arith(op, a, b)})
+	}
+	return out
+}
+
+func isComparison(op string) bool {
+	return precedence[op] == precedence["=="]
+}
+
+func compareValues(op string, a, b float64) bool {
+	switch op {
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	case ">":
+		return a > b
+	case "<":
+		return a < b
+	case ">=":
+		return a >= b
+	case "<=":
+		return a <= b
+	}
+	return false
+}
+
+func arith(op string, a, b float64) float64 {
+	switch op {
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	case "/":
+		return a / b
+	case "%":
+		return math.Mod(a, b)
+	case "^":
+		return math.Pow(a, b)
+	}
+	return math.NaN()
+}
+
+// aggregateVector группирует серии по меткам и агрегирует значения
+// каждой группы.
+func aggregateVector(agg *AggregateExpr, vec Vector) Vector {
+	grouping := make(map[string]bool, len(agg.Grouping))
+	for _, l := range agg.Grouping {
+		grouping[l] = true
+	}
+
+	type group struct {
+		key    series.Key
+		values []float64
+	}
+	groups := make(map[string]*group)
+	var order []string
+	for _, smp := range vec {
+		var key series.Key
+		for _, l := range smp.Key.Labels {
+			if grouping[l.Name] != agg.Without {
+				key.Labels = append(key.Labels, l)
+			}
+		}
+		sig := signature(key)
+		g, ok := groups[sig]
+		if !ok {
+			g = &group{key: key}
+			groups[sig] = g
+			order = append(order, sig)
+		}
+		g.values = append(g.values, smp.Value)
+	}
+
+	out := make(Vector, 0, len(order))
+	for _, sig := range order {
+		g := groups[sig]
+		v := float64(len(g.values))
+		if agg.Op != "count" {
+			v, _ = aggregate(g.values, RangeQuery{Func: agg.Op}, false)
+		}
+		out = append(out, Sample{Key: g.key, Value: v})
+	}
+	return out
+}
+
+// signature — ключ серии без имени метрики.
+func signature(k series.Key) string {
+	return series.Key{Labels: k.Labels}.String()
+}
+
+func dropName(k series.Key) series.Key {
+	return series.Key{Labels: k.Labels}
+}
@@ -0,0 +1,117 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluatorEval(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	ago := func(sec int) time.Time { return now.Add(-time.Duration(sec) * time.Second) }
+
+	s := storage.New(0, "", false)
+	s.UpdateGauge(ctx, `HeapAlloc{host="a",job="agent"}`, 100)
+	s.UpdateGauge(ctx, `HeapAlloc{host="b",job="agent"}`, 300)
+	s.UpdateGauge(ctx, `HeapSys{host="a",job="agent"}`, 400)
+	s.UpdateGauge(ctx, `HeapSys{host="b",job="agent"}`, 600)
+	s.UpdateCounter(ctx, `PollCount{host="a"}`, 90)
+
+	h := fakeHistory{
+		{Name: `PollCount{host="a"}`, MType: "counter", Timestamp: ago(400), Value: 0},
+		{Name: `PollCount{host="a"}`, MType: "counter", Timestamp: ago(240), Value: 30},
+		{Name: `PollCount{host="a"}`, MType: "counter", Timestamp: ago(120), Value: 60},
+		{Name: `PollCount{host="a"}`, MType: "counter", Timestamp: ago(60), Value: 10},
+		{Name: `PollCount{host="a"}`, MType: "counter", Timestamp: ago(0), Value: 40},
+		{Name: `HeapAlloc{host="a",job="agent"}`, MType: "gauge", Timestamp: ago(30), Value: 50},
+		{Name: `HeapAlloc{host="b",job="agent"}`, MType: "gauge", Timestamp: ago(600), Value: 70},
+	}
+
+	testCases := []struct {
+		name    string
+		input   string
+		at      time.Time
+		want    map[string]float64
+		scalar  float64
+		wantErr error
+	}{
+		{name: "Eval() Test 1", input: `HeapAlloc`,
+			want: map[string]float64{`HeapAlloc{host="a",job="agent"}`: 100, `HeapAlloc{host="b",job="agent"}`: 300}},
+		{name: "Eval() Test 2", input: `sum(HeapAlloc)`, want: map[string]float64{``: 400}},
+		{name: "Eval() Test 3", input: `sum without (host) (HeapAlloc)`, want: map[string]float64{`{job="agent"}`: 400}},
+		{name: "Eval() Test 4", input: `avg by (host) (HeapAlloc) / 100`, want: map[string]float64{`{host="a"}`: 1, `{host="b"}`: 3}},
+		{name: "Eval() Test 5", input: `HeapAlloc / HeapSys * 100`,
+			want: map[string]float64{`{host="a",job="agent"}`: 25, `{host="b",job="agent"}`: 50}},
+		{name: "Eval() Test 6", input: `HeapAlloc > 200`, want: map[string]float64{`HeapAlloc{host="b",job="agent"}`: 300}},
+		{name: "Eval() Test 7", input: `HeapSys - HeapAlloc >= 300`, want: map[string]float64{`{host="a",job="agent"}`: 300, `{host="b",job="agent"}`: 300}},
+		{name: "Eval() Test 8", input: `count({__name__=~"Heap.*"})`, want: map[string]float64{``: 4}},
+		{name: "Eval() Test 9", input: `max by (job) (HeapSys) - min by (job) (HeapSys)`, want: map[string]float64{`{job="agent"}`: 200}},
+		{name: "Eval() Test 10", input: `2 ^ 3 - 10 % 4`, scalar: 6},
+		{name: "Eval() Test 11", input: `-abs(HeapAlloc{host="a"} - 150)`, want: map[string]float64{`{host="a",job="agent"}`: -50}},
+		{name: "Eval() Test 12", input: `scalar(HeapAlloc{host="b"}) + 1`, scalar: 301},
+		{name: "Eval() Test 13", input: `rate(PollCount[5m])`, at: now, want: map[string]float64{`{host="a"}`: 70.0 / 300}},
+		{name: "Eval() Test 14", input: `increase(PollCount[5m])`, at: now, want: map[string]float64{`{host="a"}`: 70}},
+		{name: "Eval() Test 15", input: `delta(PollCount[5m])`, at: now, want: map[string]float64{`{host="a"}`: 10}},
+		{name: "Eval() Test 16", input: `max_over_time(PollCount[5m])`, at: now, want: map[string]float64{`{host="a"}`: 60}},
+		{name: "Eval() Test 17", input: `count_over_time(PollCount[1m])`, at: now, want: map[string]float64{`{host="a"}`: 1}},
+		{name: "Eval() Test 18", input: `HeapAlloc`, at: now, want: map[string]float64{`HeapAlloc{host="a",job="agent"}`: 50}},
+		{name: "Eval() Test 19", input: `PollCount`, at: ago(90), want: map[string]float64{`PollCount{host="a"}`: 60}},
+		{name: "Eval() Test 20", input: `HeapAlloc + on_missing`, want: map[string]float64{}},
+		{name: "Eval() Test 21", input: `rate(PollCount[5m])`, wantErr: ErrNoHistory},
+		{name: "Eval() Test 22", input: `HeapAlloc + sum(HeapSys)`, want: map[string]float64{}},
+		{name: "Eval() Test 23", input: `{job="agent"} * {job="agent"}`, wantErr: ErrBadQuery},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			expr, err := Parse(test.input)
+			require.NoError(t, err)
+
+			ev := Evaluator{Storage: s, History: h, Time: test.at}
+			if errors.Is(test.wantErr, ErrNoHistory) {
+				ev.History = nil
+			}
+			v, err := ev.Eval(ctx, expr)
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			if test.want == nil {
+				require.IsType(t, Scalar(0), v)
+				assert.InDelta(t, test.scalar, float64(v.(Scalar)), 1e-9)
+				return
+			}
+			require.IsType(t, Vector{}, v)
+			got := make(map[string]float64)
+			for _, smp := range v.(Vector) {
+				got[smp.Key.String()] = smp.Value
+			}
+			require.Len(t, got, len(test.want))
+			for key, value := range test.want {
+				assert.InDelta(t, value, got[key], 1e-9, key)
+			}
+		})
+	}
+
+	expr, err := Parse(`PollCount[5m]`)
+	require.NoError(t, err)
+	v, err := Evaluator{Storage: s, History: h, Time: now}.Eval(ctx, expr)
+	require.NoError(t, err)
+	require.IsType(t, Matrix{}, v)
+	require.Len(t, v.(Matrix), 1)
+	assert.Len(t, v.(Matrix)[0].Points, 4)
+
+	expr, err = Parse(`scalar(HeapAlloc)`)
+	require.NoError(t, err)
+	v, err = Evaluator{Storage: s}.Eval(ctx, expr)
+	require.NoError(t, err)
+	assert.True(t, math.IsNaN(float64(v.(Scalar))))
+}
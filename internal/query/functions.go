@@ -0,0 +1,89 @@
+package query
+
+import (
+	"math"
+	"time"
+)
+
+type function struct {
+	args []ValueType
+	ret  ValueType
+}
+
+// rangeFunctions вычисляют значение серии по точкам интервала. Функция
+// возвращает false, если точек недостаточно.
+var rangeFunctions = map[string]func(points []Point, d time.Duration) (float64, bool){
+	// rate — средний прирост в секунду с учётом сбросов счётчика
+	"rate": func(points []Point, d time.Duration) (float64, bool) {
+		if len(points) < 2 {
+			return 0, false
+		}
+		return increase(values(points), true) / d.Seconds(), true
+	},
+	// increase — прирост за интервал с учётом сбросов счётчика
+	"increase": func(points []Point, _ time.Duration) (float64, bool) {
+		if len(points) < 2 {
+			return 0, false
+		}
+		return increase(values(points), true), true
+	},
+	// delta — разность последнего и первого значений
+	"delta": func(points []Point, _ time.Duration) (float64, bool) {
+		if len(points) < 2 {
+			return 0, false
+		}
+		return points[len(points)-1].Value - points[0].Value, true
+	},
+	"avg_over_time":  overTime(FuncAvg),
+	"min_over_time":  overTime(FuncMin),
+	"max_over_time":  overTime(FuncMax),
+	"sum_over_time":  overTime(FuncSum),
+	"last_over_time": overTime(FuncLast),
+	"count_over_time": func(points []Point, _ time.Duration) (float64, bool) {
+		return float64(len(points)), len(points) != 0
+	},
+}
+
+// overTime применяет к точкам интервала функцию агрегации запроса
+// /api/v1/query_range.
+func overTime(fn string) func(points []Point, d time.Duration) (float64, bool) {
+	return func(points []Point, _ time.Duration) (float64, bool) {
+		return aggregate(values(points), RangeQuery{Func: fn}, false)
+	}
+}
+
+// mathFunctions применяются к каждому значению вектора.
+var mathFunctions = map[string]func(float64) float64{
+	"abs":   math.Abs,
+	"ceil":  math.Ceil,
+	"floor": math.Floor,
+	"round": math.Round,
+	"sqrt":  math.Sqrt,
+	"exp":   math.Exp,
+	"ln":    math.Log,
+	"log2":  math.Log2,
+	"log10": math.Log10,
+}
+
+// functions — сигнатуры всех функций выражений.
+var functions = make(map[string]function)
+
+func init() {
+	for name := range rangeFunctions {
+		functions[name] = function{args: []ValueType{ValueMatrix}, ret: ValueVector}
+	}
+	for name := range mathFunctions {
+		functions[name] = function{args: []ValueType{ValueVector}, ret: ValueVector}
+	}
+	functions["scalar"] = function{args: []ValueType{ValueVector}, ret: ValueScalar}
+	functions["vector"] = function{args: []ValueType{ValueScalar}, ret: ValueVector}
+	functions["time"] = function{ret: ValueScalar}
+}
+
+func values(points []Point) []float64 {
+	vs := make([]float64, 0, len(points))
+	for _, p := range points {
+		vs = append(vs, p.Value)
+	}
+	return vs
+}
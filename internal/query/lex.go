@@ -0,0 +1,195 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokRange
+	tokOp
+	tokLParen
+	tokRParen
+	tokLBrace
+	tokRBrace
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of input"
+	}
+	if t.kind == tokRange {
+		return "[" + t.text + "]"
+	}
+	return strconv.Quote(t.text)
+}
+
+// operators перечислены так, что двухсимвольные проверяются раньше
+// своих односимвольных префиксов.
+var operators = []string{"==", "!=", ">=", "<=", "=~", "!~", "+", "-", "*", "/", "%", "^", ">", "<", "="}
+
+// lex разбивает выражение на лексемы. Последняя лексема — tokEOF.
+func lex(input string) ([]token, error) {
+	var tokens []token
+	pos := 0
+	for {
+		for pos < len(input) && strings.IndexByte(" \t\r\n", input[pos]) >= 0 {
+			pos++
+		}
+		if pos == len(input) {
+			return append(tokens, token{kind: tokEOF, pos: pos}), nil
+		}
+
+		start := pos
+		c := input[pos]
+		switch {
+		case c == '(':
+			tokens = append(tokens, token{kind: tokLParen, text: "(", pos: start})
+			pos++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokRParen, text: ")", pos: start})
+			pos++
+		case c == '{':
+			tokens = append(tokens, token{kind: tokLBrace, text: "{", pos: start})
+			pos++
+		case c == '}':
+			tokens = append(tokens, token{kind: tokRBrace, text: "}", pos: start})
+			pos++
+		case c == ',':
+			tokens = append(tokens, token{kind: tokComma, text: ",", pos: start})
+			pos++
+		case c == '[':
+			end := strings.IndexByte(input[pos:], ']')
+			if end < 0 {
+				return nil, parseError(start, "unterminated range")
+			}
+			tokens = append(tokens, token{kind: tokRange, text: strings.TrimSpace(input[pos+1 : pos+end]), pos: start})
+			pos += end + 1
+		case c == '"' || c == '`':
+			n, err := scanString(input[pos:])
+			if err != nil {
+				return nil, parseError(start, err.Error())
+			}
+			s, err := strconv.Unquote(input[pos : pos+n])
+			if err != nil {
+				return nil, parseError(start, "invalid string "+input[pos:pos+n])
+			}
+			tokens = append(tokens, token{kind: tokString, text: s, pos: start})
+			pos += n
+		case isDigit(c) || c == '.' && pos+1 < len(input) && isDigit(input[pos+1]):
+			for pos < len(input) && (isDigit(input[pos]) || input[pos] == '.' || input[pos] == 'e' || input[pos] == 'E' ||
+				(input[pos] == '+' || input[pos] == '-') && (input[pos-1] == 'e' || input[pos-1] == 'E')) {
+				pos++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: input[start:pos], pos: start})
+		case isIdentStart(c):
+			for pos < len(input) && (isIdentStart(input[pos]) || isDigit(input[pos])) {
+				pos++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: input[start:pos], pos: start})
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(input[pos:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, parseError(start, fmt.Sprintf("unexpected character %q", c))
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: start})
+			pos += len(op)
+		}
+	}
+}
+
+// scanString возвращает длину строки в кавычках в начале s. В строке
+// в обратных кавычках экранирование не действует.
+func scanString(s string) (int, error) {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quote == '"' {
+				i++
+			}
+		case quote:
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated string")
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == ':'
+}
+
+var durationUnits = []struct {
+	name string
+	d    time.Duration
+}{
+	{"w", 7 * 24 * time.Hour},
+	{"d", 24 * time.Hour},
+	{"h", time.Hour},
+	{"m", time.Minute},
+	{"s", time.Second},
+	{"ms", time.Millisecond},
+}
+
+// parseDuration разбирает интервал вида 5m или 1h30m. Кроме единиц
+// time.ParseDuration допускаются дни (d) и недели (w).
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty duration")
+	}
+	var d time.Duration
+	for rest := s; rest != ""; {
+		i := 0
+		for i < len(rest) && isDigit(rest[i]) {
+			i++
+		}
+		if i == 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		n, err := strconv.ParseInt(rest[:i], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		rest = rest[i:]
+
+		j := 0
+		for j < len(rest) && !isDigit(rest[j]) {
+			j++
+		}
+		unit := time.Duration(0)
+		for _, u := range durationUnits {
+			if u.name == rest[:j] {
+				unit = u.d
+			}
+		}
+		if unit == 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		rest = rest[j:]
+		d += time.Duration(n) * unit
+	}
+	return d, nil
+}
@@ -0,0 +1,326 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/amidvn/go-metrics/internal/series"
+)
+
+// ErrBadQuery оборачивает ошибки в самом выражении: синтаксические,
+// ошибки типов и неоднозначное сопоставление серий.
+var ErrBadQuery = errors.New("bad query")
+
+func parseError(pos int, msg string) error {
+	return fmt.Errorf("%w: parse error at position %d: %s", ErrBadQuery, pos+1, msg)
+}
+
+// aggregations — операторы агрегации по группам меток.
+var aggregations = map[string]bool{"sum": true, "avg": true, "min": true, "max": true, "count": true}
+
+// precedence — приоритет бинарных операторов; ^ правоассоциативен.
+var precedence = map[string]int{
+	"==": 1, "!=": 1, ">": 1, "<": 1, ">=": 1, "<=": 1,
+	"+": 2, "-": 2,
+	"*": 3, "/": 3, "%": 3,
+	"^": 4,
+}
+
+// Parse разбирает выражение:
+//
+//	sum by (host) (rate(PollCount{job="agent"}[5m])) * 60
+//
+// Поддерживаются селекторы с условиями на метки, интервалы [5m],
+// арифметика + - * / % ^, сравнения == != > < >= <=, функции
+// и агрегации sum, avg, min, max и count с by или without.
+func Parse(input string) (Expr, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	expr, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, parseError(t.pos, "unexpected "+t.String())
+	}
+	return expr, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, parseError(t.pos, fmt.Sprintf("expected %s, got %s", what, t))
+	}
+	return t, nil
+}
+
+// parseBinary разбирает цепочку бинарных операций с приоритетом
+// не ниже minPrec.
+func (p *parser) parseBinary(minPrec int) (Expr, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		prec, ok := precedence[t.text]
+		if t.kind != tokOp || !ok || prec < minPrec {
+			return lhs, nil
+		}
+		p.next()
+
+		nextPrec := prec + 1
+		if t.text == "^" {
+			nextPrec = prec
+		}
+		rhs, err := p.parseBinary(nextPrec)
+		if err != nil {
+			return nil, err
+		}
+		for _, operand := range []Expr{lhs, rhs} {
+			if operand.Type() == ValueMatrix {
+				return nil, parseError(t.pos, fmt.Sprintf("operator %s cannot be applied to range vector %s", t.text, operand))
+			}
+		}
+		lhs = &BinaryExpr{Op: t.text, LHS: lhs, RHS: rhs}
+	}
+}
+
+// parseUnary разбирает унарный плюс или минус. Как и в PromQL, ^
+// связывает сильнее унарного минуса: -2^2 равно -4.
+func (p *parser) parseUnary() (Expr, error) {
+	t := p.peek()
+	if t.kind != tokOp || t.text != "-" && t.text != "+" {
+		return p.parsePrimary()
+	}
+	p.next()
+	expr, err := p.parseBinary(precedence["^"])
+	if err != nil {
+		return nil, err
+	}
+	if expr.Type() == ValueMatrix {
+		return nil, parseError(t.pos, fmt.Sprintf("unary %s cannot be applied to range vector %s", t.text, expr))
+	}
+	if t.text == "+" {
+		return expr, nil
+	}
+	if n, ok := expr.(*NumberLiteral); ok {
+		return &NumberLiteral{Value: -n.Value}, nil
+	}
+	return &UnaryExpr{Expr: expr}, nil
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokNumber:
+		p.next()
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, parseError(t.pos, "invalid number "+t.text)
+		}
+		return &NumberLiteral{Value: v}, nil
+	case tokLParen:
+		p.next()
+		expr, err := p.parseBinary(1)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen, `")"`); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	case tokLBrace:
+		return p.parseSelector("")
+	case tokIdent:
+		p.next()
+		next := p.peek()
+		if aggregations[t.text] && (next.kind == tokLParen || next.kind == tokIdent && (next.text == "by" || next.text == "without")) {
+			return p.parseAggregate(t)
+		}
+		if next.kind == tokLParen {
+			return p.parseCall(t)
+		}
+		return p.parseSelector(t.text)
+	}
+	return nil, parseError(t.pos, "unexpected "+t.String())
+}
+
+// parseSelector разбирает условия на метки и интервал после имени
+// метрики.
+func (p *parser) parseSelector(name string) (Expr, error) {
+	start := p.peek().pos
+	sel := series.Selector{Name: name}
+	if p.peek().kind == tokLBrace {
+		p.next()
+		for p.peek().kind != tokRBrace {
+			m, err := p.parseMatcher()
+			if err != nil {
+				return nil, err
+			}
+			sel.Matchers = append(sel.Matchers, m)
+			if p.peek().kind != tokComma {
+				break
+			}
+			p.next()
+		}
+		if _, err := p.expect(tokRBrace, `"}"`); err != nil {
+			return nil, err
+		}
+	}
+	if sel.Name == "" && len(sel.Matchers) == 0 {
+		return nil, parseError(start, "selector must contain a metric name or a label matcher")
+	}
+
+	vs := &VectorSelector{Selector: sel}
+	if t := p.peek(); t.kind == tokRange {
+		p.next()
+		d, err := parseDuration(t.text)
+		if err != nil {
+			return nil, parseError(t.pos, err.Error())
+		}
+		if d <= 0 {
+			return nil, parseError(t.pos, "range must be positive")
+		}
+		vs.Range = d
+	}
+	return vs, nil
+}
+
+func (p *parser) parseMatcher() (series.Matcher, error) {
+	name, err := p.expect(tokIdent, "label name")
+	if err != nil {
+		return series.Matcher{}, err
+	}
+	op := p.next()
+	var t series.MatchType
+	switch op.text {
+	case "=":
+		t = series.MatchEqual
+	case "!=":
+		t = series.MatchNotEqual
+	case "=~":
+		t = series.MatchRegexp
+	case "!~":
+		t = series.MatchNotRegexp
+	default:
+		return series.Matcher{}, parseError(op.pos, fmt.Sprintf("expected label matching operator, got %s", op))
+	}
+	value, err := p.expect(tokString, "label value")
+	if err != nil {
+		return series.Matcher{}, err
+	}
+	m, err := series.NewMatcher(t, name.text, value.text)
+	if err != nil {
+		return series.Matcher{}, parseError(value.pos, err.Error())
+	}
+	return m, nil
+}
+
+func (p *parser) parseCall(name token) (Expr, error) {
+	f, ok := functions[name.text]
+	if !ok {
+		return nil, parseError(name.pos, "unknown function "+name.text)
+	}
+	p.next()
+
+	call := &Call{Func: name.text}
+	for p.peek().kind != tokRParen {
+		arg, err := p.parseBinary(1)
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+		if p.peek().kind != tokComma {
+			break
+		}
+		p.next()
+	}
+	if _, err := p.expect(tokRParen, `")"`); err != nil {
+		return nil, err
+	}
+
+	if len(call.Args) != len(f.args) {
+		return nil, parseError(name.pos, fmt.Sprintf("function %s expects %d argument(s), got %d", name.text, len(f.args), len(call.Args)))
+	}
+	for i, arg := range call.Args {
+		if arg.Type() != f.args[i] {
+			return nil, parseError(name.pos, fmt.Sprintf("function %s expects %s argument, got %s %s", name.text, f.args[i], arg.Type(), arg))
+		}
+	}
+	return call, nil
+}
+
+// parseAggregate разбирает агрегацию; by или without можно указать
+// как перед аргументом, так и после него.
+func (p *parser) parseAggregate(op token) (Expr, error) {
+	agg := &AggregateExpr{Op: op.text}
+	grouping := false
+	if p.peek().kind == tokIdent {
+		if err := p.parseGrouping(agg); err != nil {
+			return nil, err
+		}
+		grouping = true
+	}
+
+	if _, err := p.expect(tokLParen, `"("`); err != nil {
+		return nil, err
+	}
+	expr, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokRParen, `")"`); err != nil {
+		return nil, err
+	}
+	if expr.Type() != ValueVector {
+		return nil, parseError(op.pos, fmt.Sprintf("%s expects instant vector, got %s %s", op.text, expr.Type(), expr))
+	}
+	agg.Expr = expr
+
+	if t := p.peek(); !grouping && t.kind == tokIdent && (t.text == "by" || t.text == "without") {
+		if err := p.parseGrouping(agg); err != nil {
+			return nil, err
+		}
+	}
+	return agg, nil
+}
+
+func (p *parser) parseGrouping(agg *AggregateExpr) error {
+	agg.Without = p.next().text == "without"
+	if _, err := p.expect(tokLParen, `"("`); err != nil {
+		return err
+	}
+	for p.peek().kind != tokRParen {
+		l, err := p.expect(tokIdent, "label name")
+		if err != nil {
+			return err
+		}
+		agg.Grouping = append(agg.Grouping, l.text)
+		if p.peek().kind != tokComma {
+			break
+		}
+		p.next()
+	}
+	_, err := p.expect(tokRParen, `")"`)
+	return err
+}
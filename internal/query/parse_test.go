@@ -0,0 +1,69 @@
+package query
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name    string
+		input   string
+		want    string
+		typ     ValueType
+		wantErr bool
+	}{
+		{name: "Parse() Test 1", input: `HeapAlloc`, want: `HeapAlloc`, typ: ValueVector},
+		{name: "Parse() Test 2", input: `sum by (host) (HeapAlloc)`, want: `sum by (host) (HeapAlloc)`, typ: ValueVector},
+		{name: "Parse() Test 3", input: `sum(rate(PollCount{job="agent", host=~"web.*"}[5m])) without (host)`,
+			want: `sum without (host) (rate(PollCount{job="agent",host=~"web.*"}[5m]))`, typ: ValueVector},
+		{name: "Parse() Test 4", input: `1 + 2 * 3 ^ 2 ^ 0.5`, want: `(1 + (2 * (3 ^ (2 ^ 0.5))))`, typ: ValueScalar},
+		{name: "Parse() Test 5", input: `-2 ^ 2`, want: `-(2 ^ 2)`, typ: ValueScalar},
+		{name: "Parse() Test 6", input: `(a - b) / 1e3 > 0.5`, want: `(((a - b) / 1000) > 0.5)`, typ: ValueVector},
+		{name: "Parse() Test 7", input: `{__name__=~"Heap.*"}[1h30m]`, want: `{__name__=~"Heap.*"}[1h30m]`, typ: ValueMatrix},
+		{name: "Parse() Test 8", input: "max_over_time(Alloc{path=`C:\\tmp`}[1d])", want: `max_over_time(Alloc{path="C:\\tmp"}[1d])`, typ: ValueVector},
+		{name: "Parse() Test 9", input: `scalar(count(up)) * time()`, want: `(scalar(count (up)) * time())`, typ: ValueScalar},
+		{name: "Parse() Test 10", input: `sum by (host,) (-x)`, want: `sum by (host) (-x)`, typ: ValueVector},
+		{name: "Parse() Test 11", input: `HeapAlloc[5m] + 1`, wantErr: true},
+		{name: "Parse() Test 12", input: `rate(PollCount)`, wantErr: true},
+		{name: "Parse() Test 13", input: `median(x)`, wantErr: true},
+		{name: "Parse() Test 14", input: `sum(x[5m])`, wantErr: true},
+		{name: "Parse() Test 15", input: `x{host="a"`, wantErr: true},
+		{name: "Parse() Test 16", input: `x{host=a}`, wantErr: true},
+		{name: "Parse() Test 17", input: `x[5x]`, wantErr: true},
+		{name: "Parse() Test 18", input: `{}`, wantErr: true},
+		{name: "Parse() Test 19", input: `(1 + 2`, wantErr: true},
+		{name: "Parse() Test 20", input: `x y`, wantErr: true},
+		{name: "Parse() Test 21", input: `x # 2`, wantErr: true},
+		{name: "Parse() Test 22", input: `abs(x, y)`, wantErr: true},
+		{name: "Parse() Test 23", input: `x{host=~"("}`, wantErr: true},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			expr, err := Parse(test.input)
+			if test.wantErr {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, ErrBadQuery), err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, expr.String())
+			assert.Equal(t, test.typ, expr.Type())
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	for input, want := range map[string]string{"5m": "5m0s", "1h30m": "1h30m0s", "1d": "24h0m0s", "1w": "168h0m0s", "250ms": "250ms"} {
+		d, err := parseDuration(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, want, d.String(), input)
+	}
+	for _, input := range []string{"", "5", "m", "5y", "1.5h"} {
+		_, err := parseDuration(input)
+		assert.Error(t, err, input)
+	}
+}
@@ -0,0 +1,284 @@
+// Package query вычисляет значения серий из истории на сетке моментов
+// с заданным шагом.
+package query
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/series"
+	"github.com/amidvn/go-metrics/internal/storage"
+)
+
+// Функции агрегации значений внутри шага.
+const (
+	FuncAvg        = "avg"
+	FuncMin        = "min"
+	FuncMax        = "max"
+	FuncSum        = "sum"
+	FuncLast       = "last"
+	FuncRate       = "rate"
+	FuncPercentile = "percentile"
+)
+
+// MaxPoints ограничивает число точек одной серии в ответе.
+const MaxPoints = 11000
+
+// RangeQuery — запрос значений серий за интервал.
+type RangeQuery struct {
+	// Selectors — селекторы серий; серия попадает в ответ, если
+	// подходит хотя бы под один
+	Selectors []series.Selector
+	// Type — gauge или counter; пустой — оба типа
+	Type  string
+	Start time.Time
+	End   time.Time
+	Step  time.Duration
+	// Func — функция агрегации, по умолчанию avg
+	Func string
+	// Percentile — процентиль в (0, 100] для функции percentile
+	Percentile float64
+}
+
+// Point — значение серии на момент Time.
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Series — результат запроса для одной серии.
+type Series struct {
+	Key    string
+	Name   string
+	Type   string
+	Labels []series.Label
+	Points []Point
+}
+
+// Validate проверяет параметры запроса и подставляет функцию по
+// умолчанию.
+func (q *RangeQuery) Validate() error {
+	if len(q.Selectors) == 0 {
+		return fmt.Errorf("at least one series selector is required")
+	}
+	switch q.Type {
+	case "", "gauge", "counter":
+	default:
+		return fmt.Errorf("invalid type %q, can only be 'gauge' or 'counter'", q.Type)
+	}
+	if q.Step <= 0 {
+		return fmt.Errorf("step must be positive")
+	}
+	if q.Start.After(q.End) {
+		return fmt.Errorf("start must not be after end")
+	}
+	if n := q.End.Sub(align(q.Start, q.Step)) / q.Step; n >= MaxPoints {
+		return fmt.Errorf("too many points per series, at most %d allowed; increase step", MaxPoints)
+	}
+	switch q.Func {
+	case "":
+		q.Func = FuncAvg
+	case FuncAvg, FuncMin, FuncMax, FuncSum, FuncLast, FuncRate:
+	case FuncPercentile:
+		if !(q.Percentile > 0 && q.Percentile <= 100) {
+			return fmt.Errorf("percentile must be in (0, 100]")
+		}
+	default:
+		return fmt.Errorf("unknown function %q", q.Func)
+	}
+	return nil
+}
+
+// Range вычисляет значения серий, подходящих под запрос. Моменты точек
+// кратны шагу, считая от начала эпохи; точка в момент t агрегирует
+// значения из истории в интервале (t-step, t]. Шаги без значений
+// пропускаются, серии без точек в ответ не попадают. Query должен
+// пройти Validate.
+func Range(ctx context.Context, s storage.Storage, h storage.History, q RangeQuery) ([]Series, error) {
+	matched, err := match(ctx, s, q.Selectors, q.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	start := align(q.Start, q.Step)
+	// для rate нужно последнее значение перед первым шагом
+	from := start.Add(-2 * q.Step)
+
+	var result []Series
+	for _, sr := range matched {
+		samples, err := h.Range(ctx, sr.Key, from, q.End)
+		if err != nil {
+			return nil, err
+		}
+		n := 0
+		for _, smp := range samples {
+			if smp.MType == sr.Type {
+				samples[n] = smp
+				n++
+			}
+		}
+		samples = samples[:n]
+		sort.SliceStable(samples, func(i, j int) bool { return samples[i].Timestamp.Before(samples[j].Timestamp) })
+
+		sr.Points = evaluate(samples, start, q, sr.Type == "counter")
+		if len(sr.Points) != 0 {
+			result = append(result, sr)
+		}
+	}
+	return result, nil
+}
+
+// match отбирает серии хранилища по селекторам и типу в порядке
+// ключей. Points каждой серии содержит её текущее значение.
+func match(ctx context.Context, s storage.Storage, selectors []series.Selector, mtype string) ([]Series, error) {
+	counters, err := s.Counters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	gauges, err := s.Gauges(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var matched []Series
+	add := func(t, key string, v float64) {
+		if mtype != "" && mtype != t {
+			return
+		}
+		k, err := series.Parse(key)
+		if err != nil {
+			k = series.Key{Name: key}
+		}
+		for _, sel := range selectors {
+			if sel.Matches(k) {
+				matched = append(matched, Series{Key: key, Name: k.Name, Type: t, Labels: k.Labels, Points: []Point{{Time: now, Value: v}}})
+				return
+			}
+		}
+	}
+	for key, v := range counters {
+		add("counter", key, float64(v))
+	}
+	for key, v := range gauges {
+		add("gauge", key, v)
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].Key != matched[j].Key {
+			return matched[i].Key < matched[j].Key
+		}
+		return matched[i].Type < matched[j].Type
+	})
+	return matched, nil
+}
+
+// evaluate вычисляет точки серии по значениям, упорядоченным по времени.
+func evaluate(samples []storage.Sample, start time.Time, q RangeQuery, counter bool) []Point {
+	var points []Point
+	lo := 0
+	values := make([]float64, 0, 16)
+	for t := start; !t.After(q.End); t = t.Add(q.Step) {
+		from := t.Add(-q.Step)
+		for lo < len(samples) && !samples[lo].Timestamp.After(from) {
+			lo++
+		}
+		values = values[:0]
+		if q.Func == FuncRate && lo > 0 && samples[lo-1].Timestamp.After(from.Add(-q.Step)) {
+			values = append(values, samples[lo-1].Value)
+		}
+		for i := lo; i < len(samples) && !samples[i].Timestamp.After(t); i++ {
+			values = append(values, samples[i].Value)
+		}
+
+		if v, ok := aggregate(values, q, counter); ok {
+			points = append(points, Point{Time: t, Value: v})
+		}
+	}
+	return points
+}
+
+// aggregate применяет функцию запроса к значениям шага. Для rate
+// первым идёт значение из предыдущего шага, если оно есть.
+func aggregate(values []float64, q RangeQuery, counter bool) (float64, bool) {
+	if len(values) == 0 {
+		return 0, false
+	}
+	switch q.Func {
+	case FuncMin:
+		v := values[0]
+		for _, x := range values[1:] {
+			v = math.Min(v, x)
+		}
+		return v, true
+	case FuncMax:
+		v := values[0]
+		for _, x := range values[1:] {
+			v = math.Max(v, x)
+		}
+		return v, true
+	case FuncSum:
+		return sum(values), true
+	case FuncLast:
+		return values[len(values)-1], true
+	case FuncRate:
+		if len(values) < 2 {
+			return 0, false
+		}
+		return increase(values, counter) / q.Step.Seconds(), true
+	case FuncPercentile:
+		return percentile(values, q.Percentile), true
+	}
+	return sum(values) / float64(len(values)), true
+}
+
+func sum(values []float64) float64 {
+	var s float64
+	for _, v := range values {
+		s += v
+	}
+	return s
+}
+
+// increase возвращает прирост значений. Уменьшение счётчика считается
+// сбросом: прирост после него равен новому значению целиком. Для gauge
+// прирост — разность последнего и первого значений.
+func increase(values []float64, counter bool) float64 {
+	if !counter {
+		return values[len(values)-1] - values[0]
+	}
+	var inc float64
+	for i := 1; i < len(values); i++ {
+		if d := values[i] - values[i-1]; d >= 0 {
+			inc += d
+		} else {
+			inc += values[i]
+		}
+	}
+	return inc
+}
+
+// percentile вычисляет процентиль p с линейной интерполяцией между
+// соседними значениями. Порядок values меняется.
+func percentile(values []float64, p float64) float64 {
+	sort.Float64s(values)
+	rank := p / 100 * float64(len(values)-1)
+	i := int(rank)
+	if i+1 >= len(values) {
+		return values[len(values)-1]
+	}
+	frac := rank - float64(i)
+	return values[i] + (values[i+1]-values[i])*frac
+}
+
+// align округляет t вниз до момента, кратного step.
+func align(t time.Time, step time.Duration) time.Time {
+	ns := t.UnixNano()
+	rem := ns % int64(step)
+	if rem < 0 {
+		rem += int64(step)
+	}
+	return time.Unix(0, ns-rem)
+}
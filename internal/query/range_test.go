@@ -0,0 +1,135 @@
+package query
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/series"
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeHistory []storage.Sample
+
+func (h fakeHistory) Append(ctx context.Context, samples ...storage.Sample) error { return nil }
+
+func (h fakeHistory) Range(ctx context.Context, name string, from, to time.Time) ([]storage.Sample, error) {
+	var result []storage.Sample
+	for _, smp := range h {
+		if smp.Name == name && !smp.Timestamp.Before(from) && !smp.Timestamp.After(to) {
+			result = append(result, smp)
+		}
+	}
+	return result, nil
+}
+
+func TestRange(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	at := func(sec int) time.Time { return base.Add(time.Duration(sec) * time.Second) }
+
+	s := storage.New(0, "", false)
+	s.UpdateGauge(ctx, `cpu{host="a"}`, 0)
+	s.UpdateGauge(ctx, `cpu{host="b"}`, 0)
+	s.UpdateCounter(ctx, "requests", 0)
+
+	h := fakeHistory{
+		{Name: `cpu{host="a"}`, MType: "gauge", Timestamp: at(10), Value: 1},
+		{Name: `cpu{host="a"}`, MType: "gauge", Timestamp: at(50), Value: 3},
+		{Name: `cpu{host="a"}`, MType: "gauge", Timestamp: at(60), Value: 8},
+		{Name: `cpu{host="a"}`, MType: "gauge", Timestamp: at(90), Value: 4},
+		{Name: `cpu{host="b"}`, MType: "gauge", Timestamp: at(30), Value: 2},
+		{Name: "requests", MType: "counter", Timestamp: at(-30), Value: 10},
+		{Name: "requests", MType: "counter", Timestamp: at(30), Value: 40},
+		{Name: "requests", MType: "counter", Timestamp: at(90), Value: 70},
+		{Name: "requests", MType: "counter", Timestamp: at(100), Value: 20},
+		{Name: "requests", MType: "gauge", Timestamp: at(30), Value: 1000},
+	}
+	cpuA := series.Label{Name: "host", Value: "a"}
+
+	testCases := []struct {
+		name string
+		sel  string
+		fn   string
+		p    float64
+		want map[string][]float64
+	}{
+		{name: "Range() Test 1", sel: `cpu{host="a"}`, fn: FuncAvg, want: map[string][]float64{`cpu{host="a"}`: {4, 4}}},
+		{name: "Range() Test 2", sel: `cpu{host="a"}`, fn: FuncMin, want: map[string][]float64{`cpu{host="a"}`: {1, 4}}},
+		{name: "Range() Test 3", sel: `cpu{host="a"}`, fn: FuncMax, want: map[string][]float64{`cpu{host="a"}`: {8, 4}}},
+		{name: "Range() Test 4", sel: `cpu`, fn: FuncSum, want: map[string][]float64{`cpu{host="a"}`: {12, 4}, `cpu{host="b"}`: {2}}},
+		{name: "Range() Test 5", sel: `cpu{host="a"}`, fn: FuncLast, want: map[string][]float64{`cpu{host="a"}`: {8, 4}}},
+		{name: "Range() Test 6", sel: `cpu{host="a"}`, fn: FuncPercentile, p: 50, want: map[string][]float64{`cpu{host="a"}`: {3, 4}}},
+		{name: "Range() Test 7", sel: `cpu{host="a"}`, fn: FuncPercentile, p: 75, want: map[string][]float64{`cpu{host="a"}`: {5.5, 4}}},
+		{name: "Range() Test 8", sel: `requests`, fn: FuncRate, want: map[string][]float64{"requests": {0.5, 50.0 / 60}}},
+		{name: "Range() Test 9", sel: `cpu`, fn: FuncRate, want: map[string][]float64{`cpu{host="a"}`: {7.0 / 60, -4.0 / 60}}},
+		{name: "Range() Test 10", sel: `memory`, fn: FuncAvg, want: map[string][]float64{}},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			sel, err := series.ParseSelector(test.sel)
+			require.NoError(t, err)
+			q := RangeQuery{
+				Selectors:  []series.Selector{sel},
+				Start:      at(30),
+				End:        at(120),
+				Step:       time.Minute,
+				Func:       test.fn,
+				Percentile: test.p,
+			}
+			require.NoError(t, q.Validate())
+
+			result, err := Range(ctx, s, h, q)
+			require.NoError(t, err)
+			got := make(map[string][]float64)
+			for _, sr := range result {
+				var values []float64
+				for i, p := range sr.Points {
+					assert.Equal(t, at(60*(i+1)).Unix(), p.Time.Unix())
+					values = append(values, p.Value)
+				}
+				got[sr.Key] = values
+				if sr.Key == `cpu{host="a"}` {
+					assert.Equal(t, []series.Label{cpuA}, sr.Labels)
+				}
+			}
+			for key, values := range test.want {
+				assert.InDeltaSlice(t, values, got[key], 1e-9, key)
+			}
+			assert.Len(t, got, len(test.want))
+		})
+	}
+}
+
+func TestRangeQueryValidate(t *testing.T) {
+	sel := []series.Selector{{Name: "cpu"}}
+	now := time.Now()
+
+	testCases := []struct {
+		name    string
+		query   RangeQuery
+		wantErr bool
+	}{
+		{name: "RangeQuery.Validate() Test 1", query: RangeQuery{Selectors: sel, Start: now.Add(-time.Hour), End: now, Step: time.Minute}},
+		{name: "RangeQuery.Validate() Test 2", query: RangeQuery{Start: now, End: now, Step: time.Minute}, wantErr: true},
+		{name: "RangeQuery.Validate() Test 3", query: RangeQuery{Selectors: sel, Start: now, End: now, Step: 0}, wantErr: true},
+		{name: "RangeQuery.Validate() Test 4", query: RangeQuery{Selectors: sel, Start: now, End: now.Add(-time.Second), Step: time.Minute}, wantErr: true},
+		{name: "RangeQuery.Validate() Test 5", query: RangeQuery{Selectors: sel, Start: now.Add(-24 * time.Hour), End: now, Step: time.Second}, wantErr: true},
+		{name: "RangeQuery.Validate() Test 6", query: RangeQuery{Selectors: sel, Start: now, End: now, Step: time.Minute, Func: "median"}, wantErr: true},
+		{name: "RangeQuery.Validate() Test 7", query: RangeQuery{Selectors: sel, Start: now, End: now, Step: time.Minute, Func: FuncPercentile}, wantErr: true},
+		{name: "RangeQuery.Validate() Test 8", query: RangeQuery{Selectors: sel, Start: now, End: now, Step: time.Minute, Type: "histogram"}, wantErr: true},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.query.Validate()
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, FuncAvg, test.query.Func)
+		})
+	}
+}
@@ -0,0 +1,252 @@
+// Package remotewrite разбирает запросы Prometheus remote_write:
+// сообщение WriteRequest в формате protobuf, сжатое snappy. Схема
+// сообщения небольшая, поэтому оно разбирается вручную, без
+// сгенерированного кода.
+package remotewrite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/amidvn/go-metrics/internal/models"
+	"github.com/amidvn/go-metrics/internal/series"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// StaleNaN — значение, которым Prometheus помечает исчезнувшие серии.
+const StaleNaN uint64 = 0x7ff0000000000002
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+type Sample struct {
+	Value float64
+	// Timestamp — миллисекунды Unix
+	Timestamp int64
+}
+
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+type WriteRequest struct {
+	Timeseries []TimeSeries
+}
+
+// IsStale сообщает, является ли значение маркером исчезнувшей серии.
+func IsStale(v float64) bool {
+	return math.Float64bits(v) == StaleNaN
+}
+
+// Metrics превращает серии запроса в пакет обновлений gauge. Серия
+// хранит последнее значение из запроса, её ключ составляется из
+// __name__ и остальных меток.
+//
+// remote_write передаёт накопленные значения счётчиков, а счётчики
+// хранилища складывают целые приращения, поэтому все серии сохраняются
+// как gauge: так значения остаются такими же, как в источнике, в том
+// числе дробные. Серии без имени, маркеры исчезновения и другие NaN и
+// бесконечности пропускаются: хранилище и его снапшоты в JSON
+// принимают только конечные значения.
+//
+// Более старые значения серии отбрасываются и в историю не попадают,
+// а последнее записывается в историю с временем приёма запроса, а не
+// с меткой времени из запроса.
+func (req WriteRequest) Metrics() []models.Metrics {
+	metrics := make([]models.Metrics, 0, len(req.Timeseries))
+	for _, ts := range req.Timeseries {
+		var name string
+		labels := make(map[string]string, len(ts.Labels))
+		for _, l := range ts.Labels {
+			if l.Name == "__name__" {
+				name = l.Value
+			} else if l.Value != "" {
+				labels[l.Name] = l.Value
+			}
+		}
+		if name == "" {
+			continue
+		}
+
+		last := -1
+		for i, s := range ts.Samples {
+			if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+				continue
+			}
+			if last < 0 || s.Timestamp >= ts.Samples[last].Timestamp {
+				last = i
+			}
+		}
+		if last < 0 {
+			continue
+		}
+		value := ts.Samples[last].Value
+		metrics = append(metrics, models.Metrics{ID: series.New(name, labels).String(), MType: "gauge", Value: &value})
+	}
+	return metrics
+}
+
+// ErrTooLarge — распакованное сообщение длиннее допустимого.
+var ErrTooLarge = errors.New("decoded message is too large")
+
+// Decode распаковывает и разбирает тело запроса remote_write. Длина
+// распакованного сообщения берётся из заголовка snappy и проверяется
+// до выделения памяти: сообщения длиннее maxLen отклоняются с
+// ErrTooLarge.
+func Decode(body []byte, maxLen int) (WriteRequest, error) {
+	n, err := snappy.DecodedLen(body)
+	if err != nil {
+		return WriteRequest{}, fmt.Errorf("snappy: %w", err)
+	}
+	if n > maxLen {
+		return WriteRequest{}, fmt.Errorf("snappy: %w: %d bytes", ErrTooLarge, n)
+	}
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		return WriteRequest{}, fmt.Errorf("snappy: %w", err)
+	}
+	return Unmarshal(data)
+}
+
+// Unmarshal разбирает несжатое сообщение WriteRequest. Из него берутся
+// только серии: метаданные и другие поля пропускаются.
+func Unmarshal(data []byte) (WriteRequest, error) {
+	var req WriteRequest
+	err := walk(data, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			ts, err := unmarshalTimeSeries(v)
+			if err != nil {
+				return fmt.Errorf("timeseries: %w", err)
+			}
+			req.Timeseries = append(req.Timeseries, ts)
+		}
+		return nil
+	})
+	return req, err
+}
+
+func unmarshalTimeSeries(data []byte) (TimeSeries, error) {
+	var ts TimeSeries
+	err := walk(data, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			l, err := unmarshalLabel(v)
+			if err != nil {
+				return err
+			}
+			ts.Labels = append(ts.Labels, l)
+		case num == 2 && typ == protowire.BytesType:
+			s, err := unmarshalSample(v)
+			if err != nil {
+				return err
+			}
+			ts.Samples = append(ts.Samples, s)
+		}
+		return nil
+	})
+	return ts, err
+}
+
+func unmarshalLabel(data []byte) (Label, error) {
+	var l Label
+	err := walk(data, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			l.Name = string(v)
+		case num == 2 && typ == protowire.BytesType:
+			l.Value = string(v)
+		}
+		return nil
+	})
+	return l, err
+}
+
+func unmarshalSample(data []byte) (Sample, error) {
+	var s Sample
+	err := walk(data, func(num protowire.Number, typ protowire.Type, _ []byte, n uint64) error {
+		switch {
+		case num == 1 && typ == protowire.Fixed64Type:
+			s.Value = math.Float64frombits(n)
+		case num == 2 && typ == protowire.VarintType:
+			s.Timestamp = int64(n)
+		}
+		return nil
+	})
+	return s, err
+}
+
+// walk перебирает поля сообщения и передаёт в fn номер и тип поля,
+// а также его содержимое: байты для BytesType или число для остальных
+// типов.
+func walk(data []byte, fn func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var (
+			bytes []byte
+			value uint64
+		)
+		switch typ {
+		case protowire.VarintType:
+			value, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			value, n = protowire.ConsumeFixed64(data)
+		case protowire.Fixed32Type:
+			var v32 uint32
+			v32, n = protowire.ConsumeFixed32(data)
+			value = uint64(v32)
+		case protowire.BytesType:
+			bytes, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if err := fn(num, typ, bytes, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Encode сериализует и сжимает запрос, как это делает Prometheus.
+func Encode(req WriteRequest) []byte {
+	var b []byte
+	for _, ts := range req.Timeseries {
+		var tsb []byte
+		for _, l := range ts.Labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.Name)
+			lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.Value)
+			tsb = protowire.AppendTag(tsb, 1, protowire.BytesType)
+			tsb = protowire.AppendBytes(tsb, lb)
+		}
+		for _, s := range ts.Samples {
+			var sb []byte
+			sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+			sb = protowire.AppendFixed64(sb, math.Float64bits(s.Value))
+			sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+			sb = protowire.AppendVarint(sb, uint64(s.Timestamp))
+			tsb = protowire.AppendTag(tsb, 2, protowire.BytesType)
+			tsb = protowire.AppendBytes(tsb, sb)
+		}
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, tsb)
+	}
+	return snappy.Encode(nil, b)
+}
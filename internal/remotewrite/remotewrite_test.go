@@ -0,0 +1,73 @@
+package remotewrite
+
+import (
+	"math"
+	"testing"
+
+	"github.com/amidvn/go-metrics/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestDecode(t *testing.T) {
+	req := WriteRequest{
+		Timeseries: []TimeSeries{
+			{
+				Labels:  []Label{{"__name__", "http_requests_total"}, {"job", "api"}, {"code", "200"}},
+				Samples: []Sample{{Value: 10, Timestamp: 2000}, {Value: 7, Timestamp: 1000}},
+			},
+			{
+				Labels:  []Label{{"__name__", "up"}, {"job", "api"}, {"empty", ""}},
+				Samples: []Sample{{Value: 1, Timestamp: 1000}, {Value: math.Float64frombits(StaleNaN), Timestamp: 2000}},
+			},
+			{
+				Labels:  []Label{{"__name__", "gone"}},
+				Samples: []Sample{{Value: math.Float64frombits(StaleNaN), Timestamp: 2000}},
+			},
+			{
+				Labels:  []Label{{"job", "api"}},
+				Samples: []Sample{{Value: 1, Timestamp: 1000}},
+			},
+			{
+				Labels:  []Label{{"__name__", "ratio"}},
+				Samples: []Sample{{Value: 0.5, Timestamp: 1000}, {Value: math.NaN(), Timestamp: 2000}, {Value: math.Inf(1), Timestamp: 3000}},
+			},
+			{
+				Labels:  []Label{{"__name__", "broken"}},
+				Samples: []Sample{{Value: math.Inf(-1), Timestamp: 1000}},
+			},
+		},
+	}
+
+	decoded, err := Decode(Encode(req), 1<<20)
+	require.NoError(t, err)
+	require.Len(t, decoded.Timeseries, len(req.Timeseries))
+	assert.Equal(t, req.Timeseries[0], decoded.Timeseries[0])
+	assert.Equal(t, req.Timeseries[1].Labels, decoded.Timeseries[1].Labels)
+	assert.True(t, IsStale(decoded.Timeseries[2].Samples[0].Value))
+
+	ten, one, half := 10.0, 1.0, 0.5
+	assert.Equal(t, []models.Metrics{
+		{ID: `http_requests_total{code="200",job="api"}`, MType: "gauge", Value: &ten},
+		{ID: `up{job="api"}`, MType: "gauge", Value: &one},
+		{ID: "ratio", MType: "gauge", Value: &half},
+	}, decoded.Metrics())
+
+	_, err = Decode([]byte("not snappy"), 1<<20)
+	assert.Error(t, err)
+	// заголовок объявляет 4 ГиБ распакованных данных
+	_, err = Decode([]byte{0xff, 0xff, 0xff, 0xff, 0x0f}, 1<<20)
+	assert.ErrorIs(t, err, ErrTooLarge)
+	_, err = Decode(Encode(req), 16)
+	assert.ErrorIs(t, err, ErrTooLarge)
+	_, err = Unmarshal([]byte{0x0a, 0x05, 0x01})
+	assert.Error(t, err)
+
+	// метаданные (поле 3) пропускаются
+	md := protowire.AppendTag(nil, 3, protowire.BytesType)
+	md = protowire.AppendBytes(md, []byte{0x08, 0x01, 0x12, 0x02, 'u', 'p'})
+	decoded, err = Unmarshal(md)
+	require.NoError(t, err)
+	assert.Empty(t, decoded.Timeseries)
+}
@@ -0,0 +1,83 @@
+package series
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type MatchType int
+
+const (
+	MatchEqual MatchType = iota
+	MatchNotEqual
+	MatchRegexp
+	MatchNotRegexp
+)
+
+var matchOperators = map[MatchType]string{
+	MatchEqual:     "=",
+	MatchNotEqual:  "!=",
+	MatchRegexp:    "=~",
+	MatchNotRegexp: "!~",
+}
+
+// Matcher проверяет значение метки. Отсутствующая метка считается
+// пустой, как в Prometheus.
+type Matcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+	re    *regexp.Regexp
+}
+
+// NewMatcher создаёт условие. Регулярное выражение должно совпасть со
+// значением целиком.
+func NewMatcher(t MatchType, name, value string) (Matcher, error) {
+	m := Matcher{Name: name, Type: t, Value: value}
+	if t == MatchRegexp || t == MatchNotRegexp {
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return Matcher{}, fmt.Errorf("label %s: %w", name, err)
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+// ParseMatcher разбирает условие вида name="value", name!="value",
+// name=~"regexp" или name!~"regexp".
+func ParseMatcher(s string) (Matcher, error) {
+	m, n, err := scanMatcher(s)
+	if err != nil {
+		return Matcher{}, fmt.Errorf("matcher %q: %w", s, err)
+	}
+	if strings.TrimSpace(s[n:]) != "" {
+		return Matcher{}, fmt.Errorf("matcher %q: unexpected %q after value", s, s[n:])
+	}
+	return m, nil
+}
+
+// Matches сообщает, подходит ли значение метки под условие. Условие
+// на метку __name__ проверяет имя метрики.
+func (m Matcher) Matches(k Key) bool {
+	v, _ := k.Label(m.Name)
+	if m.Name == "__name__" {
+		v = k.Name
+	}
+	switch m.Type {
+	case MatchEqual:
+		return v == m.Value
+	case MatchNotEqual:
+		return v != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(v)
+	case MatchNotRegexp:
+		return !m.re.MatchString(v)
+	}
+	return false
+}
+
+func (m Matcher) String() string {
+	return m.Name + matchOperators[m.Type] + `"` + EscapeValue(m.Value) + `"`
+}
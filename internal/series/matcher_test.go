@@ -0,0 +1,42 @@
+package series
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMatcher(t *testing.T) {
+	k, err := Parse(`http_requests{host="web1",method="GET"}`)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name    string
+		matcher string
+		want    bool
+		wantErr bool
+	}{
+		{name: "ParseMatcher() Test 1", matcher: `host="web1"`, want: true},
+		{name: "ParseMatcher() Test 2", matcher: `host != "web1"`, want: false},
+		{name: "ParseMatcher() Test 3", matcher: `host=~"web.*"`, want: true},
+		{name: "ParseMatcher() Test 4", matcher: `host=~"web"`, want: false},
+		{name: "ParseMatcher() Test 5", matcher: `method!~"POST|PUT"`, want: true},
+		{name: "ParseMatcher() Test 6", matcher: `zone=""`, want: true},
+		{name: "ParseMatcher() Test 7", matcher: `host=web1`, wantErr: true},
+		{name: "ParseMatcher() Test 8", matcher: `="web1"`, wantErr: true},
+		{name: "ParseMatcher() Test 9", matcher: `host=~"("`, wantErr: true},
+		{name: "ParseMatcher() Test 10", matcher: `host="a" x`, wantErr: true},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := ParseMatcher(test.matcher)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, m.Matches(k))
+		})
+	}
+}
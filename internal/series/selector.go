@@ -0,0 +1,117 @@
+package series
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Selector выбирает серии по имени и условиям на метки:
+//
+//	name{label="value",label=~"regexp",...}
+//
+// Имя можно не указывать, если задано хотя бы одно условие.
+type Selector struct {
+	Name     string
+	Matchers []Matcher
+}
+
+// ParseSelector разбирает селектор серий.
+func ParseSelector(s string) (Selector, error) {
+	s = strings.TrimSpace(s)
+	open := strings.IndexByte(s, '{')
+	if open < 0 {
+		if s == "" {
+			return Selector{}, fmt.Errorf("empty selector")
+		}
+		return Selector{Name: s}, nil
+	}
+
+	sel := Selector{Name: strings.TrimSpace(s[:open])}
+	rest := s[open+1:]
+	for {
+		rest = strings.TrimLeft(rest, " ")
+		if strings.HasPrefix(rest, "}") {
+			rest = rest[1:]
+			break
+		}
+
+		m, n, err := scanMatcher(rest)
+		if err != nil {
+			return Selector{}, fmt.Errorf("selector %q: %w", s, err)
+		}
+		sel.Matchers = append(sel.Matchers, m)
+
+		rest = strings.TrimLeft(rest[n:], " ")
+		switch {
+		case strings.HasPrefix(rest, ","):
+			rest = rest[1:]
+		case strings.HasPrefix(rest, "}"):
+		default:
+			return Selector{}, fmt.Errorf("selector %q: expected ',' or '}' after %s", s, m)
+		}
+	}
+	if strings.TrimSpace(rest) != "" {
+		return Selector{}, fmt.Errorf("selector %q: unexpected %q after labels", s, rest)
+	}
+	if sel.Name == "" && len(sel.Matchers) == 0 {
+		return Selector{}, fmt.Errorf("selector %q: name or label matcher required", s)
+	}
+	return sel, nil
+}
+
+// scanMatcher читает условие в начале s и возвращает число
+// прочитанных байт.
+func scanMatcher(s string) (Matcher, int, error) {
+	i := strings.IndexAny(s, "=!")
+	if i <= 0 {
+		return Matcher{}, 0, fmt.Errorf("expected label matcher")
+	}
+	name := strings.TrimSpace(s[:i])
+
+	var t MatchType
+	switch rest := s[i:]; {
+	case strings.HasPrefix(rest, "=~"):
+		t = MatchRegexp
+	case strings.HasPrefix(rest, "!~"):
+		t = MatchNotRegexp
+	case strings.HasPrefix(rest, "!="):
+		t = MatchNotEqual
+	case strings.HasPrefix(rest, "="):
+		t = MatchEqual
+	default:
+		return Matcher{}, 0, fmt.Errorf("label %s: invalid operator", name)
+	}
+	pos := i + len(matchOperators[t])
+	pos += len(s[pos:]) - len(strings.TrimLeft(s[pos:], " "))
+
+	value, n, err := unquote(s[pos:])
+	if err != nil {
+		return Matcher{}, 0, fmt.Errorf("label %s: %w", name, err)
+	}
+	m, err := NewMatcher(t, name, value)
+	return m, pos + n, err
+}
+
+// Matches сообщает, подходит ли серия под селектор.
+func (s Selector) Matches(k Key) bool {
+	if s.Name != "" && s.Name != k.Name {
+		return false
+	}
+	for _, m := range s.Matchers {
+		if !m.Matches(k) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s Selector) String() string {
+	if len(s.Matchers) == 0 {
+		return s.Name
+	}
+	parts := make([]string, 0, len(s.Matchers))
+	for _, m := range s.Matchers {
+		parts = append(parts, m.String())
+	}
+	return s.Name + "{" + strings.Join(parts, ",") + "}"
+}
@@ -0,0 +1,43 @@
+package series
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSelector(t *testing.T) {
+	k, err := Parse(`http_requests{host="web1",method="GET"}`)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		selector string
+		want     bool
+		wantErr  bool
+	}{
+		{name: "ParseSelector() Test 1", selector: `http_requests`, want: true},
+		{name: "ParseSelector() Test 2", selector: `http_requests{host="web1", method=~"GET|POST"}`, want: true},
+		{name: "ParseSelector() Test 3", selector: `http_requests{host!="web1"}`, want: false},
+		{name: "ParseSelector() Test 4", selector: `{__name__=~"http_.*"}`, want: true},
+		{name: "ParseSelector() Test 5", selector: `{host="a,b}"}`, want: false},
+		{name: "ParseSelector() Test 6", selector: `Alloc{}`, want: false},
+		{name: "ParseSelector() Test 7", selector: ``, wantErr: true},
+		{name: "ParseSelector() Test 8", selector: `{}`, wantErr: true},
+		{name: "ParseSelector() Test 9", selector: `http_requests{host="web1"`, wantErr: true},
+		{name: "ParseSelector() Test 10", selector: `http_requests{host="web1"} x`, wantErr: true},
+		{name: "ParseSelector() Test 11", selector: `http_requests{host="web1" method="GET"}`, wantErr: true},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			sel, err := ParseSelector(test.selector)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, sel.Matches(k))
+		})
+	}
+}
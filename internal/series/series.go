@@ -0,0 +1,159 @@
+// Package series описывает ключи серий с метками в формате
+// name{label="value",...}. Серия без меток — просто имя метрики.
+package series
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Key — имя метрики и набор меток серии.
+type Key struct {
+	Name   string
+	Labels []Label
+}
+
+// Parse разбирает ключ серии. Метки в результате упорядочены по имени.
+func Parse(s string) (Key, error) {
+	open := strings.IndexByte(s, '{')
+	if open < 0 {
+		if s == "" {
+			return Key{}, fmt.Errorf("empty series name")
+		}
+		return Key{Name: s}, nil
+	}
+	if open == 0 {
+		return Key{}, fmt.Errorf("series %q: empty name", s)
+	}
+
+	k := Key{Name: s[:open]}
+	rest := s[open+1:]
+	for {
+		rest = strings.TrimLeft(rest, " ")
+		if strings.HasPrefix(rest, "}") {
+			rest = rest[1:]
+			break
+		}
+
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return Key{}, fmt.Errorf("series %q: label without value", s)
+		}
+		name := strings.TrimSpace(rest[:eq])
+		rest = strings.TrimLeft(rest[eq+1:], " ")
+		value, n, err := unquote(rest)
+		if err != nil {
+			return Key{}, fmt.Errorf("series %q: %w", s, err)
+		}
+		k.Labels = append(k.Labels, Label{Name: name, Value: value})
+
+		rest = strings.TrimLeft(rest[n:], " ")
+		switch {
+		case strings.HasPrefix(rest, ","):
+			rest = rest[1:]
+		case strings.HasPrefix(rest, "}"):
+		default:
+			return Key{}, fmt.Errorf("series %q: expected ',' or '}' after label %s", s, name)
+		}
+	}
+	if rest != "" {
+		return Key{}, fmt.Errorf("series %q: unexpected %q after labels", s, rest)
+	}
+
+	k.sortLabels()
+	for i := 1; i < len(k.Labels); i++ {
+		if k.Labels[i].Name == k.Labels[i-1].Name {
+			return Key{}, fmt.Errorf("series %q: duplicate label %s", s, k.Labels[i].Name)
+		}
+	}
+	return k, nil
+}
+
+// New собирает ключ из имени и пар имя-значение меток.
+func New(name string, labels map[string]string) Key {
+	k := Key{Name: name}
+	for n, v := range labels {
+		k.Labels = append(k.Labels, Label{Name: n, Value: v})
+	}
+	k.sortLabels()
+	return k
+}
+
+// String возвращает ключ в каноническом виде, пригодном для хранения.
+func (k Key) String() string {
+	if len(k.Labels) == 0 {
+		return k.Name
+	}
+	var sb strings.Builder
+	sb.WriteString(k.Name)
+	sb.WriteByte('{')
+	for i, l := range k.Labels {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(l.Name)
+		sb.WriteString(`="`)
+		sb.WriteString(EscapeValue(l.Value))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// Label возвращает значение метки name.
+func (k Key) Label(name string) (string, bool) {
+	for _, l := range k.Labels {
+		if l.Name == name {
+			return l.Value, true
+		}
+	}
+	return "", false
+}
+
+func (k *Key) sortLabels() {
+	sort.Slice(k.Labels, func(i, j int) bool { return k.Labels[i].Name < k.Labels[j].Name })
+}
+
+// EscapeValue экранирует значение метки для записи в кавычках.
+func EscapeValue(v string) string {
+	return valueEscaper.Replace(v)
+}
+
+var valueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// unquote читает строку в двойных кавычках в начале s и возвращает её
+// значение и число прочитанных байт.
+func unquote(s string) (string, int, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", 0, fmt.Errorf("label value must be quoted")
+	}
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return sb.String(), i + 1, nil
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", 0, fmt.Errorf("unterminated label value")
+			}
+			switch s[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			case '\\', '"':
+				sb.WriteByte(s[i])
+			default:
+				return "", 0, fmt.Errorf("invalid escape \\%c in label value", s[i])
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated label value")
+}
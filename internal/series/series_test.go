@@ -0,0 +1,53 @@
+package series
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name    string
+		key     string
+		want    Key
+		str     string
+		wantErr bool
+	}{
+		{name: "Parse() Test 1", key: "Alloc", want: Key{Name: "Alloc"}, str: "Alloc"},
+		{name: "Parse() Test 2", key: `http_requests{method="GET",code="200"}`,
+			want: Key{Name: "http_requests", Labels: []Label{{"code", "200"}, {"method", "GET"}}},
+			str:  `http_requests{code="200",method="GET"}`},
+		{name: "Parse() Test 3", key: `up{ job = "a\"b\\c\nd", }`,
+			want: Key{Name: "up", Labels: []Label{{"job", "a\"b\\c\nd"}}},
+			str:  `up{job="a\"b\\c\nd"}`},
+		{name: "Parse() Test 4", key: `up{}`, want: Key{Name: "up"}, str: "up"},
+		{name: "Parse() Test 5", key: `up{job=a}`, wantErr: true},
+		{name: "Parse() Test 6", key: `up{job="a"`, wantErr: true},
+		{name: "Parse() Test 7", key: `up{job="a",job="b"}`, wantErr: true},
+		{name: "Parse() Test 8", key: `{job="a"}`, wantErr: true},
+		{name: "Parse() Test 9", key: `up{job="a"}x`, wantErr: true},
+		{name: "Parse() Test 10", key: "", wantErr: true},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			k, err := Parse(test.key)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, k)
+			assert.Equal(t, test.str, k.String())
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	k := New("cpu", map[string]string{"host": "a", "core": "1"})
+	assert.Equal(t, `cpu{core="1",host="a"}`, k.String())
+	v, ok := k.Label("host")
+	assert.True(t, ok)
+	assert.Equal(t, "a", v)
+}
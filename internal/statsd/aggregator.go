@@ -0,0 +1,237 @@
+package statsd
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/amidvn/go-metrics/internal/models"
+	"github.com/amidvn/go-metrics/internal/series"
+	"github.com/amidvn/go-metrics/internal/storage"
+)
+
+// Quantiles — квантили таймеров, которые сохраняются при сбросе.
+var Quantiles = []float64{0.5, 0.9, 0.99}
+
+type gaugeState struct {
+	value float64
+	// set — значение было задано явно, а не только изменено
+	set bool
+}
+
+type timerState struct {
+	values []float64
+	count  float64
+}
+
+// Aggregator накапливает значения между сбросами в хранилище.
+//
+// Счётчики складываются с учётом доли отправленных значений; дробный
+// остаток переносится в следующий интервал. Относительные изменения
+// gauge прибавляются к значению в хранилище через AddGauge. Таймер сохраняется как
+// счётчик name_count и gauge name_min, name_max, name_mean и
+// name{quantile="..."}. Для множества сохраняется число разных
+// элементов за интервал.
+type Aggregator struct {
+	mu       sync.Mutex
+	counters map[string]float64
+	gauges   map[string]gaugeState
+	timers   map[string]*timerState
+	sets     map[string]map[string]struct{}
+}
+
+func NewAggregator() *Aggregator {
+	return &Aggregator{
+		counters: make(map[string]float64),
+		gauges:   make(map[string]gaugeState),
+		timers:   make(map[string]*timerState),
+		sets:     make(map[string]map[string]struct{}),
+	}
+}
+
+func (a *Aggregator) Add(m Metric) {
+	key := m.Key.String()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch m.Type {
+	case Counter:
+		a.counters[key] += m.Value / m.SampleRate
+	case Gauge:
+		g := a.gauges[key]
+		if m.Relative {
+			g.value += m.Value
+		} else {
+			g = gaugeState{value: m.Value, set: true}
+		}
+		a.gauges[key] = g
+	case Timer:
+		t, ok := a.timers[key]
+		if !ok {
+			t = &timerState{}
+			a.timers[key] = t
+		}
+		t.values = append(t.values, m.Value)
+		t.count += 1 / m.SampleRate
+	case Set:
+		members, ok := a.sets[key]
+		if !ok {
+			members = make(map[string]struct{})
+			a.sets[key] = members
+		}
+		members[m.Member] = struct{}{}
+	}
+}
+
+// Flush записывает накопленное за интервал в хранилище: заданные
+// значения одним пакетом, затем относительные изменения gauge. Если
+// записать не удалось, несохранённые значения возвращаются в следующий
+// интервал.
+func (a *Aggregator) Flush(ctx context.Context, s storage.Storage) error {
+	a.mu.Lock()
+	counters := make(map[string]int64)
+	for key, v := range a.counters {
+		whole := math.Trunc(v)
+		if whole != 0 {
+			counters[key] = int64(whole)
+		}
+		if rest := v - whole; rest != 0 {
+			a.counters[key] = rest
+		} else {
+			delete(a.counters, key)
+		}
+	}
+	gauges, timers, sets := a.gauges, a.timers, a.sets
+	a.gauges = make(map[string]gaugeState)
+	a.timers = make(map[string]*timerState)
+	a.sets = make(map[string]map[string]struct{})
+	a.mu.Unlock()
+
+	var metrics []models.Metrics
+	addGauge := func(key string, v float64) {
+		metrics = append(metrics, models.Metrics{ID: key, MType: "gauge", Value: &v})
+	}
+	addCounter := func(key string, v int64) {
+		metrics = append(metrics, models.Metrics{ID: key, MType: "counter", Delta: &v})
+	}
+
+	for key, v := range counters {
+		addCounter(key, v)
+	}
+	// относительные изменения нельзя записать как значение: за время
+	// сброса gauge мог измениться в хранилище
+	relative := make(map[string]gaugeState)
+	for key, g := range gauges {
+		if g.set {
+			addGauge(key, g.value)
+		} else {
+			relative[key] = g
+		}
+	}
+	for key, t := range timers {
+		k, err := series.Parse(key)
+		if err != nil {
+			continue
+		}
+		sort.Float64s(t.values)
+		var sum float64
+		for _, v := range t.values {
+			sum += v
+		}
+		if count := int64(math.Round(t.count)); count != 0 {
+			addCounter(suffixed(k, "_count"), count)
+		}
+		addGauge(suffixed(k, "_min"), t.values[0])
+		addGauge(suffixed(k, "_max"), t.values[len(t.values)-1])
+		addGauge(suffixed(k, "_mean"), sum/float64(len(t.values)))
+		for _, q := range Quantiles {
+			labels := labelMap(k.Labels)
+			labels["quantile"] = strconv.FormatFloat(q, 'f', -1, 64)
+			addGauge(series.New(k.Name, labels).String(), quantile(t.values, q))
+		}
+	}
+	for key, members := range sets {
+		addGauge(key, float64(len(members)))
+	}
+
+	if len(metrics) != 0 {
+		if err := s.StoreBatch(ctx, metrics); err != nil {
+			a.requeue(counters, gauges, timers, sets)
+			return err
+		}
+	}
+	for key, g := range relative {
+		if err := s.AddGauge(ctx, key, g.value); err != nil {
+			a.requeue(nil, relative, nil, nil)
+			return err
+		}
+		delete(relative, key)
+	}
+	return nil
+}
+
+// requeue возвращает несохранённые значения интервала в накопитель.
+// Заданное явно значение gauge, пришедшее после сброса, важнее
+// несохранённого.
+func (a *Aggregator) requeue(counters map[string]int64, gauges map[string]gaugeState, timers map[string]*timerState, sets map[string]map[string]struct{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for key, v := range counters {
+		a.counters[key] += float64(v)
+	}
+	for key, g := range gauges {
+		newer, ok := a.gauges[key]
+		switch {
+		case !ok:
+			a.gauges[key] = g
+		case !newer.set:
+			a.gauges[key] = gaugeState{value: g.value + newer.value, set: g.set}
+		}
+	}
+	for key, t := range timers {
+		newer, ok := a.timers[key]
+		if !ok {
+			a.timers[key] = t
+			continue
+		}
+		newer.values = append(t.values, newer.values...)
+		newer.count += t.count
+	}
+	for key, members := range sets {
+		newer, ok := a.sets[key]
+		if !ok {
+			a.sets[key] = members
+			continue
+		}
+		for member := range members {
+			newer[member] = struct{}{}
+		}
+	}
+}
+
+func suffixed(k series.Key, suffix string) string {
+	k.Name += suffix
+	return k.String()
+}
+
+func labelMap(labels []series.Label) map[string]string {
+	m := make(map[string]string, len(labels))
+	for _, l := range labels {
+		m[l.Name] = l.Value
+	}
+	return m
+}
+
+// quantile возвращает квантиль упорядоченных значений методом
+// ближайшего ранга.
+func quantile(sorted []float64, q float64) float64 {
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
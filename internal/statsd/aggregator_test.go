@@ -0,0 +1,149 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/amidvn/go-metrics/internal/models"
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregatorFlush(t *testing.T) {
+	ctx := context.Background()
+	s := storage.New(0, "", false)
+	require.NoError(t, s.UpdateGauge(ctx, "queue", 10))
+
+	a := NewAggregator()
+	for _, line := range []string{
+		"hits:1|c|@0.4", "hits:1|c",
+		"queue:+5|g", "queue:-3|g",
+		"temp:20|g", "temp:+1|g",
+		"load:10|ms", "load:30|ms", "load:20|ms|@0.5",
+		"users:a|s", "users:b|s", "users:a|s",
+	} {
+		m, err := Parse(line)
+		require.NoError(t, err)
+		a.Add(m)
+	}
+	require.NoError(t, a.Flush(ctx, s))
+
+	counters, err := s.Counters(ctx)
+	require.NoError(t, err)
+	gauges, err := s.Gauges(ctx)
+	require.NoError(t, err)
+
+	// 1/0.4 + 1 = 3.5: половина переходит в следующий интервал
+	assert.Equal(t, int64(3), counters["hits"])
+	assert.Equal(t, int64(4), counters["load_count"])
+	assert.Equal(t, 12.0, gauges["queue"])
+	assert.Equal(t, 21.0, gauges["temp"])
+	assert.Equal(t, 10.0, gauges["load_min"])
+	assert.Equal(t, 30.0, gauges["load_max"])
+	assert.Equal(t, 20.0, gauges["load_mean"])
+	assert.Equal(t, 20.0, gauges[`load{quantile="0.5"}`])
+	assert.Equal(t, 30.0, gauges[`load{quantile="0.99"}`])
+	assert.Equal(t, 2.0, gauges["users"])
+
+	m, err := Parse("hits:1|c|@0.4")
+	require.NoError(t, err)
+	a.Add(m)
+	require.NoError(t, a.Flush(ctx, s))
+	counters, err = s.Counters(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), counters["hits"])
+}
+
+// failingStorage не сохраняет пакеты, пока fail равно true, и
+// относительные изменения gauge, пока failAdd равно true.
+type failingStorage struct {
+	*storage.MemStorage
+	fail    bool
+	failAdd bool
+}
+
+func (s *failingStorage) AddGauge(ctx context.Context, n string, v float64) error {
+	if s.failAdd {
+		return errors.New("storage is unavailable")
+	}
+	return s.MemStorage.AddGauge(ctx, n, v)
+}
+
+func (s *failingStorage) StoreBatch(ctx context.Context, metrics []models.Metrics) error {
+	if s.fail {
+		return errors.New("storage is unavailable")
+	}
+	return s.MemStorage.StoreBatch(ctx, metrics)
+}
+
+func TestAggregatorFlushFailure(t *testing.T) {
+	ctx := context.Background()
+	s := &failingStorage{MemStorage: storage.New(0, "", false), fail: true}
+
+	a := NewAggregator()
+	add := func(lines ...string) {
+		for _, line := range lines {
+			m, err := Parse(line)
+			require.NoError(t, err)
+			a.Add(m)
+		}
+	}
+	add("hits:2|c", "load:10|ms", "queue:+5|g", "temp:+1|g", "users:a|s", "users:b|s")
+	assert.Error(t, a.Flush(ctx, s))
+
+	// после неудачного сброса приходят новые значения
+	add("hits:3|c", "load:30|ms", "queue:+1|g", "temp:20|g", "users:b|s", "users:c|s")
+	s.fail = false
+	require.NoError(t, a.Flush(ctx, s))
+
+	counters, err := s.Counters(ctx)
+	require.NoError(t, err)
+	gauges, err := s.Gauges(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), counters["hits"])
+	assert.Equal(t, int64(2), counters["load_count"])
+	assert.Equal(t, 20.0, gauges["load_mean"])
+	assert.Equal(t, 6.0, gauges["queue"])
+	assert.Equal(t, 20.0, gauges["temp"])
+	// уникальные значения обоих интервалов считаются вместе
+	assert.Equal(t, 3.0, gauges["users"])
+}
+
+func TestAggregatorFlushAddFailure(t *testing.T) {
+	ctx := context.Background()
+	s := &failingStorage{MemStorage: storage.New(0, "", false), failAdd: true}
+	require.NoError(t, s.UpdateGauge(ctx, "queue", 10))
+
+	a := NewAggregator()
+	for _, line := range []string{"hits:2|c", "queue:+5|g", "temp:20|g"} {
+		m, err := Parse(line)
+		require.NoError(t, err)
+		a.Add(m)
+	}
+	assert.Error(t, a.Flush(ctx, s))
+
+	// пакет записан, относительное изменение ждёт следующего сброса
+	counters, err := s.Counters(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), counters["hits"])
+	gauges, err := s.Gauges(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 10.0, gauges["queue"])
+	assert.Equal(t, 20.0, gauges["temp"])
+
+	// изменение прибавляется к значению, записанному в хранилище
+	// между сбросами
+	require.NoError(t, s.UpdateGauge(ctx, "queue", 100))
+	s.failAdd = false
+	require.NoError(t, a.Flush(ctx, s))
+
+	counters, err = s.Counters(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), counters["hits"])
+	gauges, err = s.Gauges(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 105.0, gauges["queue"])
+	assert.Equal(t, 20.0, gauges["temp"])
+}
@@ -0,0 +1,112 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/storage"
+	"go.uber.org/zap"
+)
+
+// максимальный размер датаграммы UDP
+const maxPacketSize = 64 * 1024
+
+// Server принимает пакеты StatsD по UDP и раз в интервал сбрасывает
+// накопленные значения в хранилище.
+type Server struct {
+	aggregator    *Aggregator
+	storage       storage.Storage
+	flushInterval time.Duration
+	logger        *zap.SugaredLogger
+
+	conn net.PacketConn
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewServer(s storage.Storage, flushInterval time.Duration, logger *zap.SugaredLogger) *Server {
+	return &Server{
+		aggregator:    NewAggregator(),
+		storage:       s,
+		flushInterval: flushInterval,
+		logger:        logger,
+		stop:          make(chan struct{}),
+	}
+}
+
+// Listen открывает UDP-порт и начинает принимать пакеты.
+func (s *Server) Listen(address string) error {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+
+	s.wg.Add(2)
+	go s.read()
+	go s.flushLoop()
+	return nil
+}
+
+// Addr возвращает адрес, на котором принимаются пакеты.
+func (s *Server) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Shutdown прекращает приём пакетов и сбрасывает накопленные значения.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.conn.Close()
+	close(s.stop)
+	s.wg.Wait()
+
+	if ferr := s.aggregator.Flush(ctx, s.storage); ferr != nil {
+		return ferr
+	}
+	return err
+}
+
+func (s *Server) read() {
+	defer s.wg.Done()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.logger.Errorw("statsd read failed", "error", err)
+			}
+			return
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			m, err := Parse(line)
+			if err != nil {
+				s.logger.Debugw("statsd line rejected", "remote", addr.String(), "error", err)
+				continue
+			}
+			s.aggregator.Add(m)
+		}
+	}
+}
+
+func (s *Server) flushLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.aggregator.Flush(context.Background(), s.storage); err != nil {
+				s.logger.Errorw("statsd flush failed", "error", err)
+			}
+		}
+	}
+}
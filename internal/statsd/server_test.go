@@ -0,0 +1,40 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/amidvn/go-metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	s := storage.New(0, "", false)
+	srv := NewServer(s, time.Hour, zap.NewNop().Sugar())
+	require.NoError(t, srv.Listen("127.0.0.1:0"))
+
+	conn, err := net.Dial("udp", srv.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("hits:2|c\nbroken\nqueue:4|g\n"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		srv.aggregator.mu.Lock()
+		defer srv.aggregator.mu.Unlock()
+		return len(srv.aggregator.gauges) == 1
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, srv.Shutdown(ctx))
+
+	c, err := s.GetCounterValue(ctx, "hits")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), c)
+	g, err := s.GetGaugeValue(ctx, "queue")
+	require.NoError(t, err)
+	assert.Equal(t, 4.0, g)
+}
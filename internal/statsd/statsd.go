@@ -0,0 +1,114 @@
+// Package statsd принимает метрики по протоколу StatsD:
+//
+//	name:value|type[|@sample_rate][|#tag:value,...]
+//
+// Поддерживаются счётчики (c), gauge (g) с относительными изменениями,
+// таймеры (ms, h) и множества (s). Метки передаются в расширении
+// DogStatsD после #.
+package statsd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/amidvn/go-metrics/internal/series"
+)
+
+type Type int
+
+const (
+	Counter Type = iota
+	Gauge
+	Timer
+	Set
+)
+
+// Metric — одно значение из пакета StatsD.
+type Metric struct {
+	Key  series.Key
+	Type Type
+	// Value — число для счётчиков, gauge и таймеров
+	Value float64
+	// Relative — значение gauge со знаком, которое прибавляется
+	// к текущему, а не заменяет его
+	Relative bool
+	// Member — элемент множества
+	Member string
+	// SampleRate — доля отправленных значений, от 0 до 1
+	SampleRate float64
+}
+
+// Parse разбирает одну строку пакета. Точки в имени заменяются на '_'.
+func Parse(line string) (Metric, error) {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return Metric{}, fmt.Errorf("invalid line %q: expected name:value|type", line)
+	}
+
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return Metric{}, fmt.Errorf("invalid line %q: missing type", line)
+	}
+	value := parts[0]
+	m := Metric{SampleRate: 1}
+
+	switch parts[1] {
+	case "c":
+		m.Type = Counter
+	case "g":
+		m.Type = Gauge
+	case "ms", "h":
+		m.Type = Timer
+	case "s":
+		m.Type = Set
+	default:
+		return Metric{}, fmt.Errorf("invalid type %q", parts[1])
+	}
+
+	tags := make(map[string]string)
+	for _, p := range parts[2:] {
+		switch {
+		case strings.HasPrefix(p, "@"):
+			rate, err := strconv.ParseFloat(p[1:], 64)
+			if err != nil || math.IsNaN(rate) || rate <= 0 || rate > 1 {
+				return Metric{}, fmt.Errorf("invalid sample rate %q", p)
+			}
+			m.SampleRate = rate
+		case strings.HasPrefix(p, "#"):
+			for _, tag := range strings.Split(p[1:], ",") {
+				k, v, _ := strings.Cut(tag, ":")
+				if k == "" {
+					return Metric{}, fmt.Errorf("invalid tag %q", tag)
+				}
+				tags[k] = v
+			}
+		default:
+			return Metric{}, fmt.Errorf("invalid section %q", p)
+		}
+	}
+	m.Key = series.New(strings.ReplaceAll(name, ".", "_"), tags)
+
+	if m.Type == Set {
+		if value == "" {
+			return Metric{}, fmt.Errorf("empty set member")
+		}
+		m.Member = value
+		return m, nil
+	}
+
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return Metric{}, fmt.Errorf("invalid value %q", value)
+	}
+	// приращение с учётом доли должно помещаться в int64 счётчика
+	if m.Type == Counter && math.Abs(v/m.SampleRate) >= math.MaxInt64 {
+		return Metric{}, fmt.Errorf("counter value %q is out of range", value)
+	}
+	m.Value = v
+	if m.Type == Gauge && (strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-")) {
+		m.Relative = true
+	}
+	return m, nil
+}
@@ -0,0 +1,48 @@
+package statsd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name    string
+		line    string
+		want    Metric
+		wantKey string
+		wantErr bool
+	}{
+		{name: "Parse() Test 1", line: "app.requests:3|c|@0.5", wantKey: "app_requests", want: Metric{Type: Counter, Value: 3, SampleRate: 0.5}},
+		{name: "Parse() Test 2", line: "queue:-2|g|#env:prod,host:a", wantKey: `queue{env="prod",host="a"}`, want: Metric{Type: Gauge, Value: -2, Relative: true, SampleRate: 1}},
+		{name: "Parse() Test 3", line: "queue:7|g", wantKey: "queue", want: Metric{Type: Gauge, Value: 7, SampleRate: 1}},
+		{name: "Parse() Test 4", line: "db.query:12.5|ms", wantKey: "db_query", want: Metric{Type: Timer, Value: 12.5, SampleRate: 1}},
+		{name: "Parse() Test 5", line: "users:alice|s", wantKey: "users", want: Metric{Type: Set, Member: "alice", SampleRate: 1}},
+		{name: "Parse() Test 6", line: "requests", wantErr: true},
+		{name: "Parse() Test 7", line: "requests:1", wantErr: true},
+		{name: "Parse() Test 8", line: "requests:1|x", wantErr: true},
+		{name: "Parse() Test 9", line: "requests:one|c", wantErr: true},
+		{name: "Parse() Test 10", line: "requests:1|c|@2", wantErr: true},
+		{name: "Parse() Test 11", line: "users:|s", wantErr: true},
+		{name: "Parse() Test 12", line: "requests:1|c|@NaN", wantErr: true},
+		{name: "Parse() Test 13", line: "requests:NaN|c", wantErr: true},
+		{name: "Parse() Test 14", line: "queue:+Inf|g", wantErr: true},
+		{name: "Parse() Test 15", line: "db.query:-Inf|ms", wantErr: true},
+		{name: "Parse() Test 16", line: "requests:1|c|@1e-300", wantErr: true},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := Parse(test.line)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.wantKey, m.Key.String())
+			m.Key = test.want.Key
+			assert.Equal(t, test.want, m)
+		})
+	}
+}
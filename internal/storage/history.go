@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Sample — значение серии в определённый момент времени.
+type Sample struct {
+	Name      string
+	MType     string
+	Timestamp time.Time
+	Value     float64
+}
+
+// History хранит историю значений серий.
+type History interface {
+	Append(ctx context.Context, samples ...Sample) error
+	Range(ctx context.Context, name string, from, to time.Time) ([]Sample, error)
+}
+
+// Recorder дописывает обновления серий в историю. Встраивается
+// в реализации Storage.
+type Recorder struct {
+	history History
+}
+
+// SetHistory включает запись истории: каждое обновление метрики
+// будет дописываться в h.
+func (r *Recorder) SetHistory(h History) {
+	r.history = h
+}
+
+func (r *Recorder) Record(ctx context.Context, t string, n string, v float64) {
+	if r.history == nil {
+		return
+	}
+	sample := Sample{Name: n, MType: t, Timestamp: time.Now(), Value: v}
+	if err := r.history.Append(ctx, sample); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// RecordSamples дописывает в историю сразу несколько значений.
+// Значениям без метки времени проставляется текущее время.
+func (r *Recorder) RecordSamples(ctx context.Context, samples []Sample) {
+	if r.history == nil || len(samples) == 0 {
+		return
+	}
+	now := time.Now()
+	for i := range samples {
+		if samples[i].Timestamp.IsZero() {
+			samples[i].Timestamp = now
+		}
+	}
+	if err := r.history.Append(ctx, samples...); err != nil {
+		fmt.Println(err)
+	}
+}
@@ -1,18 +1,67 @@
 package storage
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"net/http"
+	"math"
+	"sync"
+	"time"
 
 	"github.com/amidvn/go-metrics/internal/models"
 )
 
+var ErrNotFound = errors.New("metric not found")
+
+// ErrNegativeCounter — накопленное значение счётчика меньше нуля.
+var ErrNegativeCounter = errors.New("negative counter value")
+
+// Storage — хранилище метрик, с которым работают обработчики запросов.
+type Storage interface {
+	UpdateCounter(ctx context.Context, n string, v int64) error
+	UpdateGauge(ctx context.Context, n string, v float64) error
+	// RaiseCounter атомарно поднимает счётчик до v, если он меньше v.
+	// Так сохраняются накопленные значения из источника: повторная или
+	// параллельная запись одного значения не прибавляется дважды.
+	// Отрицательное v отклоняется с ErrNegativeCounter.
+	RaiseCounter(ctx context.Context, n string, v int64) error
+	// AddGauge атомарно прибавляет v к значению gauge; отсутствующий
+	// gauge считается равным нулю.
+	AddGauge(ctx context.Context, n string, v float64) error
+	GetCounterValue(ctx context.Context, id string) (int64, error)
+	GetGaugeValue(ctx context.Context, id string) (float64, error)
+	Counters(ctx context.Context) (map[string]int64, error)
+	Gauges(ctx context.Context) (map[string]float64, error)
+	StoreBatch(ctx context.Context, metrics []models.Metrics) error
+}
+
+// Notifier получает изменения метрик, сделанные через хранилище:
+// приращение счётчика в Delta или новое значение gauge в Value.
+type Notifier interface {
+	Notify(ctx context.Context, m models.Metrics)
+}
+
 type gauge float64
 type counter int64
 
 type MemStorage struct {
+	Recorder
+	notifier Notifier
+
+	mu          sync.RWMutex
 	gaugeData   map[string]gauge
 	counterData map[string]counter
+
+	// серии, изменённые с момента последнего сохранения
+	changedGauges   map[string]struct{}
+	changedCounters map[string]struct{}
+
+	// когда счётчик впервые обновился в этом процессе
+	counterCreated map[string]time.Time
+
+	// время последнего успешного сохранения и ошибка последней попытки
+	lastDump time.Time
+	dumpErr  error
 }
 
 type AllMetrics struct {
@@ -22,80 +71,353 @@ type AllMetrics struct {
 
 func New(storeInterval int, filePath string, restore bool) *MemStorage {
 	storage := MemStorage{
-		gaugeData:   make(map[string]gauge),
-		counterData: make(map[string]counter),
+		gaugeData:       make(map[string]gauge),
+		counterData:     make(map[string]counter),
+		counterCreated:  make(map[string]time.Time),
+		changedGauges:   make(map[string]struct{}),
+		changedCounters: make(map[string]struct{}),
 	}
 
 	return &storage
 }
 
-func (s *MemStorage) UpdateCounter(n string, v int64) {
+func (s *MemStorage) UpdateCounter(ctx context.Context, n string, v int64) error {
+	total := s.updateCounter(ctx, n, v)
+	s.Record(ctx, "counter", n, float64(total))
+	return nil
+}
+
+func (s *MemStorage) UpdateGauge(ctx context.Context, n string, v float64) error {
+	s.updateGauge(ctx, n, v)
+	s.Record(ctx, "gauge", n, v)
+	return nil
+}
+
+// updateCounter прибавляет v к счётчику и возвращает новое значение.
+// В историю значение не записывается.
+func (s *MemStorage) updateCounter(ctx context.Context, n string, v int64) int64 {
+	s.mu.Lock()
 	s.counterData[n] += counter(v)
+	s.changedCounters[n] = struct{}{}
+	s.markCreated(n)
+	total := s.counterData[n]
+	s.mu.Unlock()
+
+	if s.notifier != nil {
+		s.notifier.Notify(ctx, models.Metrics{ID: n, MType: "counter", Delta: &v})
+	}
+	return int64(total)
 }
 
-func (s *MemStorage) UpdateGauge(n string, v float64) {
+func (s *MemStorage) updateGauge(ctx context.Context, n string, v float64) {
+	s.mu.Lock()
 	s.gaugeData[n] = gauge(v)
+	s.changedGauges[n] = struct{}{}
+	s.mu.Unlock()
+
+	if s.notifier != nil {
+		s.notifier.Notify(ctx, models.Metrics{ID: n, MType: "gauge", Value: &v})
+	}
+}
+
+// Ping всегда успешен: данные хранятся в памяти процесса.
+func (s *MemStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *MemStorage) RaiseCounter(ctx context.Context, n string, v int64) error {
+	if v < 0 {
+		return fmt.Errorf("%s: %w", n, ErrNegativeCounter)
+	}
+	s.mu.Lock()
+	current, ok := s.counterData[n]
+	if ok && int64(current) >= v {
+		s.mu.Unlock()
+		return nil
+	}
+	delta := v - int64(current)
+	s.counterData[n] = counter(v)
+	s.changedCounters[n] = struct{}{}
+	s.markCreated(n)
+	s.mu.Unlock()
+
+	s.Record(ctx, "counter", n, float64(v))
+	if s.notifier != nil {
+		s.notifier.Notify(ctx, models.Metrics{ID: n, MType: "counter", Delta: &delta})
+	}
+	return nil
 }
 
-func (s *MemStorage) GetValue(t string, n string) (string, int) {
-	var v string
-	statusCode := http.StatusOK
-	if val, ok := s.gaugeData[n]; ok && t == "gauge" {
-		v = fmt.Sprint(val)
-	} else if val, ok := s.counterData[n]; ok && t == "counter" {
-		v = fmt.Sprint(val)
-	} else {
-		statusCode = http.StatusNotFound
+func (s *MemStorage) AddGauge(ctx context.Context, n string, v float64) error {
+	s.mu.Lock()
+	s.gaugeData[n] += gauge(v)
+	s.changedGauges[n] = struct{}{}
+	value := float64(s.gaugeData[n])
+	s.mu.Unlock()
+
+	s.Record(ctx, "gauge", n, value)
+	if s.notifier != nil {
+		s.notifier.Notify(ctx, models.Metrics{ID: n, MType: "gauge", Value: &value})
 	}
-	return v, statusCode
+	return nil
 }
 
-func (s *MemStorage) GetCounterValue(id string) int64 {
-	return int64(s.counterData[id])
+// SetNotifier задаёт получателя изменений, сделанных через UpdateCounter
+// и UpdateGauge.
+func (s *MemStorage) SetNotifier(n Notifier) {
+	s.notifier = n
 }
 
-func (s *MemStorage) GetGaugeValue(id string) float64 {
-	return float64(s.gaugeData[id])
+// ApplyCounter прибавляет к счётчику приращение, сделанное другим
+// сервером. В отличие от UpdateCounter изменение не отправляется
+// получателю изменений, не помечается для сохранения и не задаёт время
+// появления счётчика: счётчик создан не этим процессом.
+func (s *MemStorage) ApplyCounter(ctx context.Context, n string, v int64) {
+	s.mu.Lock()
+	s.counterData[n] += counter(v)
+	total := s.counterData[n]
+	s.mu.Unlock()
+
+	s.Record(ctx, "counter", n, float64(total))
 }
 
-func (s *MemStorage) AllMetrics() string {
-	var result string
-	result += "Gauge metrics:\n"
-	for n, v := range s.gaugeData {
-		result += fmt.Sprintf("- %s = %f\n", n, v)
+// ApplyGauge устанавливает значение gauge, полученное от другого сервера,
+// так же не помечая его для сохранения.
+func (s *MemStorage) ApplyGauge(ctx context.Context, n string, v float64) {
+	s.mu.Lock()
+	s.gaugeData[n] = gauge(v)
+	s.mu.Unlock()
+
+	s.Record(ctx, "gauge", n, v)
+}
+
+func (s *MemStorage) GetCounterValue(ctx context.Context, id string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, ok := s.counterData[id]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return int64(v), nil
+}
+
+func (s *MemStorage) GetGaugeValue(ctx context.Context, id string) (float64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, ok := s.gaugeData[id]
+	if !ok {
+		return 0, ErrNotFound
 	}
+	return float64(v), nil
+}
+
+func (s *MemStorage) Counters(ctx context.Context) (map[string]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	result += "Counter metrics:\n"
+	result := make(map[string]int64, len(s.counterData))
 	for n, v := range s.counterData {
-		result += fmt.Sprintf("- %s = %d\n", n, v)
+		result[n] = int64(v)
+	}
+	return result, nil
+}
+
+func (s *MemStorage) Gauges(ctx context.Context) (map[string]float64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[string]float64, len(s.gaugeData))
+	for n, v := range s.gaugeData {
+		result[n] = float64(v)
+	}
+	return result, nil
+}
+
+// markCreated запоминает время появления счётчика. Вызывается под s.mu.
+func (s *MemStorage) markCreated(n string) {
+	if _, ok := s.counterCreated[n]; !ok {
+		s.counterCreated[n] = time.Now()
 	}
+}
+
+// CountersCreated возвращает время появления счётчиков. Для счётчиков,
+// загруженных из снапшота целиком, оно неизвестно.
+func (s *MemStorage) CountersCreated(ctx context.Context) map[string]time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
+	result := make(map[string]time.Time, len(s.counterCreated))
+	for n, t := range s.counterCreated {
+		result[n] = t
+	}
 	return result
 }
 
+// GetCounterData возвращает копию всех счётчиков.
 func (s *MemStorage) GetCounterData() map[string]counter {
-	return s.counterData
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[string]counter, len(s.counterData))
+	for n, v := range s.counterData {
+		result[n] = v
+	}
+	return result
 }
 
+// GetGaugeData возвращает копию всех значений gauge.
 func (s *MemStorage) GetGaugeData() map[string]gauge {
-	return s.gaugeData
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[string]gauge, len(s.gaugeData))
+	for n, v := range s.gaugeData {
+		result[n] = v
+	}
+	return result
 }
 
 func (s *MemStorage) UpdateGaugeData(gaugeData map[string]gauge) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	s.gaugeData = gaugeData
+	for n := range gaugeData {
+		s.changedGauges[n] = struct{}{}
+	}
 }
 
 func (s *MemStorage) UpdateCounterData(counterData map[string]counter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	s.counterData = counterData
+	for n := range counterData {
+		s.changedCounters[n] = struct{}{}
+	}
+}
+
+// Load добавляет серии, загруженные из сохранённого состояния. В отличие
+// от UpdateCounter и UpdateGauge серии не помечаются изменёнными, время
+// появления счётчиков не запоминается, а изменения не записываются
+// в историю и не передаются получателю изменений.
+func (s *MemStorage) Load(counters map[string]int64, gauges map[string]float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for n, v := range counters {
+		s.counterData[n] = counter(v)
+	}
+	for n, v := range gauges {
+		s.gaugeData[n] = gauge(v)
+	}
+}
+
+// TakeChanges возвращает текущие значения серий, изменённых с прошлого
+// вызова, и сбрасывает отметки об изменениях.
+func (s *MemStorage) TakeChanges() (map[string]int64, map[string]float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counters := make(map[string]int64, len(s.changedCounters))
+	for n := range s.changedCounters {
+		counters[n] = int64(s.counterData[n])
+	}
+	gauges := make(map[string]float64, len(s.changedGauges))
+	for n := range s.changedGauges {
+		gauges[n] = float64(s.gaugeData[n])
+	}
+
+	s.changedCounters = make(map[string]struct{})
+	s.changedGauges = make(map[string]struct{})
+	return counters, gauges
+}
+
+// RequeueChanges снова помечает серии изменёнными, например после
+// неудачной попытки их сохранить.
+func (s *MemStorage) RequeueChanges(counters map[string]int64, gauges map[string]float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for n := range counters {
+		s.changedCounters[n] = struct{}{}
+	}
+	for n := range gauges {
+		s.changedGauges[n] = struct{}{}
+	}
+}
+
+// SetDumpResult запоминает результат очередного сохранения хранилища
+// в файл или базу данных.
+func (s *MemStorage) SetDumpResult(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dumpErr = err
+	if err == nil {
+		s.lastDump = time.Now()
+	}
 }
 
-func (s *MemStorage) StoreBatch(metrics []models.Metrics) {
+// DumpStatus возвращает время последнего успешного сохранения и ошибку
+// последней попытки.
+func (s *MemStorage) DumpStatus() (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.lastDump, s.dumpErr
+}
+
+func (s *MemStorage) StoreBatch(ctx context.Context, metrics []models.Metrics) error {
+	if err := ValidateBatch(metrics); err != nil {
+		return err
+	}
+	// история пакета пишется одним вызовом, чтобы хранилище истории
+	// могло сохранить её за один обмен
+	samples := make([]Sample, 0, len(metrics))
 	for _, m := range metrics {
 		switch m.MType {
 		case "counter":
-			s.UpdateCounter(m.ID, *m.Delta)
+			total := s.updateCounter(ctx, m.ID, *m.Delta)
+			samples = append(samples, Sample{Name: m.ID, MType: m.MType, Value: float64(total)})
 		case "gauge":
-			s.UpdateGauge(m.ID, *m.Value)
+			s.updateGauge(ctx, m.ID, *m.Value)
+			samples = append(samples, Sample{Name: m.ID, MType: m.MType, Value: *m.Value})
+		}
+	}
+	s.RecordSamples(ctx, samples)
+	return nil
+}
+
+// ValidateMetric проверяет, что у метрики есть имя, известный тип
+// и значение этого типа. Значение gauge должно быть конечным: NaN и
+// бесконечности не сохраняются в JSON, и с ними не работают снапшоты
+// и ответы API.
+func ValidateMetric(m models.Metrics) error {
+	switch {
+	case m.ID == "":
+		return fmt.Errorf("%s: id is missing", m.MType)
+	case m.MType == "counter" && m.Delta == nil:
+		return fmt.Errorf("counter %s: delta is missing", m.ID)
+	case m.MType == "gauge" && m.Value == nil:
+		return fmt.Errorf("gauge %s: value is missing", m.ID)
+	case m.MType == "gauge" && (math.IsNaN(*m.Value) || math.IsInf(*m.Value, 0)):
+		return fmt.Errorf("gauge %s: value %v is not finite", m.ID, *m.Value)
+	case m.MType != "counter" && m.MType != "gauge":
+		return fmt.Errorf("%s: invalid type %q, can only be 'gauge' or 'counter'", m.ID, m.MType)
+	}
+	return nil
+}
+
+// ValidateBatch проверяет каждую метрику пакета и возвращает первую
+// найденную ошибку.
+func ValidateBatch(metrics []models.Metrics) error {
+	for _, m := range metrics {
+		if err := ValidateMetric(m); err != nil {
+			return err
 		}
 	}
+	return nil
 }
@@ -1,9 +1,14 @@
 package storage
 
 import (
+	"context"
+	"math"
 	"testing"
+	"time"
 
+	"github.com/amidvn/go-metrics/internal/models"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestUpdateCounter(t *testing.T) {
@@ -22,12 +27,42 @@ func TestUpdateCounter(t *testing.T) {
 	}
 	for _, test := range testCases {
 		t.Run(test.name, func(t *testing.T) {
-			s.UpdateCounter(test.metricsName, test.value)
+			s.UpdateCounter(context.Background(), test.metricsName, test.value)
 			assert.Equal(t, counter(test.result), s.counterData[test.metricsName])
 		})
 	}
 }
 
+func TestRaiseCounter(t *testing.T) {
+	s := New(300, "", false)
+	testCases := []struct {
+		name        string
+		metricsName string
+		value       int64
+		result      int64
+	}{
+		{name: "RaiseCounter() Test 1", metricsName: "testCounter1", value: 10, result: 10},
+		{name: "RaiseCounter() Test 2", metricsName: "testCounter1", value: 10, result: 10},
+		{name: "RaiseCounter() Test 3", metricsName: "testCounter1", value: 4, result: 10},
+		{name: "RaiseCounter() Test 4", metricsName: "testCounter1", value: 15, result: 15},
+		{name: "RaiseCounter() Test 5", metricsName: "testCounter2", value: 0, result: 0},
+		{name: "RaiseCounter() Test 6", metricsName: "testCounter2", value: -5, result: 0},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			s.RaiseCounter(context.Background(), test.metricsName, test.value)
+			assert.Equal(t, counter(test.result), s.counterData[test.metricsName])
+		})
+	}
+	_, ok := s.counterData["testCounter2"]
+	assert.True(t, ok)
+
+	err := s.RaiseCounter(context.Background(), "testCounter3", -1)
+	assert.ErrorIs(t, err, ErrNegativeCounter)
+	_, ok = s.counterData["testCounter3"]
+	assert.False(t, ok)
+}
+
 func TestUpdateGauge(t *testing.T) {
 	s := New(300, "", false)
 	testCases := []struct {
@@ -43,8 +78,135 @@ func TestUpdateGauge(t *testing.T) {
 	}
 	for _, test := range testCases {
 		t.Run(test.name, func(t *testing.T) {
-			s.UpdateGauge(test.metricsName, test.value)
+			s.UpdateGauge(context.Background(), test.metricsName, test.value)
+			assert.Equal(t, gauge(test.result), s.gaugeData[test.metricsName])
+		})
+	}
+}
+
+func TestAddGauge(t *testing.T) {
+	s := New(300, "", false)
+	testCases := []struct {
+		name        string
+		metricsName string
+		value       float64
+		result      float64
+	}{
+		{name: "AddGauge() Test 1", metricsName: "testGauge1", value: 1.5, result: 1.5},
+		{name: "AddGauge() Test 2", metricsName: "testGauge1", value: -0.5, result: 1.0},
+		{name: "AddGauge() Test 3", metricsName: "testGauge2", value: 2, result: 2.0},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			s.AddGauge(context.Background(), test.metricsName, test.value)
 			assert.Equal(t, gauge(test.result), s.gaugeData[test.metricsName])
 		})
 	}
 }
+
+func TestTakeChanges(t *testing.T) {
+	s := New(300, "", false)
+	s.UpdateCounter(context.Background(), "testCounter1", 1)
+	s.UpdateCounter(context.Background(), "testCounter1", 2)
+	s.UpdateGauge(context.Background(), "testGauge1", 1.5)
+
+	counters, gauges := s.TakeChanges()
+	assert.Equal(t, map[string]int64{"testCounter1": 3}, counters)
+	assert.Equal(t, map[string]float64{"testGauge1": 1.5}, gauges)
+
+	counters, gauges = s.TakeChanges()
+	assert.Empty(t, counters)
+	assert.Empty(t, gauges)
+
+	s.UpdateGauge(context.Background(), "testGauge2", 2)
+	s.RequeueChanges(map[string]int64{"testCounter1": 3}, nil)
+	counters, gauges = s.TakeChanges()
+	assert.Equal(t, map[string]int64{"testCounter1": 3}, counters)
+	assert.Equal(t, map[string]float64{"testGauge2": 2}, gauges)
+}
+
+func TestLoad(t *testing.T) {
+	ctx := context.Background()
+	s := New(300, "", false)
+	s.UpdateCounter(ctx, "testCounter1", 1)
+	s.TakeChanges()
+	created := s.CountersCreated(ctx)
+
+	s.Load(map[string]int64{"testCounter2": 5}, map[string]float64{"testGauge1": 1.5})
+	v, err := s.GetCounterValue(ctx, "testCounter2")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), v)
+	g, err := s.GetGaugeValue(ctx, "testGauge1")
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, g)
+
+	// загруженные серии уже сохранены и появились не в этом процессе
+	counters, gauges := s.TakeChanges()
+	assert.Empty(t, counters)
+	assert.Empty(t, gauges)
+	assert.Equal(t, created, s.CountersCreated(ctx))
+}
+
+func TestValidateMetric(t *testing.T) {
+	delta, value := int64(1), 1.5
+	nan, inf := math.NaN(), math.Inf(-1)
+	testCases := []struct {
+		name    string
+		metric  models.Metrics
+		wantErr bool
+	}{
+		{name: "ValidateMetric() Test 1", metric: models.Metrics{ID: "c", MType: "counter", Delta: &delta}},
+		{name: "ValidateMetric() Test 2", metric: models.Metrics{ID: "g", MType: "gauge", Value: &value}},
+		{name: "ValidateMetric() Test 3", metric: models.Metrics{ID: "c", MType: "counter", Value: &value}, wantErr: true},
+		{name: "ValidateMetric() Test 4", metric: models.Metrics{ID: "g", MType: "gauge"}, wantErr: true},
+		{name: "ValidateMetric() Test 5", metric: models.Metrics{ID: "h", MType: "histogram", Value: &value}, wantErr: true},
+		{name: "ValidateMetric() Test 6", metric: models.Metrics{MType: "gauge", Value: &value}, wantErr: true},
+		{name: "ValidateMetric() Test 7", metric: models.Metrics{ID: "g", MType: "gauge", Value: &nan}, wantErr: true},
+		{name: "ValidateMetric() Test 8", metric: models.Metrics{ID: "g", MType: "gauge", Value: &inf}, wantErr: true},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateMetric(test.metric)
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// appendCounter запоминает вызовы Append.
+type appendCounter struct {
+	calls   int
+	samples []Sample
+}
+
+func (h *appendCounter) Append(ctx context.Context, samples ...Sample) error {
+	h.calls++
+	h.samples = append(h.samples, samples...)
+	return nil
+}
+
+func (h *appendCounter) Range(ctx context.Context, name string, from, to time.Time) ([]Sample, error) {
+	return nil, nil
+}
+
+func TestStoreBatchHistory(t *testing.T) {
+	s := New(300, "", false)
+	h := &appendCounter{}
+	s.SetHistory(h)
+
+	delta, value := int64(2), 1.5
+	require.NoError(t, s.UpdateCounter(context.Background(), "PollCount", 3))
+	require.NoError(t, s.StoreBatch(context.Background(), []models.Metrics{
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "Alloc", MType: "gauge", Value: &value},
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+	}))
+
+	// одно значение от UpdateCounter и один вызов на весь пакет
+	assert.Equal(t, 2, h.calls)
+	require.Len(t, h.samples, 4)
+	assert.Equal(t, []float64{3, 5, 1.5, 7}, []float64{h.samples[0].Value, h.samples[1].Value, h.samples[2].Value, h.samples[3].Value})
+}